@@ -1,45 +1,255 @@
 package main
 
 import (
+	"context"
+	"crypto/x509"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/user/distfs/internal/api"
+	"github.com/user/distfs/internal/config"
+	"github.com/user/distfs/internal/crypto"
 	"github.com/user/distfs/internal/fs"
+	"github.com/user/distfs/internal/jobs"
 	"github.com/user/distfs/internal/node"
 )
 
 func main() {
+	if err := run(); err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+// run starts the node and serves the HTTP API until SIGINT or SIGTERM,
+// then shuts everything down. Deferred cleanup (stopping the P2P network,
+// saving the node registry and file metadata) runs before it returns.
+func run() error {
 	// Parse command line flags
 	port := flag.Int("port", 8080, "Port to listen on for HTTP API")
 	p2pPort := flag.Int("p2p-port", 9000, "Port to listen on for P2P network")
+	listenAddr := flag.String("listen", "", "Address (host:port) to listen on for HTTP API, overrides --port")
+	p2pListenAddr := flag.String("p2p-listen", "", "Address (host:port) to listen on for P2P network, overrides --p2p-port")
 	dataDir := flag.String("data", "./data", "Data directory")
 	nodeID := flag.String("id", "", "Node ID (will be generated if empty)")
+	advertiseAddr := flag.String("advertise-addr", "", "HTTP API URL announced to peers so they register this node (e.g. http://10.0.0.5:8080), empty to not register")
+	storageMax := flag.Int64("storage-max", 0, "Storage capacity in bytes announced with --advertise-addr")
+	zone := flag.String("zone", "", "Failure domain (zone or rack) announced with --advertise-addr")
 	enableP2P := flag.Bool("p2p", true, "Enable P2P networking")
 	enableDiscovery := flag.Bool("discovery", true, "Enable automatic peer discovery")
 	peerList := flag.String("peers", "", "Comma-separated list of peers to connect to")
+	byteStrings := flag.Bool("json-byte-strings", false, "Encode byte counts as JSON strings to preserve precision above 2^53")
+	storageReserve := flag.String("storage-reserve", "", "Free space kept back on every node, in bytes or as a percentage of capacity (e.g. 10%)")
+	placement := flag.String("placement", "free-space", "Storage node placement strategy (free-space, round-robin, consistent-hash, label-aware)")
+	spreadZones := flag.Bool("spread-zones", false, "Place the replicas of a file in distinct zones before putting several in one zone")
+	readPref := flag.String("read-preference", "local-first", "Replica read preference (local-first, lowest-latency, round-robin)")
+	slowThreshold := flag.Duration("slow-request-threshold", time.Second, "Log requests slower than this (0 disables)")
+	noAutoMkdir := flag.Bool("no-auto-mkdir", false, "Reject uploads into missing directories instead of creating them")
+	maxMessageSize := flag.Int("max-message-size", 4*1024*1024, "Largest P2P message in bytes, peers sending larger messages are disconnected")
+	maxPeers := flag.Int("max-peers", 50, "Maximum number of connected P2P peers, inbound and outbound (0 for no limit)")
+	maxConnHandlers := flag.Int("max-conn-handlers", 100, "Maximum number of concurrently handled inbound P2P connections")
+	messageWorkers := flag.Int("message-workers", 16, "Number of workers serving P2P file requests")
+	pingTimeout := flag.Duration("ping-timeout", 30*time.Second, "Peers that send nothing for this long are disconnected, they are pinged every third of it")
+	peerRetention := flag.Duration("peer-retention", 10*time.Minute, "How long disconnected peers are kept before they are evicted")
+	reconnectMin := flag.Duration("reconnect-min", time.Second, "Delay before reconnecting to a lost --peers peer, doubled after every failed attempt")
+	reconnectMax := flag.Duration("reconnect-max", time.Minute, "Maximum delay between reconnect attempts")
+	breakerThreshold := flag.Int("breaker-threshold", 5, "Consecutive connect failures after which connects to a peer fast-fail")
+	breakerCooldown := flag.Duration("breaker-cooldown", 30*time.Second, "How long connects to a failing peer fast-fail before it is probed again")
+	maxDecompressed := flag.Int64("max-decompressed-size", 1<<30, "Maximum size in bytes of a gzip-encoded upload once decompressed")
+	authMode := flag.String("auth", "none", "API authentication (none, api-key, jwt)")
+	durability := flag.String("durability", "fast", "When writes are flushed to disk before they are acknowledged (fast, safe, batch)")
+	chunkStore := flag.String("chunk-store", "local", "Where chunks are stored (local, dedup, memory, s3)")
+	s3Endpoint := flag.String("s3-endpoint", "", "S3-compatible endpoint URL for --chunk-store=s3")
+	s3Bucket := flag.String("s3-bucket", "", "Bucket for --chunk-store=s3")
+	s3Region := flag.String("s3-region", "us-east-1", "Region for --chunk-store=s3")
+	s3Prefix := flag.String("s3-prefix", "chunks/", "Object key prefix for --chunk-store=s3")
+	copyBufferSize := flag.Int("copy-buffer-size", 0, "Buffer size in bytes for copying file content (0 uses the 32KB default)")
+	chunkCacheSize := flag.Int64("chunk-cache-size", 0, "Bytes of recently read chunks kept in memory (0 disables the cache)")
+	chunking := flag.String("chunking", "fixed", "How files are split into chunks (fixed, content)")
+	compressChunks := flag.Bool("compress-chunks", false, "Gzip-compress chunks before storing them")
+	watchFiles := flag.Bool("watch", false, "Watch the data directory for changes made outside the API (Linux only)")
+	maxJobs := flag.Int("max-background-jobs", 2, "Maximum number of background jobs (scrubs, integrity checks) running at once")
+	logLevel := flag.String("log-level", "info", "Minimum level of logged messages (debug, info, warn, error)")
+	logFormat := flag.String("log-format", "text", "Log output format (text, json)")
+	logBuffer := flag.Int("log-buffer", 1000, "Number of recent log entries kept for /api/admin/logs")
+	scanCommand := flag.String("upload-scan-command", "", "Command run with the path of every upload before it is made available, a non-zero exit rejects the upload")
+	scanTimeout := flag.Duration("upload-scan-timeout", time.Minute, "Time limit for one run of --upload-scan-command")
+	registryPath := flag.String("node-registry", "", "JSON file the node registry is saved to and loaded from on startup, empty keeps nodes in memory only")
+	registryFlush := flag.Duration("node-registry-flush", 10*time.Second, "How often changes to the node registry are saved to --node-registry")
+	nodeTimeout := flag.Duration("node-timeout", 2*time.Minute, "Active nodes not heard from for this long are marked failed (0 disables)")
+	nodeCheckInterval := flag.Duration("node-check-interval", 15*time.Second, "How often nodes are checked against --node-timeout")
+	deleteReplicas := flag.Bool("delete-replicas", true, "Delete the replicas of deleted files from the peers holding them")
+	metadataFlush := flag.Duration("metadata-flush", 5*time.Second, "How often changed file metadata (replication factors, hashes) is saved to the data directory (0 saves on shutdown only)")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate file to serve the HTTP API over TLS with, together with --tls-key")
+	tlsKey := flag.String("tls-key", "", "PEM private key file of --tls-cert")
+	tlsSelfSigned := flag.Bool("tls-self-signed", false, "Serve the HTTP API over TLS with a self-signed certificate generated at startup, for testing")
+	p2pTLSCert := flag.String("p2p-tls-cert", "", "PEM certificate file to encrypt P2P connections with, together with --p2p-tls-key")
+	p2pTLSKey := flag.String("p2p-tls-key", "", "PEM private key file of --p2p-tls-cert")
+	p2pTLSSelfSigned := flag.Bool("p2p-tls-self-signed", false, "Encrypt P2P connections with a self-signed certificate generated at startup, without authenticating peers")
+	p2pTLSCA := flag.String("p2p-tls-ca", "", "PEM file of CA certificates that peers' P2P certificates must be signed by")
+	p2pMTLS := flag.Bool("p2p-mtls", false, "Require peers to present a certificate signed by --p2p-tls-ca whose common name is their node ID")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 15*time.Second, "How long in-flight requests may take to finish on SIGINT or SIGTERM before their connections are closed")
+	configPath := flag.String("config", "", "YAML or JSON file of option values keyed by flag name, flags given on the command line take precedence")
 	flag.Parse()
 
+	// Fill in the options not given on the command line from the config file
+	if *configPath != "" {
+		cfg, err := config.Load(*configPath)
+		if err != nil {
+			return err
+		}
+		if err := cfg.Apply(flag.CommandLine, "config"); err != nil {
+			return err
+		}
+	}
+
+	// Keep recent log entries for the log streaming endpoint. Setting the
+	// default slog logger also routes the standard log package through it.
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		return fmt.Errorf("invalid log level: %s", *logLevel)
+	}
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch *logFormat {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, handlerOpts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, handlerOpts)
+	default:
+		return fmt.Errorf("invalid log format: %s", *logFormat)
+	}
+	logHub := api.NewLogHub(*logBuffer)
+	logger := slog.New(logHub.Handler(handler))
+	slog.SetDefault(logger)
+
+	// Signs P2P registrations and chunk requests between nodes
+	clusterSecret := os.Getenv("FILEGO_CLUSTER_SECRET")
+
 	// Make sure data directory exists
 	if err := os.MkdirAll(*dataDir, 0755); err != nil {
-		log.Fatalf("Failed to create data directory: %v", err)
+		return fmt.Errorf("failed to create data directory: %w", err)
 	}
 
 	// Initialize components
-	fileSystem := fs.NewDistributedFileSystem()
+	fileSystem := fs.NewDistributedFileSystemAt(*dataDir)
+	defer fileSystem.Close()
+	fileSystem.SetLogger(logger)
+	fileSystem.SetAutoMkdir(!*noAutoMkdir)
+	fileSystem.SetCopyBufferSize(*copyBufferSize)
+	crypto.SetCopyBufferSize(*copyBufferSize)
+	if err := fileSystem.SetDurability(fs.DurabilityMode(*durability)); err != nil {
+		return fmt.Errorf("invalid durability mode: %w", err)
+	}
+	if err := fileSystem.LoadMetadata(); err != nil {
+		return fmt.Errorf("failed to load file metadata: %w", err)
+	}
+	fileSystem.StartMetadataFlush(*metadataFlush)
+	if *scanCommand != "" {
+		scanner, err := fs.NewCommandScanner(*scanCommand, *scanTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid upload scan command: %w", err)
+		}
+		fileSystem.SetUploadScanner(scanner)
+	}
 	nodeManager := node.NewNodeManager()
+	if *registryPath != "" {
+		loaded, err := node.LoadNodeManager(*registryPath)
+		if err != nil {
+			return fmt.Errorf("failed to load node registry: %w", err)
+		}
+		nodeManager = loaded
+		nodeManager.StartAutoSave(*registryFlush)
+	}
+	nodeManager.SetLogger(logger)
+	defer nodeManager.Close()
+	nodeManager.StartHealthMonitor(*nodeCheckInterval, *nodeTimeout)
+
+	// Configure node placement
+	strategy, err := node.NewPlacementStrategy(*placement)
+	if err != nil {
+		return fmt.Errorf("invalid placement strategy: %w", err)
+	}
+	nodeManager.SetPlacementStrategy(strategy)
+	nodeManager.SetZoneSpread(*spreadZones)
+	reserve, err := node.ParseStorageReserve(*storageReserve)
+	if err != nil {
+		return fmt.Errorf("invalid storage reserve: %w", err)
+	}
+	nodeManager.SetStorageReserve(reserve)
 
 	// Set up file chunking
-	_, err := fs.NewFileChunker(*dataDir + "/chunks", fs.DefaultChunkSize)
+	chunker, err := fs.NewFileChunker(*dataDir+"/chunks", fs.DefaultChunkSize)
 	if err != nil {
-		log.Fatalf("Failed to initialize file chunker: %v", err)
+		return fmt.Errorf("failed to initialize file chunker: %w", err)
+	}
+	if err := chunker.SetDurability(fs.DurabilityMode(*durability)); err != nil {
+		return fmt.Errorf("invalid durability mode: %w", err)
+	}
+	switch fs.ChunkingMode(*chunking) {
+	case fs.ChunkingFixed:
+	case fs.ChunkingContent:
+		if err := chunker.SetChunking(fs.DefaultContentChunking()); err != nil {
+			return fmt.Errorf("invalid chunking options: %w", err)
+		}
+	default:
+		return fmt.Errorf("invalid chunking mode: %s", *chunking)
+	}
+	switch *chunkStore {
+	case "local":
+	case "memory":
+		chunker.SetChunkStore(fs.NewMemoryChunkStore())
+	case "dedup":
+		store, err := fs.NewDedupChunkStore(*dataDir + "/chunks")
+		if err != nil {
+			return fmt.Errorf("failed to initialize dedup chunk store: %w", err)
+		}
+		chunker.SetChunkStore(store)
+	case "s3":
+		store, err := fs.NewS3ChunkStore(fs.S3Options{
+			Endpoint:  *s3Endpoint,
+			Bucket:    *s3Bucket,
+			Region:    *s3Region,
+			AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			Prefix:    *s3Prefix,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to initialize S3 chunk store: %w", err)
+		}
+		chunker.SetChunkStore(store)
+	default:
+		return fmt.Errorf("invalid chunk store: %s", *chunkStore)
+	}
+	chunker.SetChunkCacheSize(*chunkCacheSize)
+	chunker.SetChunkCompression(*compressChunks)
+	fileSystem.SetChunker(chunker)
+	// Chunks are managed by the chunker, don't report them as files or let
+	// clients touch them
+	if rel, err := filepath.Rel(fileSystem.RootDir(), filepath.Join(*dataDir, "chunks")); err == nil {
+		fileSystem.SetHiddenPaths([]string{rel})
+	}
+	if *watchFiles {
+		if err := fileSystem.Watch(nil); err != nil {
+			return fmt.Errorf("failed to watch data directory: %w", err)
+		}
+	}
+	if chunker.Layout() == fs.LayoutFlat {
+		logger.Info("migrating chunk store to sharded layout")
+		if err := chunker.MigrateToSharded(); err != nil {
+			return fmt.Errorf("failed to migrate chunk store: %w", err)
+		}
 	}
 
 	// Initialize P2P network if enabled
@@ -48,15 +258,48 @@ func main() {
 		// Create P2P options
 		p2pOpts := node.DefaultP2POptions()
 		p2pOpts.Port = *p2pPort
+		p2pOpts.ListenAddr = *p2pListenAddr
 		p2pOpts.NodeID = *nodeID
+		p2pOpts.MaxPeers = *maxPeers
+		p2pOpts.MaxConnHandlers = *maxConnHandlers
+		p2pOpts.MaxMessageSize = *maxMessageSize
+		p2pOpts.MessageWorkers = *messageWorkers
+		p2pOpts.PingTimeout = *pingTimeout
+		p2pOpts.PeerRetention = *peerRetention
+		p2pOpts.BreakerThreshold = *breakerThreshold
+		p2pOpts.BreakerCooldown = *breakerCooldown
+		p2pOpts.ReconnectMin = *reconnectMin
+		p2pOpts.ReconnectMax = *reconnectMax
+		p2pOpts.AdvertiseAddr = *advertiseAddr
+		p2pOpts.StorageMax = *storageMax
+		p2pOpts.Zone = *zone
+		p2pOpts.ClusterSecret = clusterSecret
+		p2pOpts.Logger = logger
+		p2pOpts.TLS, err = p2pTLSOptions(crypto.TLSOptions{CertFile: *p2pTLSCert, KeyFile: *p2pTLSKey, SelfSigned: *p2pTLSSelfSigned}, *p2pTLSCA, *p2pMTLS)
+		if err != nil {
+			return fmt.Errorf("invalid P2P TLS configuration: %w", err)
+		}
 
 		// Create and start P2P network
 		p2pNetwork = node.NewP2PNetwork(p2pOpts, nodeManager)
 		if err := p2pNetwork.Start(); err != nil {
-			log.Fatalf("Failed to start P2P network: %v", err)
+			return fmt.Errorf("failed to start P2P network: %w", err)
 		}
 		defer p2pNetwork.Stop()
-		log.Printf("P2P network started on port %d, Node ID: %s", *p2pPort, p2pNetwork.GetNodeID())
+		p2pNetwork.SetFileSource(fileSystem, chunker)
+		if *deleteReplicas {
+			nodeManager.SetReplicaDeleter(p2pNetwork.DeleteReplica)
+		}
+		logger.Info("P2P network started", "address", p2pNetwork.ListenAddr(), "nodeId", p2pNetwork.GetNodeID())
+
+		// Register this node locally too, peers learn about it on connect
+		if *advertiseAddr != "" {
+			_, _, err := nodeManager.RegisterNodeWithOptions(p2pNetwork.GetNodeID(), *advertiseAddr, node.RegisterOptions{StorageMax: *storageMax, Zone: *zone})
+			if err != nil {
+				return fmt.Errorf("failed to register this node: %w", err)
+			}
+			nodeManager.SetLocalNodeID(p2pNetwork.GetNodeID())
+		}
 
 		// Connect to initial peers if specified
 		if *peerList != "" {
@@ -64,8 +307,25 @@ func main() {
 		}
 	}
 
+	// Resolve the HTTP API listen address
+	apiAddr := fmt.Sprintf(":%d", *port)
+	if *listenAddr != "" {
+		apiAddr = *listenAddr
+	}
+
+	// Configure TLS for the HTTP API
+	tlsConfig, err := crypto.ServerTLSConfig(crypto.TLSOptions{CertFile: *tlsCert, KeyFile: *tlsKey, SelfSigned: *tlsSelfSigned})
+	if err != nil {
+		return fmt.Errorf("invalid TLS configuration: %w", err)
+	}
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+
 	// Set up the router
-	router := gin.Default()
+	router := gin.New()
+	router.Use(api.RequestLogger(logger), gin.Recovery())
 
 	// Load HTML templates
 	router.LoadHTMLGlob("templates/*html")
@@ -74,17 +334,66 @@ func main() {
 	config := cors.DefaultConfig()
 	config.AllowAllOrigins = true
 	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Type", "Authorization"}
+	config.AllowHeaders = []string{"Origin", "Content-Type", "Authorization", "X-API-Key"}
 	router.Use(cors.New(config))
 
+	// Count requests for /metrics
+	requestMetrics := api.NewRequestMetrics()
+	router.Use(requestMetrics.Middleware())
+
+	// Authenticate API requests if configured
+	authenticator, err := api.NewAuthenticator(*authMode, os.Getenv("FILEGO_API_KEYS"), os.Getenv("FILEGO_JWT_SECRET"))
+	if err != nil {
+		return fmt.Errorf("invalid authentication configuration: %w", err)
+	}
+	var nodeSecret []byte
+	if clusterSecret != "" {
+		nodeSecret = []byte(clusterSecret)
+	}
+	if authenticator != nil {
+		// Other nodes fetch chunks with requests signed by the cluster secret
+		if nodeSecret != nil {
+			authenticator = api.ChainAuthenticators(api.NewNodeAuthenticator(nodeSecret), authenticator)
+		} else {
+			logger.Warn("authentication is enabled without FILEGO_CLUSTER_SECRET, other nodes can't recover chunks from this one")
+		}
+		router.Use(api.AuthMiddleware(authenticator))
+	}
+	router.Use(api.AdminMiddleware())
+
+	// Log slow requests
+	if *slowThreshold > 0 {
+		router.Use(api.SlowRequestLogger(*slowThreshold, slog.Default()))
+	}
+
 	// Set up API routes
-	api.SetupRoutes(router, fileSystem, nodeManager)
+	apiOpts := api.DefaultOptions()
+	apiOpts.ByteFieldsAsStrings = *byteStrings
+	apiOpts.MaxDecompressedSize = *maxDecompressed
+	apiOpts.ReadPreference, err = node.ParseReadPreference(*readPref)
+	if err != nil {
+		return fmt.Errorf("invalid read preference: %w", err)
+	}
+	if p2pNetwork != nil {
+		apiOpts.NodeID = p2pNetwork.GetNodeID()
+	}
+	apiOpts.Locks = fs.NewLockManager()
+	api.SetupRoutes(router, fileSystem, nodeManager, apiOpts)
 	
 	// Set up P2P API routes if P2P is enabled
 	if p2pNetwork != nil {
-		api.SetupP2PRoutes(router, fileSystem, nodeManager, p2pNetwork)
+		api.SetupP2PRoutes(router, fileSystem, nodeManager, p2pNetwork, apiOpts.Locks)
 	}
 	
+	// Set up admin API routes
+	api.SetupAdminRoutes(router, fileSystem, nodeManager, chunker, jobs.NewScheduler(*maxJobs))
+	api.SetupLogRoutes(router, logHub)
+	api.SetupEventRoutes(router, fileSystem, nodeManager, p2pNetwork)
+	api.SetupMetricsRoutes(router, requestMetrics, fileSystem, nodeManager, chunker, p2pNetwork)
+
+	// Set up chunk routes used by other nodes to recover missing chunks
+	api.SetupChunkRoutesWithSecret(router, nodeManager, chunker, apiOpts.NodeID, nodeSecret)
+
 	// Set up root route handler
 	api.SetupRootRoute(router)
 
@@ -92,9 +401,9 @@ func main() {
 	fmt.Println("=======================================")
 	fmt.Println("        FileGO Decentralized FS       ")
 	fmt.Println("=======================================")
-	fmt.Printf("API Server: http://localhost:%d\n", *port)
+	fmt.Printf("API Server: %s://%s\n", scheme, displayAddr(apiAddr))
 	if p2pNetwork != nil {
-		fmt.Printf("P2P Network: Enabled (Port %d)\n", *p2pPort)
+		fmt.Printf("P2P Network: Enabled (%s)\n", displayAddr(p2pNetwork.ListenAddr()))
 		fmt.Printf("Node ID: %s\n", p2pNetwork.GetNodeID())
 		fmt.Printf("Peer Discovery: %v\n", *enableDiscovery)
 	} else {
@@ -104,13 +413,95 @@ func main() {
 	fmt.Println("=======================================")
 
 	// Start the server
-	fmt.Printf("Starting server on port %d...\n", *port)
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", *port), router); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	fmt.Printf("Starting server on %s...\n", apiAddr)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// A second signal during shutdown kills the process right away
+	context.AfterFunc(ctx, stop)
+	server := &http.Server{Addr: apiAddr, Handler: router, TLSConfig: tlsConfig}
+	return serve(ctx, server, *shutdownTimeout)
+}
+
+// serve runs the server until ctx is done, then stops accepting
+// connections and waits up to timeout for in-flight requests to finish.
+// Connections still open after that, such as log and event streams, are
+// closed. The server uses TLS when it has a TLS configuration.
+func serve(ctx context.Context, server *http.Server, timeout time.Duration) error {
+	errc := make(chan error, 1)
+	go func() {
+		if server.TLSConfig != nil {
+			// The certificate comes from the TLS configuration
+			errc <- server.ListenAndServeTLS("", "")
+			return
+		}
+		errc <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return fmt.Errorf("failed to start server: %w", err)
+	case <-ctx.Done():
 	}
+
+	slog.Info("shutting down, waiting for in-flight requests", "timeout", timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("graceful shutdown incomplete", "error", err)
+		server.Close()
+	}
+	if err := <-errc; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("server failed: %w", err)
+	}
+	return nil
+}
+
+// p2pTLSOptions returns the TLS options of the P2P network, nil when no
+// certificate is configured
+func p2pTLSOptions(opts crypto.TLSOptions, caFile string, mutual bool) (*node.P2PTLSOptions, error) {
+	if !opts.Enabled() {
+		if caFile != "" || mutual {
+			return nil, errors.New("a certificate is needed to verify peers")
+		}
+		return nil, nil
+	}
+
+	config, err := crypto.ServerTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	tlsOpts := &node.P2PTLSOptions{Certificate: config.Certificates[0]}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsOpts.CAs = x509.NewCertPool()
+		if !tlsOpts.CAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+	if mutual {
+		if tlsOpts.CAs == nil {
+			return nil, errors.New("mutual TLS needs a CA to verify peers with")
+		}
+		tlsOpts.Mutual = true
+		tlsOpts.BindNodeID = true
+	}
+	return tlsOpts, nil
 }
 
-// connectToPeers connects to initial peers from a comma-separated list
+// displayAddr fills in localhost for addresses that bind all interfaces
+func displayAddr(addr string) string {
+	if strings.HasPrefix(addr, ":") {
+		return "localhost" + addr
+	}
+	return addr
+}
+
+// connectToPeers keeps the network connected to the peers in a
+// comma-separated list, reconnecting with backoff whenever one is lost
 func connectToPeers(network *node.P2PNetwork, peerList string) {
 	peers := strings.Split(peerList, ",")
 	for _, peerAddr := range peers {
@@ -119,18 +510,7 @@ func connectToPeers(network *node.P2PNetwork, peerList string) {
 			continue
 		}
 
-		go func(addr string) {
-			for i := 0; i < 3; i++ { // Try 3 times
-				log.Printf("Connecting to peer: %s (attempt %d)", addr, i+1)
-				peer, err := network.ConnectToPeer(addr)
-				if err != nil {
-					log.Printf("Failed to connect to peer %s: %v", addr, err)
-					time.Sleep(2 * time.Second)
-					continue
-				}
-				log.Printf("Connected to peer: %s (ID: %s)", addr, peer.ID)
-				break
-			}
-		}(peerAddr)
+		slog.Info("connecting to peer", "peer", peerAddr)
+		network.AddPersistentPeer(peerAddr)
 	}
 }
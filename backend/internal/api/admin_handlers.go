@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/distfs/internal/fs"
+	"github.com/user/distfs/internal/jobs"
+	"github.com/user/distfs/internal/node"
+)
+
+// SetupAdminRoutes adds administrative routes to the router
+func SetupAdminRoutes(router *gin.Engine, fileSystem *fs.DistributedFileSystem, nodeManager *node.NodeManager, chunker *fs.FileChunker, scheduler *jobs.Scheduler) {
+	fsck := &fsckRunner{
+		fs:          fileSystem,
+		nodeManager: nodeManager,
+		chunker:     chunker,
+		scheduler:   scheduler,
+		jobs:        make(map[string]*FsckJob),
+	}
+
+	// Group routes under /api/admin
+	adminGroup := router.Group("/api/admin")
+	{
+		// Verify all stored chunks and quarantine corrupt ones, repairing
+		// them from other nodes where one has a good copy.
+		// The scrub runs as a background job so it shares the concurrency
+		// cap with other maintenance work, the request waits for it.
+		adminGroup.POST("/scrub", func(c *gin.Context) {
+			var report *fs.ScanReport
+			job := scheduler.Submit("scrub", jobs.PriorityNormal, func(ctx context.Context, progress jobs.Progress) error {
+				var err error
+				report, err = chunker.ScanChunkStore()
+				return err
+			})
+
+			finished, err := scheduler.Wait(c.Request.Context(), job.ID)
+			if err != nil {
+				scheduler.Cancel(job.ID) // The client went away
+				return
+			}
+			if finished.State != jobs.StateCompleted {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": finished.Error, "job": finished})
+				return
+			}
+			c.JSON(http.StatusOK, report)
+		})
+
+		// List replicas of deleted files still waiting for their node to
+		// come back
+		adminGroup.GET("/pending-deletes", func(c *gin.Context) {
+			c.JSON(http.StatusOK, nodeManager.PendingDeletes())
+		})
+
+		// Get the distribution of chunk sizes and chunks per file
+		adminGroup.GET("/chunk-stats", func(c *gin.Context) {
+			c.JSON(http.StatusOK, chunker.Stats())
+		})
+
+		// Stream a tar archive of every locally stored chunk for backups.
+		// Exports of other nodes are redirected to that node.
+		adminGroup.GET("/chunks/export", func(c *gin.Context) {
+			target := c.DefaultQuery("node", "self")
+			if target != "self" {
+				n, err := nodeManager.GetNode(target)
+				if err != nil {
+					c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+					return
+				}
+				c.Redirect(http.StatusTemporaryRedirect, strings.TrimSuffix(n.Address, "/")+"/api/admin/chunks/export?node=self")
+				return
+			}
+
+			c.Header("Content-Type", "application/x-tar")
+			c.Header("Content-Disposition", contentDisposition("attachment", "chunks.tar"))
+			c.Status(http.StatusOK)
+			if err := chunker.ExportChunks(c.Writer); err != nil {
+				if errors.Is(err, fs.ErrStoreNotLocal) && !c.Writer.Written() {
+					c.Writer.Header().Del("Content-Disposition")
+					c.Writer.Header().Del("Content-Type")
+					c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+					return
+				}
+				c.Error(err) // Headers are sent, the archive is cut short
+			}
+		})
+
+		// Restore chunks from an export archive sent as the request body
+		adminGroup.POST("/chunks/import", func(c *gin.Context) {
+			report, err := chunker.ImportChunks(c.Request.Body)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "report": report})
+				return
+			}
+			c.JSON(http.StatusOK, report)
+		})
+
+		// Start a cluster wide integrity check
+		adminGroup.POST("/fsck", func(c *gin.Context) {
+			c.JSON(http.StatusAccepted, fsck.start())
+		})
+
+		// Get the progress and findings of an integrity check
+		adminGroup.GET("/fsck/:id", func(c *gin.Context) {
+			job := fsck.get(c.Param("id"))
+			if job == nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "fsck job not found"})
+				return
+			}
+			c.JSON(http.StatusOK, job)
+		})
+
+		// List background jobs, queued, running and finished
+		adminGroup.GET("/jobs", func(c *gin.Context) {
+			c.JSON(http.StatusOK, scheduler.List())
+		})
+
+		// Get the state and progress of a background job
+		adminGroup.GET("/jobs/:id", func(c *gin.Context) {
+			job, err := scheduler.Get(c.Param("id"))
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, job)
+		})
+
+		// Cancel a queued or running background job
+		adminGroup.DELETE("/jobs/:id", func(c *gin.Context) {
+			err := scheduler.Cancel(c.Param("id"))
+			switch {
+			case errors.Is(err, jobs.ErrJobNotFound):
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			case errors.Is(err, jobs.ErrJobFinished):
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			default:
+				c.JSON(http.StatusOK, gin.H{"message": "Job cancelled"})
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/distfs/internal/fs"
+)
+
+// Archive formats and compression algorithms for directory downloads
+const (
+	ArchiveZip = "zip"
+	ArchiveTar = "tar"
+
+	CompressionStore   = "store"
+	CompressionDeflate = "deflate"
+	CompressionGzip    = "gzip"
+)
+
+// archiveOptions describes how a directory archive is built
+type archiveOptions struct {
+	format      string
+	compression string
+	level       int
+}
+
+// parseArchiveOptions reads the archive format (?format=zip|tar), the
+// compression algorithm (?compression=store|deflate|gzip) and its level
+// (?level=0-9). Zip archives support store and deflate and default to
+// deflate, tar archives support store and gzip and default to gzip.
+func parseArchiveOptions(ctx *gin.Context) (archiveOptions, error) {
+	opts := archiveOptions{
+		format: ctx.DefaultQuery("format", ArchiveZip),
+		level:  flate.DefaultCompression,
+	}
+
+	switch opts.format {
+	case ArchiveZip:
+		opts.compression = ctx.DefaultQuery("compression", CompressionDeflate)
+		if opts.compression != CompressionStore && opts.compression != CompressionDeflate {
+			return opts, fmt.Errorf("zip archives support store or deflate compression, not %q", opts.compression)
+		}
+	case ArchiveTar:
+		opts.compression = ctx.DefaultQuery("compression", CompressionGzip)
+		if opts.compression != CompressionStore && opts.compression != CompressionGzip {
+			return opts, fmt.Errorf("tar archives support store or gzip compression, not %q", opts.compression)
+		}
+	default:
+		return opts, fmt.Errorf("format must be zip or tar, not %q", opts.format)
+	}
+
+	if value := ctx.Query("level"); value != "" {
+		level, err := strconv.Atoi(value)
+		if err != nil || level < flate.NoCompression || level > flate.BestCompression {
+			return opts, fmt.Errorf("level must be between %d and %d", flate.NoCompression, flate.BestCompression)
+		}
+		opts.level = level
+	}
+
+	return opts, nil
+}
+
+// DownloadArchive streams a directory as a zip or tar archive, compressing
+// it on the fly as selected by the query parameters
+func (c *Controller) DownloadArchive(ctx *gin.Context) {
+	dirPath := strings.TrimPrefix(ctx.Param("path"), "/")
+
+	opts, err := parseArchiveOptions(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	info, err := c.FS.GetFileInfo(dirPath)
+	if err != nil {
+		ctx.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if !info.IsDir {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "path is not a directory"})
+		return
+	}
+
+	name := path.Base("/" + dirPath)
+	if name == "/" {
+		name = "files"
+	}
+
+	contentType := "application/zip"
+	extension := ".zip"
+	if opts.format == ArchiveTar {
+		contentType = "application/x-tar"
+		extension = ".tar"
+		if opts.compression == CompressionGzip {
+			contentType = "application/gzip"
+			extension = ".tar.gz"
+		}
+	}
+
+	ctx.Header("Content-Disposition", contentDisposition("attachment", name+extension))
+	ctx.Header("Content-Type", contentType)
+	ctx.Status(http.StatusOK)
+
+	// Headers are sent by now, so errors can only cut the archive short
+	if opts.format == ArchiveZip {
+		err = c.writeZipArchive(ctx.Request.Context(), ctx.Writer, dirPath, opts)
+	} else {
+		err = c.writeTarArchive(ctx.Request.Context(), ctx.Writer, dirPath, opts)
+	}
+	if err != nil {
+		ctx.Error(err)
+	}
+}
+
+// writeZipArchive writes the files below dirPath as a zip archive
+func (c *Controller) writeZipArchive(ctx context.Context, w io.Writer, dirPath string, opts archiveOptions) error {
+	archive := zip.NewWriter(w)
+	archive.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, opts.level)
+	})
+
+	method := zip.Deflate
+	if opts.compression == CompressionStore {
+		method = zip.Store
+	}
+
+	err := c.FS.WalkDirectory(dirPath, func(info fs.FileInfo) error {
+		header := &zip.FileHeader{
+			Name:     archiveName(dirPath, info.Path),
+			Method:   method,
+			Modified: info.ModTime,
+		}
+		entry, err := archive.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		return c.copyFile(ctx, entry, info.Path)
+	})
+	if err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// writeTarArchive writes the files below dirPath as a tar archive,
+// gzipped unless stored
+func (c *Controller) writeTarArchive(ctx context.Context, w io.Writer, dirPath string, opts archiveOptions) error {
+	if opts.compression == CompressionGzip {
+		gz, err := gzip.NewWriterLevel(w, opts.level)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		w = gz
+	}
+
+	archive := tar.NewWriter(w)
+	err := c.FS.WalkDirectory(dirPath, func(info fs.FileInfo) error {
+		reader, err := c.FS.DownloadFile(info.Path)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+
+		// Tar headers carry the size up front, take it from the open file
+		// rather than the possibly stale metadata cache
+		size := info.Size
+		if file, ok := reader.(fs.StatReadSeeker); ok {
+			if stat, err := file.Stat(); err == nil {
+				size = stat.Size()
+			}
+		}
+
+		header := &tar.Header{
+			Name:    archiveName(dirPath, info.Path),
+			Mode:    0644,
+			Size:    size,
+			ModTime: info.ModTime,
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err = fs.CopyBufferContext(ctx, archive, reader, c.FS.CopyBufferSize())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// copyFile copies a file's content into an archive entry
+func (c *Controller) copyFile(ctx context.Context, w io.Writer, filePath string) error {
+	reader, err := c.FS.DownloadFile(filePath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	_, err = fs.CopyBufferContext(ctx, w, reader, c.FS.CopyBufferSize())
+	return err
+}
+
+// archiveName returns the slash separated name of a file inside the
+// archive of dirPath
+func archiveName(dirPath, filePath string) string {
+	name, err := filepath.Rel(filepath.Join(".", dirPath), filePath)
+	if err != nil {
+		name = filePath
+	}
+	return filepath.ToSlash(name)
+}
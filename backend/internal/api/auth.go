@@ -0,0 +1,392 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Errors returned by authenticators
+var (
+	ErrUnauthenticated = errors.New("authentication required")
+	ErrInvalidToken    = errors.New("invalid credentials")
+)
+
+// principalKey is the gin context key the authenticated principal is stored under
+const principalKey = "principal"
+
+// apiKeyHeader is the header carrying API keys
+const apiKeyHeader = "X-API-Key"
+
+// nodeSignatureHeader carries the signature of requests between nodes
+const nodeSignatureHeader = "X-Node-Signature"
+
+// nodeRoutePrefix is where the routes nodes call on each other live
+const nodeRoutePrefix = "/api/chunks/"
+
+// nodeSignatureMaxAge is how far a node signature's timestamp may be off
+const nodeSignatureMaxAge = 5 * time.Minute
+
+// adminRoutePrefix is where the administrative routes live
+const adminRoutePrefix = "/api/admin/"
+
+// RoleAdmin is the role required for the administrative routes
+const RoleAdmin = "admin"
+
+// RoleNode is the role of other nodes authenticated by the cluster secret
+const RoleNode = "node"
+
+// Principal identifies an authenticated caller
+type Principal struct {
+	ID    string   `json:"id"`
+	Roles []string `json:"roles,omitempty"`
+}
+
+// Authenticator resolves the principal making a request. Implementations
+// return ErrUnauthenticated when the request carries no credentials they
+// understand and ErrInvalidToken when the credentials are wrong.
+type Authenticator interface {
+	Authenticate(ctx *gin.Context) (Principal, error)
+}
+
+// Names of the built-in authenticators
+const (
+	AuthNone   = "none"
+	AuthAPIKey = "api-key"
+	AuthJWT    = "jwt"
+)
+
+// NewAuthenticator creates a built-in authenticator by name. It returns nil
+// for AuthNone. apiKeys is a ParseAPIKeys list, jwtSecret the HS256 key.
+func NewAuthenticator(name, apiKeys, jwtSecret string) (Authenticator, error) {
+	switch name {
+	case AuthNone, "":
+		return nil, nil
+	case AuthAPIKey:
+		keys, err := ParseAPIKeys(apiKeys)
+		if err != nil {
+			return nil, err
+		}
+		return NewAPIKeyAuthenticator(keys), nil
+	case AuthJWT:
+		if jwtSecret == "" {
+			return nil, errors.New("jwt authentication requires a secret")
+		}
+		return NewJWTAuthenticator([]byte(jwtSecret)), nil
+	default:
+		return nil, fmt.Errorf("unknown authenticator: %s", name)
+	}
+}
+
+// AuthMiddleware rejects /api requests the authenticator can't resolve to
+// a principal with 401 and stores the principal for later handlers. Other
+// paths, like the web UI, are left public.
+func AuthMiddleware(auth Authenticator) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !strings.HasPrefix(ctx.Request.URL.Path, "/api/") {
+			ctx.Next()
+			return
+		}
+
+		principal, err := auth.Authenticate(ctx)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx.Set(principalKey, principal)
+		ctx.Next()
+	}
+}
+
+// AdminMiddleware guards the /api/admin routes, it goes after
+// AuthMiddleware. Authenticated principals need the admin role. Without
+// authentication there are no principals, then only clients on the
+// loopback interface get in.
+func AdminMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !strings.HasPrefix(ctx.Request.URL.Path, adminRoutePrefix) {
+			ctx.Next()
+			return
+		}
+
+		if principal, ok := PrincipalFromContext(ctx); ok {
+			if !principal.HasRole(RoleAdmin) {
+				ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin role required"})
+				return
+			}
+		} else if !isLoopbackRequest(ctx.Request) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin routes are only served to local clients without authentication"})
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// isLoopbackRequest reports whether a request came over the loopback
+// interface. Forwarding headers are ignored, they are up to the client.
+func isLoopbackRequest(req *http.Request) bool {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// HasRole reports whether the principal has a role
+func (p Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// ChainAuthenticators tries authenticators in order. The first one that
+// finds credentials it understands decides, so a request is only rejected
+// as unauthenticated when none of them does.
+func ChainAuthenticators(auths ...Authenticator) Authenticator {
+	return authenticatorChain(auths)
+}
+
+// authenticatorChain is the Authenticator returned by ChainAuthenticators
+type authenticatorChain []Authenticator
+
+// Authenticate implements Authenticator
+func (c authenticatorChain) Authenticate(ctx *gin.Context) (Principal, error) {
+	for _, auth := range c {
+		principal, err := auth.Authenticate(ctx)
+		if !errors.Is(err, ErrUnauthenticated) {
+			return principal, err
+		}
+	}
+	return Principal{}, ErrUnauthenticated
+}
+
+// NodeAuthenticator authenticates other nodes of the cluster calling the
+// node-to-node routes, by an HMAC of the request signed with the cluster
+// secret in the X-Node-Signature header. See SignNodeRequest.
+type NodeAuthenticator struct {
+	secret []byte
+	now    func() time.Time
+}
+
+// NewNodeAuthenticator creates an authenticator verifying node signatures
+// made with secret
+func NewNodeAuthenticator(secret []byte) *NodeAuthenticator {
+	return &NodeAuthenticator{
+		secret: secret,
+		now:    time.Now,
+	}
+}
+
+// Authenticate implements Authenticator. Signatures are only accepted on
+// node-to-node routes, they grant nothing else.
+func (a *NodeAuthenticator) Authenticate(ctx *gin.Context) (Principal, error) {
+	value := ctx.GetHeader(nodeSignatureHeader)
+	if value == "" {
+		return Principal{}, ErrUnauthenticated
+	}
+	if !strings.HasPrefix(ctx.Request.URL.Path, nodeRoutePrefix) {
+		return Principal{}, fmt.Errorf("%w: node signatures are only valid on node routes", ErrInvalidToken)
+	}
+
+	timestamp, signature, found := strings.Cut(value, ":")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if !found || err != nil {
+		return Principal{}, ErrInvalidToken
+	}
+	if age := a.now().Sub(time.Unix(seconds, 0)); age > nodeSignatureMaxAge || age < -nodeSignatureMaxAge {
+		return Principal{}, fmt.Errorf("%w: node signature expired", ErrInvalidToken)
+	}
+	if !hmac.Equal([]byte(signature), []byte(nodeSignature(a.secret, ctx.Request.Method, ctx.Request.URL.Path, timestamp))) {
+		return Principal{}, ErrInvalidToken
+	}
+
+	return Principal{ID: "node", Roles: []string{RoleNode}}, nil
+}
+
+// SignNodeRequest signs a request to another node with the cluster secret
+func SignNodeRequest(req *http.Request, secret []byte) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(nodeSignatureHeader, timestamp+":"+nodeSignature(secret, req.Method, req.URL.Path, timestamp))
+}
+
+// nodeSignature is the hex HMAC-SHA256 over a request's method, path and
+// timestamp
+func nodeSignature(secret []byte, method, path, timestamp string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(method + "\n" + path + "\n" + timestamp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// PrincipalFromContext returns the principal stored by AuthMiddleware
+func PrincipalFromContext(ctx *gin.Context) (Principal, bool) {
+	value, exists := ctx.Get(principalKey)
+	if !exists {
+		return Principal{}, false
+	}
+	principal, ok := value.(Principal)
+	return principal, ok
+}
+
+// APIKeyAuthenticator authenticates requests by the key in the X-API-Key
+// header, or sent as a bearer token in the Authorization header
+type APIKeyAuthenticator struct {
+	keys map[string]Principal
+}
+
+// NewAPIKeyAuthenticator creates an authenticator accepting the given keys
+func NewAPIKeyAuthenticator(keys map[string]Principal) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{keys: keys}
+}
+
+// Authenticate implements Authenticator
+func (a *APIKeyAuthenticator) Authenticate(ctx *gin.Context) (Principal, error) {
+	key := ctx.GetHeader(apiKeyHeader)
+	if bearer, found := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer "); key == "" && found {
+		key = bearer
+	}
+	if key == "" {
+		return Principal{}, ErrUnauthenticated
+	}
+
+	// Compare against every key so timing doesn't reveal which ones exist
+	var match *Principal
+	for candidate, principal := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(key)) == 1 {
+			p := principal
+			match = &p
+		}
+	}
+	if match == nil {
+		return Principal{}, ErrInvalidToken
+	}
+
+	return *match, nil
+}
+
+// JWTAuthenticator authenticates requests by an HS256 signed JWT in the
+// Authorization: Bearer header. The sub claim becomes the principal ID and
+// the roles claim its roles.
+type JWTAuthenticator struct {
+	secret []byte
+	now    func() time.Time
+}
+
+// NewJWTAuthenticator creates an authenticator verifying tokens with secret
+func NewJWTAuthenticator(secret []byte) *JWTAuthenticator {
+	return &JWTAuthenticator{
+		secret: secret,
+		now:    time.Now,
+	}
+}
+
+// jwtClaims are the claims read from a token
+type jwtClaims struct {
+	Subject   string   `json:"sub"`
+	Roles     []string `json:"roles"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+}
+
+// Authenticate implements Authenticator
+func (a *JWTAuthenticator) Authenticate(ctx *gin.Context) (Principal, error) {
+	token, found := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+	if !found || token == "" {
+		return Principal{}, ErrUnauthenticated
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Principal{}, ErrInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return Principal{}, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Principal{}, ErrInvalidToken
+	}
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return Principal{}, ErrInvalidToken
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil || claims.Subject == "" {
+		return Principal{}, ErrInvalidToken
+	}
+
+	now := a.now().Unix()
+	if claims.ExpiresAt != 0 && now >= claims.ExpiresAt {
+		return Principal{}, fmt.Errorf("%w: token expired", ErrInvalidToken)
+	}
+	if claims.NotBefore != 0 && now < claims.NotBefore {
+		return Principal{}, fmt.Errorf("%w: token not valid yet", ErrInvalidToken)
+	}
+
+	return Principal{ID: claims.Subject, Roles: claims.Roles}, nil
+}
+
+// decodeJWTPart decodes a base64url encoded JSON token segment
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// ParseAPIKeys parses a comma-separated list of principal=key pairs. The
+// principal may be followed by its roles, separated by colons, as in
+// ops:admin=key.
+func ParseAPIKeys(spec string) (map[string]Principal, error) {
+	keys := make(map[string]Principal)
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		id, key, found := strings.Cut(pair, "=")
+		if !found || id == "" || key == "" {
+			return nil, fmt.Errorf("invalid api key entry %q, expected principal=key", pair)
+		}
+		id, roles, _ := strings.Cut(id, ":")
+		if id == "" {
+			return nil, fmt.Errorf("invalid api key entry %q, expected principal=key", pair)
+		}
+		principal := Principal{ID: id}
+		if roles != "" {
+			principal.Roles = strings.Split(roles, ":")
+		}
+		keys[key] = principal
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no api keys configured")
+	}
+	return keys, nil
+}
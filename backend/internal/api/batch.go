@@ -0,0 +1,188 @@
+package api
+
+import (
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/distfs/internal/fs"
+)
+
+// Policies for batch upload parts whose path is taken, either by an
+// existing file or by another part of the same batch
+const (
+	ConflictReject    = "reject"
+	ConflictOverwrite = "overwrite"
+	ConflictRename    = "rename"
+)
+
+// Outcomes of a batch upload part
+const (
+	batchStored      = "stored"
+	batchOverwritten = "overwritten"
+	batchRenamed     = "renamed"
+	batchRejected    = "rejected"
+	batchSuperseded  = "superseded" // A later part for the same path won
+	batchFailed      = "failed"
+)
+
+// BatchResult reports what happened to one part of a batch upload
+type BatchResult struct {
+	Path       string `json:"path"`
+	StoredPath string `json:"storedPath,omitempty"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+}
+
+// maxRenameAttempts bounds the search for a free name when renaming
+const maxRenameAttempts = 1000
+
+// BatchUpload stores several files from one multipart request. Each "file"
+// part is stored at the matching "path" value (or its filename) under the
+// directory in the URL. ?onConflict= decides what happens when a path is
+// taken: reject (default), overwrite or rename.
+func (c *Controller) BatchUpload(ctx *gin.Context) {
+	baseDir := strings.TrimPrefix(ctx.Param("path"), "/")
+
+	policy := ctx.DefaultQuery("onConflict", ConflictReject)
+	if policy != ConflictReject && policy != ConflictOverwrite && policy != ConflictRename {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "onConflict must be reject, overwrite or rename"})
+		return
+	}
+
+	form, err := ctx.MultipartForm()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	files := form.File["file"]
+	if len(files) == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "No file provided"})
+		return
+	}
+
+	paths := form.Value["path"]
+	if len(paths) != 0 && len(paths) != len(files) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "path must be given once per file or not at all"})
+		return
+	}
+
+	// Resolve every target path up front so collisions inside the batch are
+	// known before anything is written
+	targets := make([]string, len(files))
+	claimed := make(map[string]int) // Maps a target path to the last part claiming it
+	claims := make(map[string]int)  // Number of parts claiming a target path
+	for i, file := range files {
+		name := file.Filename
+		if len(paths) != 0 {
+			name = paths[i]
+		}
+		relative := path.Clean("/" + name)[1:]
+		if relative == "" {
+			continue // Rejected below
+		}
+		targets[i] = path.Join(baseDir, relative)
+		claimed[targets[i]] = i
+		claims[targets[i]]++
+	}
+
+	opts := c.FS.DefaultUploadOptions()
+	if mkdir := ctx.Query("mkdir"); mkdir != "" {
+		opts.CreateParents = mkdir == "true"
+	}
+
+	results := make([]BatchResult, len(files))
+	taken := make(map[string]bool) // Paths stored by this batch so far
+	for i, file := range files {
+		target := targets[i]
+		result := &results[i]
+		result.Path = target
+
+		if target == "" {
+			result.Status = batchRejected
+			result.Error = "part has no path"
+			continue
+		}
+
+		exists := c.FS.Exists(target)
+		status := batchStored
+
+		switch {
+		case policy == ConflictReject && claims[target] > 1:
+			result.Status = batchRejected
+			result.Error = "path is used by more than one file in the batch"
+			continue
+		case policy == ConflictReject && exists:
+			result.Status = batchRejected
+			result.Error = "path already exists"
+			continue
+		case policy == ConflictOverwrite && claimed[target] != i:
+			// The last part for a path wins
+			result.Status = batchSuperseded
+			continue
+		case policy == ConflictOverwrite && exists:
+			status = batchOverwritten
+		case policy == ConflictRename && (exists || taken[target]):
+			// The first part for a path keeps it, later ones are renamed
+			renamed, err := c.freePath(target, claimed, taken)
+			if err != nil {
+				result.Status = batchRejected
+				result.Error = err.Error()
+				continue
+			}
+			target = renamed
+			status = batchRenamed
+		}
+
+		if err := c.Locks.Check(target, ctx.GetHeader(lockTokenHeader)); err != nil {
+			result.Status = batchRejected
+			result.Error = err.Error()
+			continue
+		}
+
+		if err := c.storeBatchPart(file, target, opts); err != nil {
+			result.Status = batchFailed
+			result.Error = err.Error()
+			continue
+		}
+
+		taken[target] = true
+		result.StoredPath = target
+		result.Status = status
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// storeBatchPart uploads one part of a batch
+func (c *Controller) storeBatchPart(file *multipart.FileHeader, target string, opts fs.UploadOptions) error {
+	src, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	return c.FS.UploadFileWithOptions(target, src, opts)
+}
+
+// freePath finds a name like "report (1).txt" next to target that is
+// neither an existing file nor a path claimed by the batch
+func (c *Controller) freePath(target string, claimed map[string]int, taken map[string]bool) (string, error) {
+	dir, name := path.Split(target)
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+
+	for n := 1; n <= maxRenameAttempts; n++ {
+		candidate := dir + fmt.Sprintf("%s (%d)%s", stem, n, ext)
+		_, isClaimed := claimed[candidate]
+		if !isClaimed && !taken[candidate] && !c.FS.Exists(candidate) {
+			return candidate, nil
+		}
+	}
+
+	return "", fmt.Errorf("no free name found for %s", target)
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/distfs/internal/fs"
+	"github.com/user/distfs/internal/node"
+)
+
+// chunkFetchTimeout bounds how long fetching a chunk from one node may take
+const chunkFetchTimeout = 30 * time.Second
+
+// SetupChunkRoutes adds routes serving stored chunks to other nodes and lets
+// the chunker recover chunks it is missing from them
+func SetupChunkRoutes(router *gin.Engine, nodeManager *node.NodeManager, chunker *fs.FileChunker, nodeID string) {
+	SetupChunkRoutesWithSecret(router, nodeManager, chunker, nodeID, nil)
+}
+
+// SetupChunkRoutesWithSecret works like SetupChunkRoutes, signing chunk
+// requests to other nodes with the cluster secret so they pass their
+// NodeAuthenticator. A nil secret sends requests unsigned.
+func SetupChunkRoutesWithSecret(router *gin.Engine, nodeManager *node.NodeManager, chunker *fs.FileChunker, nodeID string, secret []byte) {
+	chunker.SetChunkLocator(LocateChunk(nodeManager, nodeID, secret))
+
+	// Group routes under /api/chunks
+	chunkGroup := router.Group("/api/chunks")
+	{
+		// Serve a chunk from the local store. Missing chunks are not
+		// recovered here, so nodes asking each other can't loop.
+		chunkGroup.GET("/:fileId/:chunkId", func(c *gin.Context) {
+			data, err := chunker.GetLocalChunk(c.Param("fileId"), c.Param("chunkId"))
+			if err != nil {
+				c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			c.Data(http.StatusOK, "application/octet-stream", data)
+		})
+	}
+}
+
+// LocateChunk returns a chunk locator that asks every other active node
+// with an HTTP address for the chunk until one has it. Requests are signed
+// with secret unless it is nil.
+func LocateChunk(nodeManager *node.NodeManager, selfID string, secret []byte) fs.ChunkLocator {
+	client := &http.Client{Timeout: chunkFetchTimeout}
+
+	return func(fileID, chunkID string) ([]byte, error) {
+		var failures []string
+		for _, n := range nodeManager.ListNodes() {
+			if n.ID == selfID || n.Status != "active" {
+				continue
+			}
+
+			data, err := fetchChunk(client, n.Address, fileID, chunkID, secret)
+			if err == nil {
+				return data, nil
+			}
+			failures = append(failures, fmt.Sprintf("%s: %v", n.ID, err))
+		}
+
+		if len(failures) == 0 {
+			return nil, fmt.Errorf("no other active nodes to fetch from")
+		}
+		return nil, fmt.Errorf("not found on any node (%s)", strings.Join(failures, "; "))
+	}
+}
+
+// fetchChunk downloads a chunk from a node's chunk route
+func fetchChunk(client *http.Client, address, fileID, chunkID string, secret []byte) ([]byte, error) {
+	chunkURL, err := url.Parse(address)
+	if err != nil || (chunkURL.Scheme != "http" && chunkURL.Scheme != "https") {
+		return nil, fmt.Errorf("node has no HTTP address")
+	}
+	chunkURL.Path = "/api/chunks/" + url.PathEscape(fileID) + "/" + url.PathEscape(chunkID)
+
+	req, err := http.NewRequest(http.MethodGet, chunkURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if secret != nil {
+		SignNodeRequest(req, secret)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, fs.MaxChunkSize+1))
+}
@@ -0,0 +1,240 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/distfs/internal/crypto"
+	"github.com/user/distfs/internal/fs"
+)
+
+// encryptionKey is the key material of an encrypt or decrypt request
+type encryptionKey struct {
+	key        []byte // Raw key, nil when a passphrase is used
+	passphrase string
+	generated  bool // The key was generated for this request
+}
+
+// requestKey reads the hex "key" or the "passphrase" form field. Without
+// either a random key is generated if generate is set.
+func requestKey(c *gin.Context, generate bool) (encryptionKey, error) {
+	hexKey := c.PostForm("key")
+	passphrase := c.PostForm("passphrase")
+
+	switch {
+	case hexKey != "" && passphrase != "":
+		return encryptionKey{}, errors.New("provide either a key or a passphrase, not both")
+	case hexKey != "":
+		key, err := crypto.StringToKey(hexKey)
+		if err != nil || len(key) != crypto.KeySize {
+			return encryptionKey{}, fmt.Errorf("key must be %d bytes encoded as hex", crypto.KeySize)
+		}
+		return encryptionKey{key: key}, nil
+	case passphrase != "":
+		return encryptionKey{passphrase: passphrase}, nil
+	case generate:
+		key, err := crypto.GenerateRandomKey()
+		if err != nil {
+			return encryptionKey{}, err
+		}
+		return encryptionKey{key: key, generated: true}, nil
+	default:
+		return encryptionKey{}, errors.New("a key or passphrase is required")
+	}
+}
+
+// createTempFile creates a temporary file for a crypto request
+func createTempFile(pattern string) (*os.File, error) {
+	file, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file in %s: %w", os.TempDir(), err)
+	}
+	return file, nil
+}
+
+// removeTempFile closes and deletes a temporary file
+func removeTempFile(file *os.File) {
+	file.Close()
+	os.Remove(file.Name())
+}
+
+// handleEncrypt encrypts an uploaded file with a key, a passphrase or a
+// newly generated key. The result is stored in the file system when a
+// "path" is given and downloaded otherwise. A generated key is returned
+// in hex, in the response body or the X-Encryption-Key header. Storing
+// over a locked file needs the lock's X-Lock-Token.
+func handleEncrypt(fileSystem *fs.DistributedFileSystem, locks *fs.LockManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		upload, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
+			return
+		}
+
+		storePath := strings.TrimPrefix(c.PostForm("path"), "/")
+		if storePath != "" {
+			if err := locks.Check(storePath, c.GetHeader(lockTokenHeader)); err != nil {
+				c.JSON(http.StatusLocked, gin.H{"error": err.Error(), "path": storePath})
+				return
+			}
+		}
+
+		key, err := requestKey(c, true)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		src, err := upload.Open()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer src.Close()
+
+		// Encrypt into a temporary file so errors can still be reported
+		// before any of the output is sent
+		dst, err := createTempFile("distfs-encrypt-*")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer removeTempFile(dst)
+
+		var processed int64
+		if key.passphrase != "" {
+			err = crypto.EncryptWithPassphrase(src, dst, key.passphrase, crypto.DefaultKDFOptions())
+			processed = upload.Size
+		} else {
+			err = crypto.EncryptFileWithOptions(src, dst, key.key, crypto.StreamOptions{
+				Progress: func(n int64) { processed = n },
+			})
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "encryption failed: " + err.Error()})
+			return
+		}
+
+		if _, err := dst.Seek(0, io.SeekStart); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if storePath != "" {
+			if err := fileSystem.UploadFile(storePath, dst); err != nil {
+				c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+
+			response := gin.H{
+				"status": "encrypted",
+				"path":   storePath,
+				"bytes":  processed,
+			}
+			if key.generated {
+				response["key"] = crypto.KeyToString(key.key)
+			}
+			c.JSON(http.StatusOK, response)
+			return
+		}
+
+		sendTempFile(c, dst, filepath.Base(upload.Filename)+".enc", key, processed)
+	}
+}
+
+// handleDecrypt decrypts an uploaded file written by the encrypt endpoint
+// with the hex "key" or "passphrase" it was encrypted with, and returns
+// the plaintext as a download
+func handleDecrypt() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		upload, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
+			return
+		}
+
+		key, err := requestKey(c, false)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		src, err := upload.Open()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer src.Close()
+
+		// Decrypt into a temporary file, nothing is sent unless the whole
+		// file authenticates
+		dst, err := createTempFile("distfs-decrypt-*")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer removeTempFile(dst)
+
+		var processed int64
+		if key.passphrase != "" {
+			err = crypto.DecryptWithPassphrase(src, dst, key.passphrase)
+		} else {
+			err = crypto.DecryptFileWithOptions(src, dst, key.key, crypto.StreamOptions{
+				Progress: func(n int64) { processed = n },
+			})
+		}
+		switch {
+		case errors.Is(err, crypto.ErrInvalidFormat):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, crypto.ErrAuthentication):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "wrong key or passphrase, or the file was modified: " + err.Error()})
+			return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "decryption failed: " + err.Error()})
+			return
+		}
+
+		if _, err := dst.Seek(0, io.SeekStart); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if key.passphrase != "" {
+			if info, err := dst.Stat(); err == nil {
+				processed = info.Size()
+			}
+		}
+
+		name := filepath.Base(upload.Filename)
+		if trimmed := strings.TrimSuffix(name, ".enc"); trimmed != "" {
+			name = trimmed
+		}
+		sendTempFile(c, dst, name, key, processed)
+	}
+}
+
+// sendTempFile downloads the output of a crypto request
+func sendTempFile(c *gin.Context, file *os.File, name string, key encryptionKey, processed int64) {
+	info, err := file.Stat()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	headers := map[string]string{
+		"Content-Disposition": contentDisposition("attachment", name),
+		"X-Processed-Bytes":   strconv.FormatInt(processed, 10),
+	}
+	if key.generated {
+		headers["X-Encryption-Key"] = crypto.KeyToString(key.key)
+	}
+
+	c.DataFromReader(http.StatusOK, info.Size(), "application/octet-stream", file, headers)
+}
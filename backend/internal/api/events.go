@@ -0,0 +1,122 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/distfs/internal/fs"
+	"github.com/user/distfs/internal/node"
+)
+
+// Kinds of events streamed from /api/events
+const (
+	EventKindFile = "file"
+	EventKindNode = "node"
+	EventKindPeer = "peer"
+)
+
+// StreamEvent is one event streamed from /api/events. Event holds a
+// fs.FileEvent, node.NodeEvent or node.PeerEvent depending on Kind.
+type StreamEvent struct {
+	Kind  string      `json:"kind"`
+	Event interface{} `json:"event"`
+}
+
+// SetupEventRoutes adds the event streaming route. p2pNetwork may be nil,
+// there are no peer events then.
+func SetupEventRoutes(router *gin.Engine, fileSystem *fs.DistributedFileSystem, nodeManager *node.NodeManager, p2pNetwork *node.P2PNetwork) {
+	// Stream file, node and peer events as they happen, as NDJSON or as
+	// server-sent events with ?format=sse or Accept: text/event-stream.
+	// ?kinds= limits the stream to a comma-separated list of kinds.
+	router.GET("/api/events", func(c *gin.Context) {
+		kinds := map[string]bool{EventKindFile: true, EventKindNode: true, EventKindPeer: true}
+		if value := c.Query("kinds"); value != "" {
+			kinds = make(map[string]bool)
+			for _, kind := range strings.Split(value, ",") {
+				kind = strings.TrimSpace(kind)
+				if kind != EventKindFile && kind != EventKindNode && kind != EventKindPeer {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "kinds must be a comma-separated list of file, node and peer"})
+					return
+				}
+				kinds[kind] = true
+			}
+		}
+
+		sse := c.Query("format") == "sse" || strings.Contains(c.GetHeader("Accept"), "text/event-stream")
+		if format := c.Query("format"); format != "" && format != "sse" && format != "ndjson" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format must be ndjson or sse"})
+			return
+		}
+
+		// Channels of kinds that weren't asked for stay nil and never fire
+		var fileEvents <-chan fs.FileEvent
+		var nodeEvents <-chan node.NodeEvent
+		var peerEvents <-chan node.PeerEvent
+		if kinds[EventKindFile] {
+			events, cancel := fileSystem.SubscribeFileEvents()
+			defer cancel()
+			fileEvents = events
+		}
+		if kinds[EventKindNode] {
+			events, cancel := nodeManager.SubscribeNodeEvents()
+			defer cancel()
+			nodeEvents = events
+		}
+		if kinds[EventKindPeer] && p2pNetwork != nil {
+			events, cancel := p2pNetwork.SubscribePeerEvents()
+			defer cancel()
+			peerEvents = events
+		}
+
+		if sse {
+			c.Header("Content-Type", "text/event-stream")
+			c.Header("Cache-Control", "no-cache")
+		} else {
+			c.Header("Content-Type", "application/x-ndjson")
+		}
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+
+		for {
+			var event StreamEvent
+			var ok bool
+			select {
+			case <-c.Request.Context().Done():
+				return
+			case event.Event, ok = <-fileEvents:
+				event.Kind = EventKindFile
+			case event.Event, ok = <-nodeEvents:
+				event.Kind = EventKindNode
+			case event.Event, ok = <-peerEvents:
+				event.Kind = EventKindPeer
+			}
+			if !ok {
+				return // Fell behind, the client may reconnect
+			}
+
+			if writeStreamEvent(c.Writer, event, sse) != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	})
+}
+
+// writeStreamEvent writes an event as an NDJSON line or as a server-sent
+// event named after its kind
+func writeStreamEvent(w gin.ResponseWriter, event StreamEvent, sse bool) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if sse {
+		_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Kind, data)
+	} else {
+		_, err = fmt.Fprintf(w, "%s\n", data)
+	}
+	return err
+}
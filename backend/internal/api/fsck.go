@@ -0,0 +1,181 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/user/distfs/internal/fs"
+	"github.com/user/distfs/internal/jobs"
+	"github.com/user/distfs/internal/node"
+)
+
+// Fsck job states
+const (
+	fsckQueued    = "queued"
+	fsckRunning   = "running"
+	fsckCompleted = "completed"
+	fsckFailed    = "failed"
+)
+
+// FsckFinding describes a single inconsistency found by an fsck job
+type FsckFinding struct {
+	Type   string `json:"type"` // "under-replicated", "corrupt-file", "corrupt-chunk"
+	Path   string `json:"path"`
+	Detail string `json:"detail"`
+}
+
+// FsckJob reports the progress and findings of a cluster integrity check
+type FsckJob struct {
+	ID            string        `json:"id"`
+	JobID         string        `json:"jobId"` // Background job running the check
+	State         string        `json:"state"`
+	FilesScanned  int           `json:"filesScanned"`
+	ChunksScanned int           `json:"chunksScanned"`
+	Findings      []FsckFinding `json:"findings"`
+	Error         string        `json:"error,omitempty"`
+	StartedAt     time.Time     `json:"startedAt"`
+	CompletedAt   *time.Time    `json:"completedAt,omitempty"`
+}
+
+// fsckRunner runs integrity checks and keeps track of their jobs
+type fsckRunner struct {
+	fs          *fs.DistributedFileSystem
+	nodeManager *node.NodeManager
+	chunker     *fs.FileChunker
+	scheduler   *jobs.Scheduler
+	jobs        map[string]*FsckJob
+	mu          sync.RWMutex
+}
+
+// start kicks off a new integrity check in the background
+func (r *fsckRunner) start() *FsckJob {
+	job := &FsckJob{
+		ID:        uuid.New().String(),
+		State:     fsckQueued,
+		Findings:  []FsckFinding{},
+		StartedAt: time.Now(),
+	}
+
+	r.mu.Lock()
+	r.jobs[job.ID] = job
+	r.mu.Unlock()
+
+	scheduled := r.scheduler.Submit("fsck", jobs.PriorityLow, func(ctx context.Context, progress jobs.Progress) error {
+		return r.run(ctx, job, progress)
+	})
+
+	r.mu.Lock()
+	job.JobID = scheduled.ID
+	r.mu.Unlock()
+
+	return r.get(job.ID)
+}
+
+// get returns a snapshot of a job
+func (r *fsckRunner) get(id string) *FsckJob {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	job, exists := r.jobs[id]
+	if !exists {
+		return nil
+	}
+
+	snapshot := *job
+	snapshot.Findings = append([]FsckFinding{}, job.Findings...)
+	return &snapshot
+}
+
+// addFinding records an inconsistency on a job
+func (r *fsckRunner) addFinding(job *FsckJob, finding FsckFinding) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job.Findings = append(job.Findings, finding)
+}
+
+// finish marks a job as done
+func (r *fsckRunner) finish(job *FsckJob, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	job.CompletedAt = &now
+	job.State = fsckCompleted
+	if err != nil {
+		job.State = fsckFailed
+		job.Error = err.Error()
+	}
+}
+
+// run checks every file's replica placement and content hash, then
+// verifies the chunk store. Progress counts the files scanned.
+func (r *fsckRunner) run(ctx context.Context, job *FsckJob, progress jobs.Progress) error {
+	r.mu.Lock()
+	job.State = fsckRunning
+	r.mu.Unlock()
+
+	err := r.fs.WalkFiles(func(info fs.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Count replicas on nodes that are still healthy
+		healthy := 0
+		for _, id := range r.nodeManager.GetPlacement(info.Path) {
+			if n, err := r.nodeManager.GetNode(id); err == nil && n.Status == "active" {
+				healthy++
+			}
+		}
+		if info.Replicas > 1 && healthy < info.Replicas {
+			r.addFinding(job, FsckFinding{
+				Type:   "under-replicated",
+				Path:   info.Path,
+				Detail: fmt.Sprintf("%d of %d replicas on healthy nodes", healthy, info.Replicas),
+			})
+		}
+
+		valid, err := r.fs.VerifyFile(info.Path)
+		if err != nil {
+			r.addFinding(job, FsckFinding{Type: "corrupt-file", Path: info.Path, Detail: err.Error()})
+		} else if !valid {
+			r.addFinding(job, FsckFinding{Type: "corrupt-file", Path: info.Path, Detail: "content hash mismatch"})
+		}
+
+		r.mu.Lock()
+		job.FilesScanned++
+		scanned := job.FilesScanned
+		r.mu.Unlock()
+		progress(scanned, 0)
+
+		return nil
+	})
+	if err != nil {
+		r.finish(job, err)
+		return err
+	}
+
+	report, err := r.chunker.VerifyChunkStore()
+	if err != nil {
+		r.finish(job, err)
+		return err
+	}
+
+	for _, chunk := range report.Corrupt {
+		r.addFinding(job, FsckFinding{
+			Type:   "corrupt-chunk",
+			Path:   chunk.FileID + "/" + chunk.ChunkID,
+			Detail: chunk.Reason,
+		})
+	}
+
+	r.mu.Lock()
+	job.ChunksScanned = report.Scanned
+	r.mu.Unlock()
+
+	r.finish(job, nil)
+	return nil
+}
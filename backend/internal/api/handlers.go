@@ -1,11 +1,19 @@
 package api
 
 import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -17,25 +25,78 @@ import (
 type Controller struct {
 	FS          *fs.DistributedFileSystem
 	NodeManager *node.NodeManager
+	Locks       *fs.LockManager
+	Options     Options
 }
 
+// Options contains configuration options for the API
+type Options struct {
+	ByteFieldsAsStrings bool                // Encode byte counts as JSON strings
+	NodeID              string              // ID of this node, used to recognize local replicas
+	ReadPreference      node.ReadPreference // Default replica read preference
+	MaxDecompressedSize int64               // Limit on gzip-encoded upload bodies once decompressed
+	Locks               *fs.LockManager     // Advisory file locks, shared with routes set up elsewhere; created when nil
+}
+
+// DefaultOptions returns default API configuration options
+func DefaultOptions() Options {
+	return Options{
+		ByteFieldsAsStrings: false,
+		ReadPreference:      node.ReadLocalFirst,
+		MaxDecompressedSize: 1 << 30,
+	}
+}
+
+// Lock settings
+const (
+	lockTokenHeader = "X-Lock-Token"
+	defaultLockTTL  = 5 * time.Minute
+	lockSweepPeriod = time.Minute
+)
+
 // SetupRoutes configures the API routes
-func SetupRoutes(router *gin.Engine, fileSystem *fs.DistributedFileSystem, nodeManager *node.NodeManager) {
+func SetupRoutes(router *gin.Engine, fileSystem *fs.DistributedFileSystem, nodeManager *node.NodeManager, options Options) {
+	locks := options.Locks
+	if locks == nil {
+		locks = fs.NewLockManager()
+	}
 	controller := &Controller{
 		FS:          fileSystem,
 		NodeManager: nodeManager,
+		Locks:       locks,
+		Options:     options,
 	}
+	controller.Locks.StartSweeper(lockSweepPeriod)
 
 	api := router.Group("/api")
 	{
 		// File system endpoints
 		api.GET("/files", controller.ListFiles)
-		api.GET("/files/*path", controller.GetFile)
+		api.GET("/search", controller.SearchFiles)
+		api.GET("/files/*path", fileRoute(fileSystem, controller.GetFile, map[string]gin.HandlerFunc{
+			"merkle":   controller.GetMerkleTree,
+			"checksum": controller.GetChecksum,
+			"archive":  controller.DownloadArchive,
+		}))
 		api.POST("/files/*path", controller.UploadFile)
 		api.DELETE("/files/*path", controller.DeleteFile)
 		api.PUT("/files/*path", controller.MoveFile)
+		api.GET("/merkle/*path", controller.GetMerkleTree)
+		api.GET("/checksums/*path", controller.GetChecksum)
+		api.GET("/archives/*path", controller.DownloadArchive)
+		api.POST("/locks/*path", controller.LockFile)
+		api.DELETE("/locks/*path", controller.UnlockFile)
+		api.POST("/copy/*path", controller.CopyFile)
+		api.POST("/batch-upload", controller.BatchUpload)
+		api.POST("/batch-upload/*path", controller.BatchUpload)
 		api.POST("/directories/*path", controller.CreateDirectory)
 		api.PUT("/replicate/*path", controller.SetReplicationFactor)
+		
+		// Policy endpoints
+		api.GET("/policies/replica-size", controller.GetReplicaSizePolicy)
+		api.PUT("/policies/replica-size", controller.SetReplicaSizePolicy)
+		api.GET("/policies/replication", controller.ListReplicationPolicies)
+		api.DELETE("/policies/replication/*path", controller.RemoveReplicationPolicy)
 
 		// Node management endpoints
 		api.GET("/nodes", controller.ListNodes)
@@ -45,81 +106,371 @@ func SetupRoutes(router *gin.Engine, fileSystem *fs.DistributedFileSystem, nodeM
 		api.PUT("/nodes/:id/storage", controller.UpdateNodeStorage)
 		api.DELETE("/nodes/:id", controller.RemoveNode)
 		api.POST("/nodes/:id/heartbeat", controller.HeartbeatNode)
+		api.POST("/nodes/:id/drain", controller.StartDrain)
+		api.GET("/nodes/:id/drain", controller.GetDrainStatus)
 		
-		// System status endpoint
+		// System status endpoints
 		api.GET("/status", controller.GetSystemStatus)
+		api.GET("/stats/io", controller.GetIOStats)
 	}
 }
 
-// ListFiles returns a list of files in the specified directory
+// fileRoute keeps the older form of the action routes working, with the
+// action as the last path segment (e.g. /api/files/d/merkle): requests for
+// paths that don't exist but end in a known action go to that action's
+// handler, with the action stripped from the path parameter. Everything
+// else, including files named like an action, goes to defaultHandler.
+func fileRoute(fileSystem *fs.DistributedFileSystem, defaultHandler gin.HandlerFunc, actions map[string]gin.HandlerFunc) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		path := ctx.Param("path")
+		if fileSystem.Exists(path) {
+			defaultHandler(ctx)
+			return
+		}
+		for action, handler := range actions {
+			if !strings.HasSuffix(path, "/"+action) {
+				continue
+			}
+			
+			for i := range ctx.Params {
+				if ctx.Params[i].Key == "path" {
+					ctx.Params[i].Value = strings.TrimSuffix(path, "/"+action)
+				}
+			}
+			handler(ctx)
+			return
+		}
+		
+		defaultHandler(ctx)
+	}
+}
+
+// checkLocks rejects the request with 423 Locked if any of the paths is
+// locked by someone other than the caller
+func (c *Controller) checkLocks(ctx *gin.Context, paths ...string) bool {
+	holder := ctx.GetHeader(lockTokenHeader)
+	for _, path := range paths {
+		if err := c.Locks.Check(path, holder); err != nil {
+			ctx.JSON(http.StatusLocked, gin.H{"error": err.Error(), "path": path})
+			return false
+		}
+	}
+	return true
+}
+
+// ListFiles returns a list of files in the specified directory, paginated
+// with ?limit=, ?offset=, ?sort= and ?order=
 func (c *Controller) ListFiles(ctx *gin.Context) {
 	dirPath := ctx.DefaultQuery("path", "/")
 	
-	files, err := c.FS.ListFiles(dirPath)
+	params, err := parsePageParams(ctx, fs.ListSortName, fs.ListSortSize, fs.ListSortModTime)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	// Recursive listings descend depth levels, 0 for the whole subtree
+	recursive := ctx.Query("recursive") == "true"
+	depth, err := strconv.Atoi(ctx.DefaultQuery("depth", "0"))
+	if err != nil || depth < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "depth must be a non-negative integer"})
+		return
+	}
+	
+	files, total, err := c.FS.ListFilesPaged(dirPath, fs.ListOptions{
+		Sort:       params.Sort,
+		Descending: params.Order == OrderDesc,
+		Offset:     params.Offset,
+		Limit:      params.Limit,
+		Recursive:  recursive,
+		Depth:      depth,
+	})
+	if errors.Is(err, fs.ErrTreeTooDeep) {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(errorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 	
-	ctx.JSON(http.StatusOK, files)
+	ctx.Header(TotalCountHeader, strconv.Itoa(total))
+	c.respond(ctx, http.StatusOK, files)
+}
+
+// SearchFiles finds files and directories by name below ?root=, matching
+// ?q= as a substring or, with ?glob=true, as a glob pattern
+func (c *Controller) SearchFiles(ctx *gin.Context) {
+	pattern := ctx.Query("q")
+	if pattern == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+	
+	limit := fs.DefaultSearchLimit
+	if raw := ctx.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > fs.MaxSearchLimit {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", fs.MaxSearchLimit)})
+			return
+		}
+		limit = n
+	}
+	
+	results, err := c.FS.Search(ctx.DefaultQuery("root", "/"), pattern, fs.SearchOptions{
+		Glob:          ctx.Query("glob") == "true",
+		CaseSensitive: ctx.Query("caseSensitive") == "true",
+		Limit:         limit,
+	})
+	if errors.Is(err, filepath.ErrBadPattern) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if results == nil {
+		results = []fs.FileInfo{}
+	}
+	
+	c.respond(ctx, http.StatusOK, results)
 }
 
 // GetFile returns information about a file or downloads it
 func (c *Controller) GetFile(ctx *gin.Context) {
 	filePath := ctx.Param("path")[1:] // Remove leading slash
 	download := ctx.DefaultQuery("download", "false") == "true"
+	inline := ctx.Query("inline") == "true"
 	
-	if download {
+	if download || inline {
+		// Send the client to a better placed replica if there is one
+		replicaURL, err := c.remoteReplicaURL(ctx, filePath)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if replicaURL != "" {
+			ctx.Redirect(http.StatusTemporaryRedirect, replicaURL)
+			return
+		}
+		
 		// Download the file
 		reader, err := c.FS.DownloadFile(filePath)
 		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			ctx.JSON(errorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
 		defer reader.Close()
 		
-		// Detect content type based on file extension
-		contentType := "application/octet-stream"
-		fileExt := filepath.Ext(filePath)
-		switch strings.ToLower(fileExt) {
-		case ".png":
-			contentType = "image/png"
-		case ".jpg", ".jpeg":
-			contentType = "image/jpeg"
-		case ".gif":
-			contentType = "image/gif"
-		case ".pdf":
-			contentType = "application/pdf"
-		case ".txt":
-			contentType = "text/plain"
-		case ".html", ".htm":
-			contentType = "text/html"
-		case ".mp3":
-			contentType = "audio/mpeg"
-		case ".mp4":
-			contentType = "video/mp4"
-		case ".json":
-			contentType = "application/json"
-		case ".xml":
-			contentType = "application/xml"
-		case ".zip":
-			contentType = "application/zip"
+		// Send the content type detected at upload, the extension tells
+		// for files we know nothing about
+		var checksum string
+		contentType := fs.DetectContentType(filePath, nil)
+		if fileInfo, err := c.FS.GetFileInfo(filePath); err == nil {
+			checksum = fileInfo.SHA256
+			if fileInfo.ContentType != "" {
+				contentType = fileInfo.ContentType
+			}
 		}
 		
-		// Set the content disposition header for download
-		ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filepath.Base(filePath)))
+		// Let browsers render the file with ?inline=true, sandboxed so
+		// uploaded HTML can't run scripts in the API's origin
+		disposition := "attachment"
+		if inline {
+			disposition = "inline"
+			ctx.Header("Content-Security-Policy", "sandbox")
+		}
+		ctx.Header("Content-Disposition", contentDisposition(disposition, filepath.Base(filePath)))
 		ctx.Header("Content-Type", contentType)
+		ctx.Header("X-Content-Type-Options", "nosniff")
+		
+		// Serve range requests through ServeContent so interrupted downloads
+		// can resume; the ETag lets If-Range refuse resuming a changed file
+		if file, ok := reader.(fs.StatReadSeeker); ok {
+			if stat, err := file.Stat(); err == nil {
+				ctx.Header("Accept-Ranges", "bytes")
+				ctx.Header("ETag", downloadETag(checksum, stat))
+				if ctx.GetHeader("Range") != "" {
+					if checksum != "" {
+						ctx.Header("X-Content-SHA256", checksum)
+					}
+					http.ServeContent(ctx.Writer, ctx.Request, stat.Name(), stat.ModTime(), file)
+					return
+				}
+			}
+		}
+		
+		// Send the checksum recorded at upload time if we have one, otherwise
+		// compute it while streaming and send it as a trailer
+		if checksum != "" {
+			ctx.Header("X-Content-SHA256", checksum)
+			c.streamContent(ctx, contentType, reader)
+			return
+		}
 		
-		ctx.DataFromReader(http.StatusOK, -1, contentType, reader, nil)
+		hash := sha256.New()
+		ctx.Header("Trailer", "X-Content-SHA256")
+		c.streamContent(ctx, contentType, io.TeeReader(reader, hash))
+		ctx.Writer.Header().Set("X-Content-SHA256", hex.EncodeToString(hash.Sum(nil)))
 	} else {
 		// Get file info
 		fileInfo, err := c.FS.GetFileInfo(filePath)
 		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			ctx.JSON(errorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
 		
-		ctx.JSON(http.StatusOK, fileInfo)
+		// Include where the replicas are and whether enough are healthy
+		if ctx.Query("replicas") == "true" {
+			c.respond(ctx, http.StatusOK, fileInfoWithReplicas{
+				FileInfo:     fileInfo,
+				ReplicaState: c.NodeManager.ReplicaHealth(filePath, fileInfo.Replicas),
+			})
+			return
+		}
+		
+		c.respond(ctx, http.StatusOK, fileInfo)
+	}
+}
+
+// fileInfoWithReplicas is the file info returned with ?replicas=true
+type fileInfoWithReplicas struct {
+	*fs.FileInfo
+	ReplicaState node.ReplicaReport `json:"replicaState"`
+}
+
+// streamContent writes a download body through the configured copy
+// buffer. The copy stops as soon as the client disconnects so the caller
+// can release the reader.
+func (c *Controller) streamContent(ctx *gin.Context, contentType string, reader io.Reader) {
+	ctx.Header("Content-Type", contentType)
+	ctx.Status(http.StatusOK)
+	fs.CopyBufferContext(ctx.Request.Context(), ctx.Writer, reader, c.FS.CopyBufferSize())
+}
+
+// remoteReplicaURL picks the replica a download should be served from
+// according to the read preference (the default, or the "read" query
+// parameter) and returns its download URL, or "" to serve it locally
+func (c *Controller) remoteReplicaURL(ctx *gin.Context, filePath string) (string, error) {
+	// Requests redirected by another node are always served locally
+	if ctx.Query("local") == "true" {
+		return "", nil
+	}
+	
+	pref := c.Options.ReadPreference
+	if name := ctx.Query("read"); name != "" {
+		parsed, err := node.ParseReadPreference(name)
+		if err != nil {
+			return "", err
+		}
+		pref = parsed
+	}
+	
+	replica, err := c.NodeManager.SelectReadReplica(filePath, pref, c.Options.NodeID)
+	if err != nil || replica.ID == c.Options.NodeID {
+		return "", nil
+	}
+	
+	// Only nodes with an HTTP address can serve downloads
+	replicaURL, err := url.Parse(replica.Address)
+	if err != nil || (replicaURL.Scheme != "http" && replicaURL.Scheme != "https") {
+		return "", nil
+	}
+	
+	replicaURL.Path = "/api/files/" + filePath
+	query := url.Values{"download": {"true"}, "local": {"true"}}
+	if ctx.Query("inline") == "true" {
+		query.Set("inline", "true")
+	}
+	replicaURL.RawQuery = query.Encode()
+	
+	return replicaURL.String(), nil
+}
+
+// contentDisposition builds an RFC 6266 Content-Disposition header value
+// with a quoted ASCII fallback filename and a UTF-8 encoded filename*
+func contentDisposition(disposition, name string) string {
+	// Drop control characters, they could be used for header injection
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	
+	var fallback, encoded strings.Builder
+	for _, r := range name {
+		switch {
+		case r == '"' || r == '\\':
+			fallback.WriteRune('\\')
+			fallback.WriteRune(r)
+		case r > 0x7e:
+			fallback.WriteRune('_')
+		default:
+			fallback.WriteRune(r)
+		}
+	}
+	
+	// Percent-encode everything outside the RFC 5987 attr-char set
+	for _, b := range []byte(name) {
+		if isAttrChar(b) {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	
+	return fmt.Sprintf("%s; filename=\"%s\"; filename*=UTF-8''%s", disposition, fallback.String(), encoded.String())
+}
+
+// isAttrChar reports whether b may appear unencoded in an RFC 5987 value
+func isAttrChar(b byte) bool {
+	switch {
+	case b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z', b >= '0' && b <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", b) >= 0
+}
+
+// downloadETag builds a strong ETag from the content hash when known, or
+// from the file's modification time and size otherwise
+func downloadETag(checksum string, stat os.FileInfo) string {
+	if checksum != "" {
+		return fmt.Sprintf("\"%s\"", checksum)
+	}
+	return fmt.Sprintf("\"%x-%x\"", stat.ModTime().UnixNano(), stat.Size())
+}
+
+// errorStatus maps a file system error to the matching HTTP status code
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, fs.ErrIsDirectory):
+		return http.StatusConflict
+	case errors.Is(err, fs.ErrParentNotFound):
+		return http.StatusConflict
+	case errors.Is(err, fs.ErrPathConflict):
+		return http.StatusConflict
+	case errors.Is(err, fs.ErrLocked):
+		return http.StatusLocked
+	case errors.Is(err, fs.ErrReplicaSizeExceeded):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, fs.ErrRootPath):
+		return http.StatusForbidden
+	case errors.Is(err, fs.ErrDirNotEmpty), errors.Is(err, fs.ErrNotDirectory):
+		return http.StatusConflict
+	case errors.Is(err, os.ErrExist):
+		return http.StatusConflict
+	case errors.Is(err, fs.ErrUploadRejected):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, fs.ErrOutsideRoot):
+		return http.StatusBadRequest
+	case errors.Is(err, fs.ErrReservedPath):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
 	}
 }
 
@@ -127,9 +478,22 @@ func (c *Controller) GetFile(ctx *gin.Context) {
 func (c *Controller) UploadFile(ctx *gin.Context) {
 	filePath := ctx.Param("path")[1:] // Remove leading slash
 	
+	if !c.checkLocks(ctx, filePath) {
+		return
+	}
+	
+	if !c.decodeRequestBody(ctx) {
+		return
+	}
+	
 	// Get the file from the form
 	file, err := ctx.FormFile("file")
 	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("decompressed upload exceeds %d bytes", tooLarge.Limit)})
+			return
+		}
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "No file provided"})
 		return
 	}
@@ -142,27 +506,172 @@ func (c *Controller) UploadFile(ctx *gin.Context) {
 	}
 	defer src.Close()
 	
+	// Let the request override whether missing parent directories are created
+	opts := c.FS.DefaultUploadOptions()
+	if mkdir := ctx.Query("mkdir"); mkdir != "" {
+		opts.CreateParents = mkdir == "true"
+	}
+	
 	// Upload the file
-	err = c.FS.UploadFile(filePath, src)
+	err = c.FS.UploadFileWithOptions(filePath, src, opts)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		ctx.JSON(errorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 	
 	ctx.JSON(http.StatusOK, gin.H{"message": "File uploaded successfully"})
 }
 
+// decodeRequestBody replaces a gzip-encoded request body with its
+// decompressed content, capped at MaxDecompressedSize to defuse
+// decompression bombs
+func (c *Controller) decodeRequestBody(ctx *gin.Context) bool {
+	switch strings.ToLower(ctx.GetHeader("Content-Encoding")) {
+	case "", "identity":
+		return true
+	case "gzip":
+	default:
+		ctx.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "unsupported content encoding"})
+		return false
+	}
+	
+	gz, err := gzip.NewReader(ctx.Request.Body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid gzip body: " + err.Error()})
+		return false
+	}
+	
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, gz, c.Options.MaxDecompressedSize)
+	ctx.Request.ContentLength = -1
+	ctx.Request.Header.Del("Content-Encoding")
+	
+	return true
+}
+
 // DeleteFile deletes a file or directory
 func (c *Controller) DeleteFile(ctx *gin.Context) {
 	filePath := ctx.Param("path")[1:] // Remove leading slash
 	
+	if !c.checkLocks(ctx, filePath) {
+		return
+	}
+	
+	if ctx.Query("recursive") == "true" {
+		c.deleteDirectory(ctx, filePath)
+		return
+	}
+	
 	err := c.FS.DeleteFile(filePath)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		ctx.JSON(errorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 	
-	ctx.JSON(http.StatusOK, gin.H{"message": "File deleted successfully"})
+	// Remove the replicas on other nodes, unreachable ones are retried
+	// when they reconnect
+	replicas := c.NodeManager.DeleteReplicas(filePath, c.Options.NodeID)
+	
+	ctx.JSON(http.StatusOK, gin.H{"message": "File deleted successfully", "replicas": replicas})
+}
+
+// deleteDirectory deletes a directory with everything below it, along
+// with the replicas of its files on other nodes
+func (c *Controller) deleteDirectory(ctx *gin.Context, dirPath string) {
+	var files []string
+	err := c.FS.WalkDirectory(dirPath, func(info fs.FileInfo) error {
+		files = append(files, info.Path)
+		return nil
+	})
+	if err != nil {
+		ctx.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	
+	if !c.checkLocks(ctx, files...) {
+		return
+	}
+	
+	if err := c.FS.DeleteDirectoryRecursive(dirPath); err != nil {
+		ctx.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	
+	replicas := make(map[string]node.ReplicaDeleteReport, len(files))
+	for _, file := range files {
+		replicas[file] = c.NodeManager.DeleteReplicas(file, c.Options.NodeID)
+	}
+	
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":  "Directory deleted successfully",
+		"files":    len(files),
+		"replicas": replicas,
+	})
+}
+
+// LockFile acquires or renews an advisory lock on a file
+func (c *Controller) LockFile(ctx *gin.Context) {
+	filePath := ctx.Param("path")[1:] // Remove leading slash
+	
+	var request struct {
+		Holder string `json:"holder"`
+		TTL    int    `json:"ttl"` // Seconds
+	}
+	
+	// The body is optional, a token and default TTL are used if missing
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindJSON(&request); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	
+	if request.Holder == "" {
+		request.Holder = ctx.GetHeader(lockTokenHeader)
+	}
+	if request.Holder == "" {
+		request.Holder = uuid.New().String()
+	}
+	
+	ttl := defaultLockTTL
+	if request.TTL > 0 {
+		ttl = time.Duration(request.TTL) * time.Second
+	}
+	
+	lock, err := c.Locks.Acquire(filePath, request.Holder, ttl)
+	if err != nil {
+		if errors.Is(err, fs.ErrLocked) {
+			ctx.JSON(http.StatusLocked, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, fs.ErrRootPath) {
+			ctx.JSON(errorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	ctx.JSON(http.StatusOK, lock)
+}
+
+// UnlockFile releases an advisory lock on a file
+func (c *Controller) UnlockFile(ctx *gin.Context) {
+	filePath := ctx.Param("path")[1:] // Remove leading slash
+	
+	err := c.Locks.Release(filePath, ctx.GetHeader(lockTokenHeader))
+	if err != nil {
+		switch {
+		case errors.Is(err, fs.ErrNotLocked):
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, fs.ErrLockHolder):
+			ctx.JSON(http.StatusLocked, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	
+	ctx.JSON(http.StatusOK, gin.H{"message": "Lock released successfully"})
 }
 
 // MoveFile moves a file from one location to another
@@ -175,22 +684,111 @@ func (c *Controller) MoveFile(ctx *gin.Context) {
 		return
 	}
 	
+	// Moving a directory carries the files below it along, none of them
+	// may be locked either
+	paths := []string{sourcePath, destPath}
+	if info, err := c.FS.GetFileInfo(sourcePath); err == nil && info.IsDir {
+		err := c.FS.WalkDirectory(sourcePath, func(info fs.FileInfo) error {
+			paths = append(paths, info.Path)
+			return nil
+		})
+		if err != nil {
+			ctx.JSON(errorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+	}
+	
+	if !c.checkLocks(ctx, paths...) {
+		return
+	}
+	
 	err := c.FS.MoveFile(sourcePath, destPath)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		ctx.JSON(errorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 	
 	ctx.JSON(http.StatusOK, gin.H{"message": "File moved successfully"})
 }
 
+// CopyFile copies a file to a new path, keeping the original
+func (c *Controller) CopyFile(ctx *gin.Context) {
+	destPath := ctx.Param("path")[1:] // Remove leading slash
+	sourcePath := ctx.Query("source")
+	
+	if sourcePath == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Source path not provided"})
+		return
+	}
+	
+	if !c.checkLocks(ctx, destPath) {
+		return
+	}
+	
+	err := c.FS.CopyFileWithOptions(sourcePath, destPath, fs.CopyOptions{
+		Overwrite: ctx.Query("overwrite") == "true",
+	})
+	if err != nil {
+		ctx.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	
+	ctx.JSON(http.StatusCreated, gin.H{"message": "File copied successfully"})
+}
+
+// GetMerkleTree returns the Merkle tree of a subtree so peers can compare
+// roots and only descend into the parts that differ
+func (c *Controller) GetMerkleTree(ctx *gin.Context) {
+	dirPath := strings.TrimPrefix(ctx.Param("path"), "/")
+	
+	depth, err := strconv.Atoi(ctx.DefaultQuery("depth", "1"))
+	if err != nil || depth < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "depth must be a non-negative integer"})
+		return
+	}
+	
+	tree, err := c.FS.MerkleTree(dirPath, depth)
+	if err != nil {
+		ctx.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	
+	ctx.JSON(http.StatusOK, tree)
+}
+
+// GetChecksum returns the digest of a file's content in the algorithm
+// chosen with ?algo= (sha256, sha1 or md5)
+func (c *Controller) GetChecksum(ctx *gin.Context) {
+	filePath := strings.TrimPrefix(ctx.Param("path"), "/")
+	algo := strings.ToLower(ctx.Query("algo"))
+	if algo == "" {
+		algo = fs.ChecksumSHA256
+	}
+	
+	checksum, err := c.FS.Checksum(filePath, algo)
+	if errors.Is(err, fs.ErrUnsupportedAlgorithm) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	
+	ctx.JSON(http.StatusOK, gin.H{
+		"path":      filePath,
+		"algorithm": algo,
+		"checksum":  checksum,
+	})
+}
+
 // CreateDirectory creates a new directory
 func (c *Controller) CreateDirectory(ctx *gin.Context) {
 	dirPath := ctx.Param("path")[1:] // Remove leading slash
 	
 	err := c.FS.CreateDirectory(dirPath)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		ctx.JSON(errorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 	
@@ -215,7 +813,7 @@ func (c *Controller) SetReplicationFactor(ctx *gin.Context) {
 	
 	err = c.FS.SetReplicationFactor(filePath, replicas)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		ctx.JSON(errorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 	
@@ -226,26 +824,91 @@ func (c *Controller) SetReplicationFactor(ctx *gin.Context) {
 		return
 	}
 	
-	optimalNodes := c.NodeManager.GetOptimalStorageNodes(fileInfo.Size, replicas)
+	// Directories hold no data, their factor is a default for new files
+	if fileInfo.IsDir {
+		ctx.JSON(http.StatusOK, gin.H{
+			"message":  "Directory replication policy set successfully",
+			"path":     filePath,
+			"replicas": replicas,
+		})
+		return
+	}
+	
+	placement := c.NodeManager.SelectStorageNodesDetailed(node.PlacementRequest{
+		Key:      filePath,
+		FileSize: fileInfo.Size,
+		Replicas: replicas,
+		Labels:   ctx.QueryMap("labels"),
+	})
+	optimalNodes := make([]string, len(placement.Selected))
+	for i, selected := range placement.Selected {
+		optimalNodes[i] = selected.ID
+	}
+	c.NodeManager.RecordPlacement(filePath, optimalNodes)
 	
 	ctx.JSON(http.StatusOK, gin.H{
-		"message": "Replication factor set successfully",
-		"nodes":   optimalNodes,
+		"message":   "Replication factor set successfully",
+		"nodes":     optimalNodes,
+		"placement": placement,
 	})
 }
 
+// ListReplicationPolicies returns the replication factors new files
+// inherit from their directory
+func (c *Controller) ListReplicationPolicies(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"policies": c.FS.ReplicationPolicies()})
+}
+
+// RemoveReplicationPolicy removes the replication policy of a directory
+func (c *Controller) RemoveReplicationPolicy(ctx *gin.Context) {
+	dirPath := ctx.Param("path")[1:] // Remove leading slash
+	
+	if err := c.FS.RemoveReplicationPolicy(dirPath); err != nil {
+		ctx.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	
+	ctx.JSON(http.StatusOK, gin.H{"message": "Replication policy removed successfully"})
+}
+
+// GetReplicaSizePolicy returns the maximum file size per replication factor tier
+func (c *Controller) GetReplicaSizePolicy(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"tiers": c.FS.ReplicaSizePolicy()})
+}
+
+// SetReplicaSizePolicy replaces the maximum file size per replication factor tier
+func (c *Controller) SetReplicaSizePolicy(ctx *gin.Context) {
+	var request struct {
+		Tiers []fs.ReplicaSizeTier `json:"tiers"`
+	}
+	
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	if err := c.FS.SetReplicaSizePolicy(request.Tiers); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	ctx.JSON(http.StatusOK, gin.H{"tiers": c.FS.ReplicaSizePolicy()})
+}
+
 // ListNodes returns a list of all nodes
 func (c *Controller) ListNodes(ctx *gin.Context) {
 	nodes := c.NodeManager.ListNodes()
-	ctx.JSON(http.StatusOK, nodes)
+	c.respond(ctx, http.StatusOK, nodes)
 }
 
 // RegisterNode registers a new node or updates an existing one
 func (c *Controller) RegisterNode(ctx *gin.Context) {
 	var request struct {
-		ID         string `json:"id"`
-		Address    string `json:"address"`
-		StorageMax int64  `json:"storageMax"`
+		ID         string            `json:"id"`
+		Address    string            `json:"address"`
+		StorageMax byteCount         `json:"storageMax"`
+		Labels     map[string]string `json:"labels"`
+		Zone       string            `json:"zone"`
 	}
 	
 	if err := ctx.ShouldBindJSON(&request); err != nil {
@@ -258,13 +921,24 @@ func (c *Controller) RegisterNode(ctx *gin.Context) {
 		request.ID = uuid.New().String()
 	}
 	
-	node, err := c.NodeManager.RegisterNode(request.ID, request.Address, request.StorageMax)
+	node, created, err := c.NodeManager.RegisterNodeWithOptions(request.ID, request.Address, node.RegisterOptions{
+		StorageMax: int64(request.StorageMax),
+		Zone:       request.Zone,
+		Labels:     request.Labels,
+	})
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	
-	ctx.JSON(http.StatusOK, node)
+	// 201 for a first registration, 200 when an existing node was updated
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+		ctx.Header("Location", "/api/nodes/"+node.ID)
+	}
+	
+	c.respond(ctx, status, node)
 }
 
 // GetNode returns a node by its ID
@@ -277,7 +951,7 @@ func (c *Controller) GetNode(ctx *gin.Context) {
 		return
 	}
 	
-	ctx.JSON(http.StatusOK, node)
+	c.respond(ctx, http.StatusOK, node)
 }
 
 // UpdateNodeStatus updates the status of a node
@@ -307,7 +981,7 @@ func (c *Controller) UpdateNodeStorage(ctx *gin.Context) {
 	id := ctx.Param("id")
 	
 	var request struct {
-		StorageUsed int64 `json:"storageUsed"`
+		StorageUsed byteCount `json:"storageUsed"`
 	}
 	
 	if err := ctx.ShouldBindJSON(&request); err != nil {
@@ -315,7 +989,7 @@ func (c *Controller) UpdateNodeStorage(ctx *gin.Context) {
 		return
 	}
 	
-	err := c.NodeManager.UpdateNodeStorage(id, request.StorageUsed)
+	err := c.NodeManager.UpdateNodeStorage(id, int64(request.StorageUsed))
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -350,16 +1024,45 @@ func (c *Controller) HeartbeatNode(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{"message": "Heartbeat received"})
 }
 
+// StartDrain starts moving all replicas off a node
+func (c *Controller) StartDrain(ctx *gin.Context) {
+	id := ctx.Param("id")
+	
+	drain, err := c.NodeManager.StartDrain(id)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	ctx.JSON(http.StatusAccepted, drain)
+}
+
+// GetDrainStatus returns the progress of a node drain
+func (c *Controller) GetDrainStatus(ctx *gin.Context) {
+	id := ctx.Param("id")
+	
+	drain, err := c.NodeManager.GetDrainStatus(id)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	
+	ctx.JSON(http.StatusOK, drain)
+}
+
 // GetSystemStatus returns the overall system status
 func (c *Controller) GetSystemStatus(ctx *gin.Context) {
 	nodes := c.NodeManager.ListNodes()
 	
-	var totalStorage, usedStorage int64
+	reserve := c.NodeManager.StorageReserve()
+	
+	var totalStorage, usedStorage, usableStorage int64
 	var activeNodes, inactiveNodes, failedNodes int
 	
 	for _, node := range nodes {
 		totalStorage += node.StorageMax
 		usedStorage += node.StorageUsed
+		usableStorage += reserve.UsableCapacity(node.StorageMax)
 		
 		switch node.Status {
 		case "active":
@@ -371,13 +1074,20 @@ func (c *Controller) GetSystemStatus(ctx *gin.Context) {
 		}
 	}
 	
-	ctx.JSON(http.StatusOK, gin.H{
-		"totalNodes":      len(nodes),
-		"activeNodes":     activeNodes,
-		"inactiveNodes":   inactiveNodes,
-		"failedNodes":     failedNodes,
-		"totalStorage":    totalStorage,
-		"usedStorage":     usedStorage,
+	c.respond(ctx, http.StatusOK, gin.H{
+		"totalNodes":       len(nodes),
+		"activeNodes":      activeNodes,
+		"inactiveNodes":    inactiveNodes,
+		"failedNodes":      failedNodes,
+		"totalStorage":     totalStorage,
+		"usedStorage":      usedStorage,
 		"availableStorage": totalStorage - usedStorage,
+		"usableStorage":    usableStorage, // Capacity minus the free space reserve
+		"storageReserve":   reserve,
 	})
 }
+
+// GetIOStats returns the upload and download throughput of this node
+func (c *Controller) GetIOStats(ctx *gin.Context) {
+	c.respond(ctx, http.StatusOK, c.FS.IOStats())
+}
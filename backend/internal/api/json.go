@@ -0,0 +1,103 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+)
+
+// byteFields are the JSON keys holding byte counts that may exceed the
+// 2^53 precision limit of JavaScript numbers
+var byteFields = map[string]bool{
+	"size":             true,
+	"storageMax":       true,
+	"storageUsed":      true,
+	"totalStorage":     true,
+	"usedStorage":      true,
+	"availableStorage": true,
+	"bytesRead":        true,
+	"bytesWritten":     true,
+}
+
+// respond writes obj as JSON, or as MessagePack when the client asks for it
+// in its Accept header. Byte count fields are encoded as strings when the
+// controller is configured to do so.
+func (c *Controller) respond(ctx *gin.Context, status int, obj interface{}) {
+	ctx.Header("Vary", "Accept")
+	msgpack := wantsMsgPack(ctx)
+
+	if !c.Options.ByteFieldsAsStrings && !msgpack {
+		ctx.JSON(status, obj)
+		return
+	}
+
+	// Go through JSON so both formats carry the same keys and values
+	data, err := json.Marshal(obj)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Options.ByteFieldsAsStrings {
+		value = stringifyByteFields(value)
+	}
+	if msgpack {
+		ctx.Render(status, render.MsgPack{Data: msgpackValue(value)})
+		return
+	}
+	ctx.JSON(status, value)
+}
+
+// stringifyByteFields walks a decoded JSON value and turns numeric byte
+// count fields into strings
+func stringifyByteFields(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if num, ok := field.(json.Number); ok && byteFields[key] {
+				v[key] = num.String()
+				continue
+			}
+			v[key] = stringifyByteFields(field)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = stringifyByteFields(item)
+		}
+	}
+	return value
+}
+
+// byteCount is an int64 that can be bound from either a JSON number or a
+// JSON string, so clients can send values above 2^53 without losing precision
+type byteCount int64
+
+// UnmarshalJSON implements json.Unmarshaler
+func (b *byteCount) UnmarshalJSON(data []byte) error {
+	text := string(data)
+	if unquoted, err := strconv.Unquote(text); err == nil {
+		text = unquoted
+	}
+
+	value, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid byte count %s", string(data))
+	}
+
+	*b = byteCount(value)
+	return nil
+}
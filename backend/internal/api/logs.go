@@ -0,0 +1,291 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// logSubscriberBuffer is how many entries a log subscriber may fall behind
+// before it is disconnected
+const logSubscriberBuffer = 256
+
+// LogEntry is a log record kept by a LogHub
+type LogEntry struct {
+	Time    time.Time              `json:"time"`
+	Level   string                 `json:"level"`
+	Message string                 `json:"msg"`
+	Attrs   map[string]interface{} `json:"attrs,omitempty"`
+}
+
+// logSubscriber receives live log entries at or above a level
+type logSubscriber struct {
+	entries chan LogEntry
+	level   slog.Level
+}
+
+// LogHub keeps the most recent log entries in a bounded ring and fans new
+// entries out to live subscribers. Subscribers that fall too far behind
+// are disconnected rather than slowing down logging.
+type LogHub struct {
+	mu          sync.Mutex
+	ring        []LogEntry
+	start       int // Index of the oldest entry in ring
+	size        int
+	subscribers map[*logSubscriber]struct{}
+}
+
+// NewLogHub creates a log hub keeping up to capacity recent entries
+func NewLogHub(capacity int) *LogHub {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &LogHub{
+		ring:        make([]LogEntry, capacity),
+		subscribers: make(map[*logSubscriber]struct{}),
+	}
+}
+
+// Handler returns a slog handler passing records to next and tee'ing them
+// into the hub
+func (h *LogHub) Handler(next slog.Handler) slog.Handler {
+	return &logTee{next: next, hub: h}
+}
+
+// publish stores an entry and hands it to the subscribers
+func (h *LogHub) publish(entry LogEntry, level slog.Level) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.size < len(h.ring) {
+		h.ring[(h.start+h.size)%len(h.ring)] = entry
+		h.size++
+	} else {
+		h.ring[h.start] = entry
+		h.start = (h.start + 1) % len(h.ring)
+	}
+
+	for sub := range h.subscribers {
+		if level < sub.level {
+			continue
+		}
+		select {
+		case sub.entries <- entry:
+		default:
+			// Too slow, drop the subscriber so logging never blocks
+			delete(h.subscribers, sub)
+			close(sub.entries)
+		}
+	}
+}
+
+// Recent returns the kept entries at or above level, oldest first
+func (h *LogHub) Recent(level slog.Level) []LogEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.recent(level)
+}
+
+// recent implements Recent. Callers must hold h.mu.
+func (h *LogHub) recent(level slog.Level) []LogEntry {
+	entries := make([]LogEntry, 0, h.size)
+	for i := 0; i < h.size; i++ {
+		entry := h.ring[(h.start+i)%len(h.ring)]
+		if entryLevel(entry) >= level {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Subscribe returns the kept entries at or above level and a channel
+// receiving new ones. The channel is closed when the subscriber falls
+// behind or cancel is called.
+func (h *LogHub) Subscribe(level slog.Level) ([]LogEntry, <-chan LogEntry, func()) {
+	sub := &logSubscriber{
+		entries: make(chan LogEntry, logSubscriberBuffer),
+		level:   level,
+	}
+
+	h.mu.Lock()
+	recent := h.recent(level)
+	h.subscribers[sub] = struct{}{}
+	h.mu.Unlock()
+
+	cancel := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subscribers[sub]; ok {
+			delete(h.subscribers, sub)
+			close(sub.entries)
+		}
+	}
+
+	return recent, sub.entries, cancel
+}
+
+// entryLevel parses the level of a stored entry
+func entryLevel(entry LogEntry) slog.Level {
+	var level slog.Level
+	level.UnmarshalText([]byte(entry.Level))
+	return level
+}
+
+// logTee is a slog handler feeding a LogHub
+type logTee struct {
+	next   slog.Handler
+	hub    *LogHub
+	attrs  map[string]interface{}
+	prefix string // Group prefix for attribute keys
+}
+
+// Enabled implements slog.Handler
+func (t *logTee) Enabled(ctx context.Context, level slog.Level) bool {
+	return t.next.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler
+func (t *logTee) Handle(ctx context.Context, record slog.Record) error {
+	entry := LogEntry{
+		Time:    record.Time,
+		Level:   record.Level.String(),
+		Message: record.Message,
+	}
+	if len(t.attrs) > 0 || record.NumAttrs() > 0 {
+		entry.Attrs = make(map[string]interface{}, len(t.attrs)+record.NumAttrs())
+		for k, v := range t.attrs {
+			entry.Attrs[k] = v
+		}
+		record.Attrs(func(attr slog.Attr) bool {
+			addLogAttr(entry.Attrs, t.prefix, attr)
+			return true
+		})
+	}
+
+	t.hub.publish(entry, record.Level)
+
+	return t.next.Handle(ctx, record)
+}
+
+// WithAttrs implements slog.Handler
+func (t *logTee) WithAttrs(attrs []slog.Attr) slog.Handler {
+	merged := make(map[string]interface{}, len(t.attrs)+len(attrs))
+	for k, v := range t.attrs {
+		merged[k] = v
+	}
+	for _, attr := range attrs {
+		addLogAttr(merged, t.prefix, attr)
+	}
+	return &logTee{next: t.next.WithAttrs(attrs), hub: t.hub, attrs: merged, prefix: t.prefix}
+}
+
+// WithGroup implements slog.Handler
+func (t *logTee) WithGroup(name string) slog.Handler {
+	return &logTee{next: t.next.WithGroup(name), hub: t.hub, attrs: t.attrs, prefix: t.prefix + name + "."}
+}
+
+// addLogAttr adds an attribute to attrs, flattening groups into dotted keys
+func addLogAttr(attrs map[string]interface{}, prefix string, attr slog.Attr) {
+	value := attr.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindGroup:
+		groupPrefix := prefix
+		if attr.Key != "" {
+			groupPrefix += attr.Key + "."
+		}
+		for _, member := range value.Group() {
+			addLogAttr(attrs, groupPrefix, member)
+		}
+	case slog.KindString, slog.KindInt64, slog.KindUint64, slog.KindFloat64, slog.KindBool, slog.KindTime:
+		attrs[prefix+attr.Key] = value.Any()
+	default:
+		attrs[prefix+attr.Key] = value.String()
+	}
+}
+
+// SetupLogRoutes adds the log streaming route to the admin routes
+func SetupLogRoutes(router *gin.Engine, hub *LogHub) {
+	// Stream recent and new log entries as NDJSON, or as server-sent events
+	// with ?format=sse or Accept: text/event-stream. ?level= filters by the
+	// minimum level and ?follow=false returns only the recent entries.
+	router.GET("/api/admin/logs", func(c *gin.Context) {
+		level := slog.LevelDebug
+		if value := c.Query("level"); value != "" {
+			if err := level.UnmarshalText([]byte(value)); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "level must be debug, info, warn or error"})
+				return
+			}
+		}
+
+		sse := c.Query("format") == "sse" || strings.Contains(c.GetHeader("Accept"), "text/event-stream")
+		if format := c.Query("format"); format != "" && format != "sse" && format != "ndjson" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format must be ndjson or sse"})
+			return
+		}
+
+		if sse {
+			c.Header("Content-Type", "text/event-stream")
+			c.Header("Cache-Control", "no-cache")
+		} else {
+			c.Header("Content-Type", "application/x-ndjson")
+		}
+		c.Status(http.StatusOK)
+
+		if c.Query("follow") == "false" {
+			for _, entry := range hub.Recent(level) {
+				if writeLogEntry(c.Writer, entry, sse) != nil {
+					return
+				}
+			}
+			return
+		}
+
+		recent, entries, cancel := hub.Subscribe(level)
+		defer cancel()
+
+		for _, entry := range recent {
+			if writeLogEntry(c.Writer, entry, sse) != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+
+		for {
+			select {
+			case <-c.Request.Context().Done():
+				return
+			case entry, ok := <-entries:
+				if !ok {
+					return // Fell behind, the client may reconnect
+				}
+				if writeLogEntry(c.Writer, entry, sse) != nil {
+					return
+				}
+				c.Writer.Flush()
+			}
+		}
+	})
+}
+
+// writeLogEntry writes an entry as an NDJSON line or server-sent event
+func writeLogEntry(w gin.ResponseWriter, entry LogEntry, sse bool) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if sse {
+		_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+	} else {
+		_, err = fmt.Fprintf(w, "%s\n", data)
+	}
+	return err
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/user/distfs/internal/fs"
+	"github.com/user/distfs/internal/node"
+)
+
+// requestDurationBuckets are the upper bounds in seconds of the request
+// duration histogram
+var requestDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// nodeStatuses are the node statuses always reported, even without nodes
+var nodeStatuses = []string{"active", "inactive", "failed"}
+
+// RequestMetrics counts HTTP requests and their durations by route
+type RequestMetrics struct {
+	requests  *prometheus.CounterVec
+	durations *prometheus.HistogramVec
+}
+
+// NewRequestMetrics creates empty request metrics
+func NewRequestMetrics() *RequestMetrics {
+	return &RequestMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "filego_http_requests_total",
+			Help: "HTTP requests by method, route and status code.",
+		}, []string{"method", "route", "code"}),
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "filego_http_request_duration_seconds",
+			Help:    "HTTP request durations by method and route, including uploads and downloads.",
+			Buckets: requestDurationBuckets,
+		}, []string{"method", "route"}),
+	}
+}
+
+// Middleware records every request. Requests are grouped by the route
+// pattern they matched, so path parameters don't create new series.
+func (m *RequestMetrics) Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		ctx.Next()
+		m.observe(ctx.Request.Method, ctx.FullPath(), ctx.Writer.Status(), time.Since(start))
+	}
+}
+
+// observe records a request
+func (m *RequestMetrics) observe(method, route string, code int, duration time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	m.requests.WithLabelValues(method, route, strconv.Itoa(code)).Inc()
+	m.durations.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+// SetupMetricsRoutes adds GET /metrics, serving metrics in the Prometheus
+// text format together with the Go runtime and process metrics. Values are
+// read when scraped, so they are always current. chunker and p2pNetwork
+// may be nil, their metrics are left out then.
+func SetupMetricsRoutes(router *gin.Engine, metrics *RequestMetrics, fileSystem *fs.DistributedFileSystem, nodeManager *node.NodeManager, chunker *fs.FileChunker, p2pNetwork *node.P2PNetwork) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		metrics.requests,
+		metrics.durations,
+		newStateCollector(fileSystem, nodeManager, chunker, p2pNetwork),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	router.GET("/metrics", gin.WrapH(promhttp.HandlerFor(registry, promhttp.HandlerOpts{})))
+}
+
+// stateCollector reports the state of the file system, chunker, nodes and
+// P2P network, read from their own statistics on every scrape
+type stateCollector struct {
+	fs          *fs.DistributedFileSystem
+	nodeManager *node.NodeManager
+	chunker     *fs.FileChunker
+	p2p         *node.P2PNetwork
+
+	uploads, uploadBytes, downloads, downloadBytes                                *prometheus.Desc
+	chunkReads, chunkWrites, cacheHits, cacheMisses, chunkedBytes, uniqueChunks   *prometheus.Desc
+	nodes, nodeStorageUsed, nodeStorageMax, peersConnected, peers, sent, received *prometheus.Desc
+}
+
+// newStateCollector creates the collector, chunker and p2pNetwork may be nil
+func newStateCollector(fileSystem *fs.DistributedFileSystem, nodeManager *node.NodeManager, chunker *fs.FileChunker, p2pNetwork *node.P2PNetwork) *stateCollector {
+	return &stateCollector{
+		fs:          fileSystem,
+		nodeManager: nodeManager,
+		chunker:     chunker,
+		p2p:         p2pNetwork,
+
+		uploads:       prometheus.NewDesc("filego_uploads_total", "Uploads written.", nil, nil),
+		uploadBytes:   prometheus.NewDesc("filego_upload_bytes_total", "Bytes written by uploads.", nil, nil),
+		downloads:     prometheus.NewDesc("filego_downloads_total", "Downloads opened.", nil, nil),
+		downloadBytes: prometheus.NewDesc("filego_download_bytes_total", "Bytes read by downloads.", nil, nil),
+
+		chunkReads:   prometheus.NewDesc("filego_chunk_reads_total", "Chunks read, from the chunk cache or the chunk store.", nil, nil),
+		chunkWrites:  prometheus.NewDesc("filego_chunk_writes_total", "Chunks written to the chunk store.", nil, nil),
+		cacheHits:    prometheus.NewDesc("filego_chunk_cache_hits_total", "Chunk reads served by the chunk cache.", nil, nil),
+		cacheMisses:  prometheus.NewDesc("filego_chunk_cache_misses_total", "Chunk reads the chunk cache couldn't serve.", nil, nil),
+		chunkedBytes: prometheus.NewDesc("filego_chunked_bytes_total", "Bytes of file content split into chunks.", nil, nil),
+		uniqueChunks: prometheus.NewDesc("filego_unique_chunks_total", "Chunks with content that wasn't stored yet when they were chunked.", nil, nil),
+
+		nodes:           prometheus.NewDesc("filego_nodes", "Storage nodes by status.", []string{"status"}, nil),
+		nodeStorageUsed: prometheus.NewDesc("filego_node_storage_used_bytes", "Bytes stored on each storage node.", []string{"node"}, nil),
+		nodeStorageMax:  prometheus.NewDesc("filego_node_storage_max_bytes", "Storage capacity of each storage node.", []string{"node"}, nil),
+		peersConnected:  prometheus.NewDesc("filego_peers_connected", "Connected P2P peers.", nil, nil),
+		peers:           prometheus.NewDesc("filego_peers", "Known P2P peers, connected or not.", nil, nil),
+		sent:            prometheus.NewDesc("filego_p2p_messages_sent_total", "P2P messages sent by type.", []string{"type"}, nil),
+		received:        prometheus.NewDesc("filego_p2p_messages_received_total", "P2P messages received by type.", []string{"type"}, nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		c.uploads, c.uploadBytes, c.downloads, c.downloadBytes,
+		c.chunkReads, c.chunkWrites, c.cacheHits, c.cacheMisses, c.chunkedBytes, c.uniqueChunks,
+		c.nodes, c.nodeStorageUsed, c.nodeStorageMax, c.peersConnected, c.peers, c.sent, c.received,
+	} {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *stateCollector) Collect(ch chan<- prometheus.Metric) {
+	counter := func(desc *prometheus.Desc, value float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, value, labels...)
+	}
+	gauge := func(desc *prometheus.Desc, value float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labels...)
+	}
+
+	io := c.fs.IOStats()
+	counter(c.uploads, float64(io.Writes))
+	counter(c.uploadBytes, float64(io.BytesWritten))
+	counter(c.downloads, float64(io.Reads))
+	counter(c.downloadBytes, float64(io.BytesRead))
+
+	if c.chunker != nil {
+		stats := c.chunker.Stats()
+		counter(c.chunkReads, float64(stats.ChunkReads))
+		counter(c.chunkWrites, float64(stats.ChunkWrites))
+		counter(c.cacheHits, float64(stats.CacheHits))
+		counter(c.cacheMisses, float64(stats.CacheMisses))
+		counter(c.chunkedBytes, float64(stats.TotalBytes))
+		counter(c.uniqueChunks, float64(stats.UniqueChunks))
+	}
+
+	byStatus := make(map[string]int)
+	for _, status := range nodeStatuses {
+		byStatus[status] = 0
+	}
+	for _, n := range c.nodeManager.ListNodes() {
+		byStatus[n.Status]++
+		gauge(c.nodeStorageUsed, float64(n.StorageUsed), n.ID)
+		gauge(c.nodeStorageMax, float64(n.StorageMax), n.ID)
+	}
+	for status, count := range byStatus {
+		gauge(c.nodes, float64(count), status)
+	}
+
+	if c.p2p != nil {
+		peers := c.p2p.GetPeers()
+		connected := 0
+		for _, peer := range peers {
+			if peer.IsActive {
+				connected++
+			}
+		}
+		gauge(c.peersConnected, float64(connected))
+		gauge(c.peers, float64(len(peers)))
+
+		messages := c.p2p.MessageStats()
+		for msgType, count := range messages.Sent {
+			counter(c.sent, float64(count), msgType)
+		}
+		for msgType, count := range messages.Received {
+			counter(c.received, float64(count), msgType)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// firstByteWriter records when the first byte of the response is written
+type firstByteWriter struct {
+	gin.ResponseWriter
+	firstByte time.Time
+}
+
+// WriteHeader implements http.ResponseWriter
+func (w *firstByteWriter) WriteHeader(code int) {
+	w.mark()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements http.ResponseWriter
+func (w *firstByteWriter) Write(data []byte) (int, error) {
+	w.mark()
+	return w.ResponseWriter.Write(data)
+}
+
+// WriteString implements gin.ResponseWriter
+func (w *firstByteWriter) WriteString(s string) (int, error) {
+	w.mark()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *firstByteWriter) mark() {
+	if w.firstByte.IsZero() {
+		w.firstByte = time.Now()
+	}
+}
+
+// isStreaming reports whether a request is served as a long-lived stream
+func isStreaming(ctx *gin.Context) bool {
+	return ctx.Query("download") == "true" ||
+		strings.EqualFold(ctx.GetHeader("Upgrade"), "websocket") ||
+		strings.Contains(ctx.GetHeader("Accept"), "text/event-stream") ||
+		ctx.Writer.Header().Get("Content-Type") == "application/x-ndjson"
+}
+
+// SlowRequestLogger logs a warning for every request that takes longer than
+// threshold. Streaming requests are timed to their first response byte,
+// since their total duration depends on the client.
+func SlowRequestLogger(threshold time.Duration, logger *slog.Logger) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		writer := &firstByteWriter{ResponseWriter: ctx.Writer}
+		ctx.Writer = writer
+
+		ctx.Next()
+
+		end := time.Now()
+		measure := "total"
+		if isStreaming(ctx) && !writer.firstByte.IsZero() {
+			end = writer.firstByte
+			measure = "time-to-first-byte"
+		}
+
+		if duration := end.Sub(start); duration > threshold {
+			logger.Warn("slow request",
+				"method", ctx.Request.Method,
+				"path", ctx.Request.URL.Path,
+				"status", ctx.Writer.Status(),
+				"duration", duration,
+				"measure", measure,
+			)
+		}
+	}
+}
+
+// RequestLogger logs every request, in place of gin's own request log, so
+// requests end up in the structured log. Server errors are logged as
+// errors, everything else at info level.
+func RequestLogger(logger *slog.Logger) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+
+		ctx.Next()
+
+		level := slog.LevelInfo
+		if ctx.Writer.Status() >= 500 {
+			level = slog.LevelError
+		}
+		logger.Log(ctx.Request.Context(), level, "request",
+			"method", ctx.Request.Method,
+			"path", ctx.Request.URL.Path,
+			"status", ctx.Writer.Status(),
+			"duration", time.Since(start),
+			"size", ctx.Writer.Size(),
+			"client", ctx.ClientIP(),
+		)
+	}
+}
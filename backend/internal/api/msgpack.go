@@ -0,0 +1,44 @@
+package api
+
+import (
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// wantsMsgPack reports whether the client prefers MessagePack over JSON.
+// JSON stays the default for clients without an Accept header.
+func wantsMsgPack(ctx *gin.Context) bool {
+	if ctx.GetHeader("Accept") == "" {
+		return false
+	}
+
+	format := ctx.NegotiateFormat(binding.MIMEJSON, binding.MIMEMSGPACK2, binding.MIMEMSGPACK)
+	return format == binding.MIMEMSGPACK2 || format == binding.MIMEMSGPACK
+}
+
+// msgpackValue turns the numbers of a JSON value decoded with UseNumber
+// into integers or floats, so they are encoded as MessagePack numbers
+// rather than strings
+func msgpackValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	case map[string]interface{}:
+		for key, field := range v {
+			v[key] = msgpackValue(field)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = msgpackValue(item)
+		}
+	}
+	return value
+}
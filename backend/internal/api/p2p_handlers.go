@@ -2,6 +2,10 @@ package api
 
 import (
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/user/distfs/internal/fs"
@@ -10,23 +14,82 @@ import (
 
 // P2PInfo represents the current state of the P2P network
 type P2PInfo struct {
-	NodeID      string       `json:"nodeId"`
-	PeerCount   int          `json:"peerCount"`
-	Peers       []PeerInfo   `json:"peers"`
-	IsConnected bool         `json:"isConnected"`
-	Port        int          `json:"port"`
+	NodeID      string                 `json:"nodeId"`
+	PeerCount   int                    `json:"peerCount"`
+	Peers       []PeerInfo             `json:"peers"`
+	IsConnected bool                   `json:"isConnected"`
+	Port        int                    `json:"port"`
+	Breakers    []node.BreakerStatus   `json:"breakers"`   // Peers with recent connect failures
+	Persistent  []node.ReconnectStatus `json:"persistent"` // Peers kept connected by reconnecting
 }
 
 // PeerInfo represents information about a peer
 type PeerInfo struct {
-	ID        string `json:"id"`
-	Address   string `json:"address"`
-	IsActive  bool   `json:"isActive"`
-	LastSeen  string `json:"lastSeen"`
+	ID            string        `json:"id"`
+	Address       string        `json:"address"`
+	IsActive      bool          `json:"isActive"`
+	LastSeen      string        `json:"lastSeen"`
+	Latency       time.Duration `json:"latency"`
+	BytesSent     int64         `json:"bytesSent"`
+	BytesReceived int64         `json:"bytesReceived"`
 }
 
-// SetupP2PRoutes adds P2P-related routes to the router
-func SetupP2PRoutes(router *gin.Engine, fileSystem *fs.DistributedFileSystem, nodeManager *node.NodeManager, p2pNetwork *node.P2PNetwork) {
+// Sort keys of the peer list
+const (
+	PeerSortAddress  = "address"
+	PeerSortLastSeen = "lastSeen"
+	PeerSortLatency  = "latency"
+	PeerSortBytes    = "bytes" // Bytes sent and received
+)
+
+// newPeerInfo describes a peer
+func newPeerInfo(peer *node.Peer) PeerInfo {
+	return PeerInfo{
+		ID:            peer.ID,
+		Address:       peer.Address,
+		IsActive:      peer.IsActive,
+		LastSeen:      peer.LastActive.Format(http.TimeFormat),
+		Latency:       peer.Latency,
+		BytesSent:     peer.BytesSent.Load(),
+		BytesReceived: peer.BytesReceived.Load(),
+	}
+}
+
+// sortPeers sorts peers by the requested key, ties are broken by address
+// so pages are stable
+func sortPeers(peers []*node.Peer, params pageParams) {
+	sort.SliceStable(peers, func(i, j int) bool {
+		a, b := peers[i], peers[j]
+		cmp := 0
+		switch params.Sort {
+		case PeerSortLastSeen:
+			cmp = a.LastActive.Compare(b.LastActive)
+		case PeerSortLatency:
+			cmp = compareInt64(int64(a.Latency), int64(b.Latency))
+		case PeerSortBytes:
+			cmp = compareInt64(a.BytesSent.Load()+a.BytesReceived.Load(), b.BytesSent.Load()+b.BytesReceived.Load())
+		}
+		if cmp == 0 {
+			cmp = strings.Compare(a.Address, b.Address)
+		}
+		return params.less(cmp)
+	})
+}
+
+// compareInt64 compares two integers like strings.Compare
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// SetupP2PRoutes adds P2P-related routes to the router. Files stored by
+// the encrypt route respect the advisory locks of locks.
+func SetupP2PRoutes(router *gin.Engine, fileSystem *fs.DistributedFileSystem, nodeManager *node.NodeManager, p2pNetwork *node.P2PNetwork, locks *fs.LockManager) {
 	// Group routes under /api/p2p
 	p2pGroup := router.Group("/api/p2p")
 	{
@@ -39,14 +102,20 @@ func SetupP2PRoutes(router *gin.Engine, fileSystem *fs.DistributedFileSystem, no
 		// Connect to peer
 		p2pGroup.POST("/peers", func(c *gin.Context) {
 			var req struct {
-				Address string `json:"address" binding:"required"`
+				Address    string `json:"address" binding:"required"`
+				Persistent bool   `json:"persistent"` // Reconnect whenever the connection is lost
 			}
 			if err := c.ShouldBindJSON(&req); err != nil {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
 				return
 			}
 
+			// Persistent peers are added after the first attempt, so the
+			// reconnect loop doesn't dial them a second time
 			peer, err := p2pNetwork.ConnectToPeer(req.Address)
+			if req.Persistent {
+				p2pNetwork.AddPersistentPeer(req.Address)
+			}
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
@@ -70,49 +139,62 @@ func SetupP2PRoutes(router *gin.Engine, fileSystem *fs.DistributedFileSystem, no
 			c.JSON(http.StatusOK, gin.H{"status": "disconnected"})
 		})
 
-		// List peers
+		// List peers, paginated with ?limit=, ?offset=, ?sort= and ?order=
 		p2pGroup.GET("/peers", func(c *gin.Context) {
+			params, err := parsePageParams(c, PeerSortAddress, PeerSortLastSeen, PeerSortLatency, PeerSortBytes)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
 			peers := p2pNetwork.GetPeers()
-			peerInfos := make([]PeerInfo, 0, len(peers))
-			
-			for _, peer := range peers {
-				peerInfos = append(peerInfos, PeerInfo{
-					ID:       peer.ID,
-					Address:  peer.Address,
-					IsActive: peer.IsActive,
-					LastSeen: peer.LastActive.Format(http.TimeFormat),
-				})
+			sortPeers(peers, params)
+
+			start, end := params.bounds(len(peers))
+			peerInfos := make([]PeerInfo, 0, end-start)
+			for _, peer := range peers[start:end] {
+				peerInfos = append(peerInfos, newPeerInfo(peer))
 			}
 			
+			c.Header(TotalCountHeader, strconv.Itoa(len(peers)))
 			c.JSON(http.StatusOK, peerInfos)
 		})
 
-		// Encrypt file endpoint
-		p2pGroup.POST("/encrypt", func(c *gin.Context) {
-			file, err := c.FormFile("file")
-			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
+		// Broadcast a heartbeat or a discovery request to all peers and
+		// report which peers it reached
+		p2pGroup.POST("/broadcast", func(c *gin.Context) {
+			var req struct {
+				Type string `json:"type" binding:"required"`
+			}
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
 				return
 			}
 
-			// Generate a temporary path for the uploaded file
-			srcPath := "/tmp/upload_" + file.Filename
-			if err := c.SaveUploadedFile(file, srcPath); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save uploaded file"})
+			var result *node.BroadcastResult
+			var err error
+			switch req.Type {
+			case "heartbeat":
+				result, err = p2pNetwork.BroadcastHeartbeat()
+			case "discovery":
+				result, err = p2pNetwork.BroadcastMessage(node.NewMessage(node.MessageTypeNodeDiscovery, nil))
+			default:
+				c.JSON(http.StatusBadRequest, gin.H{"error": "type must be heartbeat or discovery"})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
 			}
 
-			// Generate a path for the encrypted file
-			dstPath := "/tmp/encrypted_" + file.Filename
-			
-			// TODO: Implement encryption using the crypto package
-			// This is a placeholder
-			c.JSON(http.StatusOK, gin.H{
-				"status": "encryption not implemented yet",
-				"src":    srcPath,
-				"dst":    dstPath,
-			})
+			c.JSON(http.StatusOK, result)
 		})
+
+		// Encrypt file endpoint
+		p2pGroup.POST("/encrypt", handleEncrypt(fileSystem, locks))
+
+		// Decrypt file endpoint
+		p2pGroup.POST("/decrypt", handleDecrypt())
 	}
 }
 
@@ -122,12 +204,7 @@ func getP2PInfo(p2pNetwork *node.P2PNetwork) P2PInfo {
 	peerInfos := make([]PeerInfo, 0, len(peers))
 	
 	for _, peer := range peers {
-		peerInfos = append(peerInfos, PeerInfo{
-			ID:       peer.ID,
-			Address:  peer.Address,
-			IsActive: peer.IsActive,
-			LastSeen: peer.LastActive.Format(http.TimeFormat),
-		})
+		peerInfos = append(peerInfos, newPeerInfo(peer))
 	}
 	
 	return P2PInfo{
@@ -136,5 +213,7 @@ func getP2PInfo(p2pNetwork *node.P2PNetwork) P2PInfo {
 		Peers:       peerInfos,
 		IsConnected: len(peers) > 0,
 		Port:        p2pNetwork.GetPort(),
+		Breakers:    p2pNetwork.BreakerStatuses(),
+		Persistent:  p2pNetwork.PersistentPeers(),
 	}
 }
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Sort orders for paginated lists
+const (
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// TotalCountHeader carries the number of items in a paginated list
+// before limit and offset are applied
+const TotalCountHeader = "X-Total-Count"
+
+// pageParams are the pagination and sorting parameters of a list request
+type pageParams struct {
+	Limit  int    // Maximum number of items, 0 for no limit
+	Offset int    // Number of items to skip
+	Sort   string // Sort key
+	Order  string // OrderAsc or OrderDesc
+}
+
+// parsePageParams reads ?limit=, ?offset=, ?sort= and ?order= from a list
+// request. sort must be one of sortKeys, it defaults to the first one.
+func parsePageParams(ctx *gin.Context, sortKeys ...string) (pageParams, error) {
+	params := pageParams{
+		Sort:  ctx.DefaultQuery("sort", sortKeys[0]),
+		Order: ctx.DefaultQuery("order", OrderAsc),
+	}
+
+	for _, q := range []struct {
+		name  string
+		value *int
+	}{{"limit", &params.Limit}, {"offset", &params.Offset}} {
+		raw := ctx.Query(q.name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return params, fmt.Errorf("%s must be a non-negative integer", q.name)
+		}
+		*q.value = n
+	}
+
+	valid := false
+	for _, key := range sortKeys {
+		valid = valid || params.Sort == key
+	}
+	if !valid {
+		return params, fmt.Errorf("sort must be one of %s", strings.Join(sortKeys, ", "))
+	}
+	if params.Order != OrderAsc && params.Order != OrderDesc {
+		return params, fmt.Errorf("order must be %s or %s", OrderAsc, OrderDesc)
+	}
+
+	return params, nil
+}
+
+// bounds returns the slice bounds of the requested page in a list of
+// total items. An offset past the end yields an empty page.
+func (p pageParams) bounds(total int) (start, end int) {
+	start = p.Offset
+	if start > total {
+		start = total
+	}
+	end = total
+	if p.Limit > 0 && start+p.Limit < end {
+		end = start + p.Limit
+	}
+	return start, end
+}
+
+// less orders two items by a sort key comparison, honouring the order.
+// cmp is negative, zero or positive like strings.Compare.
+func (p pageParams) less(cmp int) bool {
+	if p.Order == OrderDesc {
+		return cmp > 0
+	}
+	return cmp < 0
+}
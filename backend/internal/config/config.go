@@ -0,0 +1,145 @@
+// Package config reads option values from a configuration file, so a node
+// can be set up without passing every option as a command line flag.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the options of a configuration file. The file is a YAML
+// (or JSON) mapping from flag names to values, for example:
+//
+//	port: 8080
+//	data: /var/lib/filego
+//	peers:
+//	  - 10.0.0.2:9000
+//	  - 10.0.0.3:9000
+//	ping-timeout: 30s
+//
+// Lists are joined with commas, for the flags taking comma-separated values.
+type Config struct {
+	Path   string
+	values map[string]option
+}
+
+// option is the value of one option and where the file sets it
+type option struct {
+	value string
+	line  int
+}
+
+// Load reads a configuration file. It fails on malformed files and on
+// values that aren't scalars or lists of scalars.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var doc yaml.Node
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&doc); err != nil {
+		if errors.Is(err, io.EOF) {
+			return &Config{Path: path, values: make(map[string]option)}, nil // Empty file
+		}
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	root := &doc
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s: line %d: expected a mapping of option names to values", path, root.Line)
+	}
+
+	cfg := &Config{Path: path, values: make(map[string]option)}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if key.Kind != yaml.ScalarNode || key.Value == "" {
+			return nil, fmt.Errorf("%s: line %d: option names must be strings", path, key.Line)
+		}
+		if _, ok := cfg.values[key.Value]; ok {
+			return nil, fmt.Errorf("%s: line %d: option %q is set twice", path, key.Line, key.Value)
+		}
+
+		text, err := scalarValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: line %d: option %q: %w", path, value.Line, key.Value, err)
+		}
+		cfg.values[key.Value] = option{value: text, line: key.Line}
+	}
+
+	return cfg, nil
+}
+
+// scalarValue returns the text of a scalar or of a list of scalars joined
+// with commas
+func scalarValue(node *yaml.Node) (string, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.Tag == "!!null" {
+			return "", errors.New("missing value")
+		}
+		return node.Value, nil
+	case yaml.SequenceNode:
+		items := make([]string, 0, len(node.Content))
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return "", errors.New("lists may only hold plain values")
+			}
+			items = append(items, item.Value)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", errors.New("expected a value or a list of values")
+	}
+}
+
+// Apply sets the flags of a parsed flag set to the values of the file,
+// leaving alone the flags set on the command line, so the precedence is
+// command line over file over default. Options that aren't flags of the
+// set and values the flags don't accept are errors. except names flags
+// that can't be set from a file, such as the flag naming the file.
+func (c *Config) Apply(flags *flag.FlagSet, except ...string) error {
+	setOnCommandLine := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		setOnCommandLine[f.Name] = true
+	})
+
+	// Apply in name order so errors are reported deterministically
+	names := make([]string, 0, len(c.values))
+	for name := range c.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		opt := c.values[name]
+		for _, excepted := range except {
+			if name == excepted {
+				return fmt.Errorf("%s: line %d: option %q can't be set in a config file", c.Path, opt.line, name)
+			}
+		}
+		if flags.Lookup(name) == nil {
+			return fmt.Errorf("%s: line %d: unknown option %q", c.Path, opt.line, name)
+		}
+		if setOnCommandLine[name] {
+			continue
+		}
+		if err := flags.Set(name, opt.value); err != nil {
+			return fmt.Errorf("%s: line %d: invalid value %q for option %q: %w", c.Path, opt.line, opt.value, name, err)
+		}
+	}
+
+	return nil
+}
@@ -4,8 +4,12 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"io"
+	"math"
+	"sync/atomic"
 )
 
 // Constants
@@ -13,6 +17,29 @@ const (
 	KeySize = 32 // 256-bit key
 )
 
+// copyBufferSize is the buffer size used for stream copies, 0 uses
+// io.Copy's default
+var copyBufferSize atomic.Int64
+
+// SetCopyBufferSize sets the buffer size used to copy streams while
+// encrypting and decrypting. 0 keeps io.Copy's default of 32KB.
+func SetCopyBufferSize(size int) {
+	copyBufferSize.Store(int64(size))
+}
+
+// copyStream copies src to dst through the configured copy buffer
+func copyStream(dst io.Writer, src io.Reader) error {
+	size := copyBufferSize.Load()
+	if size <= 0 {
+		_, err := io.Copy(dst, src)
+		return err
+	}
+
+	// Hide ReaderFrom and WriterTo so io.CopyBuffer can't bypass the buffer
+	_, err := io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, make([]byte, size))
+	return err
+}
+
 // GenerateRandomKey generates a random key for encryption
 func GenerateRandomKey() ([]byte, error) {
 	key := make([]byte, KeySize)
@@ -23,76 +50,305 @@ func GenerateRandomKey() ([]byte, error) {
 	return key, nil
 }
 
-// EncryptFile encrypts a file using AES-GCM
-func EncryptFile(src io.Reader, dst io.Writer, key []byte) error {
-	// Create a new cipher block from the key
+// Errors returned when decrypting
+var (
+	ErrInvalidFormat  = errors.New("not an encrypted file or unsupported format")
+	ErrAuthentication = errors.New("encrypted data failed authentication")
+)
+
+// errTruncated is returned by segmentReader.open when the stream ends
+// before the last segment. Read reports it as ErrAuthentication, resumes
+// use it to find where an interrupted output ends.
+var errTruncated = errors.New("encrypted data is truncated")
+
+// Encrypted files start with a header of the magic, the format version,
+// the segment size and a random nonce prefix. The plaintext follows in
+// segments of up to segmentSize bytes, each sealed with AES-GCM under a
+// nonce made of the prefix, the segment counter and a flag marking the
+// last segment, with the header as additional data. Segments are framed
+// by a 4 byte length whose top bit repeats the last segment flag, so
+// reordered, dropped or truncated segments fail authentication.
+const (
+	formatMagic     = "FGCM"
+	formatVersion   = 1
+	segmentSize     = 64 * 1024
+	noncePrefixSize = 7
+	headerSize      = len(formatMagic) + 1 + 4 + noncePrefixSize
+	lastSegmentFlag = 1 << 31
+)
+
+// newGCM creates an AES-GCM instance for a key
+func newGCM(key []byte) (cipher.AEAD, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return cipher.NewGCM(block)
+}
 
-	// Create a new GCM
-	gcm, err := cipher.NewGCM(block)
+// segmentNonce returns the nonce of a segment
+func segmentNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, noncePrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], counter)
+	if last {
+		nonce[noncePrefixSize+4] = 1
+	}
+	return nonce
+}
+
+// StreamOptions configures an encryption or decryption
+type StreamOptions struct {
+	// Progress, if set, is called after every segment with the number of
+	// plaintext bytes processed so far
+	Progress func(processed int64)
+}
+
+// EncryptFile encrypts a stream with AES-GCM in authenticated segments, so
+// files larger than memory can be encrypted and decrypted
+func EncryptFile(src io.Reader, dst io.Writer, key []byte) error {
+	return EncryptFileWithOptions(src, dst, key, StreamOptions{})
+}
+
+// EncryptFileWithOptions works like EncryptFile with the given options
+func EncryptFileWithOptions(src io.Reader, dst io.Writer, key []byte, opts StreamOptions) error {
+	gcm, err := newGCM(key)
 	if err != nil {
 		return err
 	}
 
-	// Create a random nonce
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+	// Write the header with a random nonce prefix
+	header := make([]byte, headerSize)
+	copy(header, formatMagic)
+	header[len(formatMagic)] = formatVersion
+	binary.BigEndian.PutUint32(header[len(formatMagic)+1:], segmentSize)
+	if _, err := io.ReadFull(rand.Reader, header[headerSize-noncePrefixSize:]); err != nil {
+		return err
+	}
+	if _, err := dst.Write(header); err != nil {
 		return err
 	}
 
-	// Write the nonce to the output file
-	if _, err := dst.Write(nonce); err != nil {
+	return sealSegments(src, newSegmentWriter(gcm, dst, header, segmentSize, opts.Progress))
+}
+
+// sealSegments copies src into a segment writer and seals the last segment
+func sealSegments(src io.Reader, sealer *segmentWriter) error {
+	if err := copyStream(sealer, src); err != nil {
 		return err
 	}
+	return sealer.Close()
+}
 
-	// Create a writer that will encrypt and write to the destination
-	encryptWriter := &cipher.StreamWriter{
-		S: cipher.NewOFB(block, nonce),
-		W: dst,
+// DecryptFile decrypts a stream written by EncryptFile. It returns
+// ErrAuthentication if the data was tampered with or truncated; everything
+// written to dst before such an error is authentic, but incomplete.
+func DecryptFile(src io.Reader, dst io.Writer, key []byte) error {
+	return DecryptFileWithOptions(src, dst, key, StreamOptions{})
+}
+
+// DecryptFileWithOptions works like DecryptFile with the given options
+func DecryptFileWithOptions(src io.Reader, dst io.Writer, key []byte, opts StreamOptions) error {
+	opener, err := newSegmentReader(src, key, opts.Progress)
+	if err != nil {
+		return err
 	}
+	return copyStream(dst, opener)
+}
 
-	// Copy the input file to the encrypted output writer
-	if _, err := io.Copy(encryptWriter, src); err != nil {
+// readHeader reads and checks the header of an encrypted stream and
+// returns it with the segment size it declares
+func readHeader(src io.Reader) ([]byte, int, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return nil, 0, ErrInvalidFormat
+	}
+	if string(header[:len(formatMagic)]) != formatMagic || header[len(formatMagic)] != formatVersion {
+		return nil, 0, ErrInvalidFormat
+	}
+	size := binary.BigEndian.Uint32(header[len(formatMagic)+1:])
+	if size == 0 || size > 16*segmentSize {
+		return nil, 0, ErrInvalidFormat
+	}
+	return header, int(size), nil
+}
+
+// segmentWriter seals everything written to it into segments. A full
+// segment is only sealed once more data arrives, so Close can mark the
+// final segment as last.
+type segmentWriter struct {
+	gcm       cipher.AEAD
+	w         io.Writer
+	header    []byte
+	prefix    []byte
+	buf       []byte
+	counter   uint32
+	processed int64
+	progress  func(int64)
+}
+
+// newSegmentWriter creates a segment writer for the stream with the given header
+func newSegmentWriter(gcm cipher.AEAD, w io.Writer, header []byte, size int, progress func(int64)) *segmentWriter {
+	return &segmentWriter{
+		gcm:      gcm,
+		w:        w,
+		header:   header,
+		prefix:   header[headerSize-noncePrefixSize:],
+		buf:      make([]byte, 0, size),
+		progress: progress,
+	}
+}
+
+// Write implements io.Writer
+func (sw *segmentWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if len(sw.buf) == cap(sw.buf) {
+			if err := sw.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(sw.buf[len(sw.buf):cap(sw.buf)], p)
+		sw.buf = sw.buf[:len(sw.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close seals the last segment
+func (sw *segmentWriter) Close() error {
+	return sw.seal(true)
+}
+
+// seal writes the buffered plaintext as one segment
+func (sw *segmentWriter) seal(last bool) error {
+	if sw.counter == math.MaxUint32 {
+		return errors.New("input too large to encrypt")
+	}
+
+	sealed := sw.gcm.Seal(nil, segmentNonce(sw.prefix, sw.counter, last), sw.buf, sw.header)
+
+	frame := uint32(len(sealed))
+	if last {
+		frame |= lastSegmentFlag
+	}
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], frame)
+	if _, err := sw.w.Write(lenBuf[:]); err != nil {
+		return err
+	}
+	if _, err := sw.w.Write(sealed); err != nil {
 		return err
 	}
 
+	sw.counter++
+	sw.processed += int64(len(sw.buf))
+	sw.buf = sw.buf[:0]
+	if sw.progress != nil {
+		sw.progress(sw.processed)
+	}
 	return nil
 }
 
-// DecryptFile decrypts a file using AES-GCM
-func DecryptFile(src io.Reader, dst io.Writer, key []byte) error {
-	// Create a new cipher block from the key
-	block, err := aes.NewCipher(key)
+// segmentReader opens the segments read from r
+type segmentReader struct {
+	gcm       cipher.AEAD
+	r         io.Reader
+	header    []byte
+	prefix    []byte
+	size      int // Plaintext size of a full segment
+	counter   uint32
+	plain     []byte // Opened plaintext not read yet
+	done      bool   // The last segment was opened
+	processed int64
+	progress  func(int64)
+}
+
+// newSegmentReader reads the header of an encrypted stream and returns a
+// reader of its plaintext
+func newSegmentReader(src io.Reader, key []byte, progress func(int64)) (*segmentReader, error) {
+	gcm, err := newGCM(key)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Create a new GCM
-	gcm, err := cipher.NewGCM(block)
+	header, size, err := readHeader(src)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Read the nonce from the encrypted file
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(src, nonce); err != nil {
+	return &segmentReader{
+		gcm:      gcm,
+		r:        src,
+		header:   header,
+		prefix:   header[headerSize-noncePrefixSize:],
+		size:     size,
+		progress: progress,
+	}, nil
+}
+
+// Read implements io.Reader
+func (sr *segmentReader) Read(p []byte) (int, error) {
+	for len(sr.plain) == 0 {
+		if sr.done {
+			// Anything after the last segment was not written by EncryptFile
+			var extra [1]byte
+			if n, _ := sr.r.Read(extra[:]); n > 0 {
+				return 0, ErrAuthentication
+			}
+			return 0, io.EOF
+		}
+		if err := sr.open(); err != nil {
+			if err == errTruncated {
+				return 0, ErrAuthentication
+			}
+			return 0, err
+		}
+	}
+
+	n := copy(p, sr.plain)
+	sr.plain = sr.plain[n:]
+	return n, nil
+}
+
+// open reads and opens the next segment
+func (sr *segmentReader) open() error {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(sr.r, lenBuf[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errTruncated // Ended before the last segment
+		}
 		return err
 	}
 
-	// Create a reader that will decrypt from the source
-	decryptReader := &cipher.StreamReader{
-		S: cipher.NewOFB(block, nonce),
-		R: src,
+	frame := binary.BigEndian.Uint32(lenBuf[:])
+	last := frame&lastSegmentFlag != 0
+	size := int(frame &^ lastSegmentFlag)
+	if size < sr.gcm.Overhead() || size > sr.size+sr.gcm.Overhead() {
+		return ErrAuthentication
 	}
 
-	// Copy the decrypted input to the output file
-	if _, err := io.Copy(dst, decryptReader); err != nil {
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(sr.r, sealed); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errTruncated
+		}
 		return err
 	}
 
+	plain, err := sr.gcm.Open(sealed[:0], segmentNonce(sr.prefix, sr.counter, last), sealed, sr.header)
+	if err != nil {
+		return ErrAuthentication
+	}
+
+	sr.counter++
+	sr.plain = plain
+	sr.done = last
+	sr.processed += int64(len(plain))
+	if sr.progress != nil {
+		sr.progress(sr.processed)
+	}
 	return nil
 }
 
@@ -0,0 +1,140 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// SaltSize is the size of the random salt used to derive passphrase keys
+const SaltSize = 16
+
+// Limits on the derivation parameters read from an encrypted stream, so a
+// crafted header can't make decryption allocate or compute much more than
+// the defaults do
+const (
+	maxKDFTime    = 4
+	maxKDFMemory  = 256 * 1024 // 256MB in KB
+	maxKDFThreads = 16
+)
+
+// KDFOptions configures Argon2id key derivation
+type KDFOptions struct {
+	Time    uint32 // Number of passes over the memory
+	Memory  uint32 // Memory in KB
+	Threads uint8  // Degree of parallelism
+}
+
+// DefaultKDFOptions returns the derivation parameters recommended for
+// interactive use by RFC 9106
+func DefaultKDFOptions() KDFOptions {
+	return KDFOptions{
+		Time:    1,
+		Memory:  64 * 1024,
+		Threads: 4,
+	}
+}
+
+// validate checks the parameters are usable
+func (o KDFOptions) validate() error {
+	if o.Time == 0 || o.Time > maxKDFTime {
+		return errors.New("invalid key derivation time")
+	}
+	if o.Memory < 8*uint32(o.Threads) || o.Memory > maxKDFMemory {
+		return errors.New("invalid key derivation memory")
+	}
+	if o.Threads == 0 || o.Threads > maxKDFThreads {
+		return errors.New("invalid key derivation threads")
+	}
+	return nil
+}
+
+// DeriveKeyFromPassphrase derives a key from a passphrase and salt with
+// Argon2id and the default parameters
+func DeriveKeyFromPassphrase(passphrase string, salt []byte) ([]byte, error) {
+	return DeriveKeyWithOptions(passphrase, salt, DefaultKDFOptions())
+}
+
+// DeriveKeyWithOptions derives a key from a passphrase and salt with
+// Argon2id and the given parameters
+func DeriveKeyWithOptions(passphrase string, salt []byte, opts KDFOptions) ([]byte, error) {
+	if passphrase == "" {
+		return nil, errors.New("passphrase is empty")
+	}
+	if len(salt) < SaltSize {
+		return nil, errors.New("salt is too short")
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	return argon2.IDKey([]byte(passphrase), salt, opts.Time, opts.Memory, opts.Threads, KeySize), nil
+}
+
+// Passphrase encrypted streams start with a header of the magic, the format
+// version, the derivation parameters and the salt, followed by the output
+// of EncryptFile under the derived key
+const (
+	passphraseMagic      = "FGPW"
+	passphraseVersion    = 1
+	passphraseHeaderSize = len(passphraseMagic) + 1 + 4 + 4 + 1 + SaltSize
+)
+
+// EncryptWithPassphrase encrypts a stream with a key derived from a
+// passphrase. A random salt and the derivation parameters are written
+// ahead of the encrypted data so DecryptWithPassphrase can recover the key.
+func EncryptWithPassphrase(src io.Reader, dst io.Writer, passphrase string, opts KDFOptions) error {
+	header := make([]byte, passphraseHeaderSize)
+	copy(header, passphraseMagic)
+	header[len(passphraseMagic)] = passphraseVersion
+	offset := len(passphraseMagic) + 1
+	binary.BigEndian.PutUint32(header[offset:], opts.Time)
+	binary.BigEndian.PutUint32(header[offset+4:], opts.Memory)
+	header[offset+8] = opts.Threads
+
+	salt := header[passphraseHeaderSize-SaltSize:]
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return err
+	}
+
+	key, err := DeriveKeyWithOptions(passphrase, salt, opts)
+	if err != nil {
+		return err
+	}
+
+	if _, err := dst.Write(header); err != nil {
+		return err
+	}
+	return EncryptFile(src, dst, key)
+}
+
+// DecryptWithPassphrase decrypts a stream written by EncryptWithPassphrase.
+// A wrong passphrase fails with ErrAuthentication.
+func DecryptWithPassphrase(src io.Reader, dst io.Writer, passphrase string) error {
+	header := make([]byte, passphraseHeaderSize)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return ErrInvalidFormat
+	}
+	if string(header[:len(passphraseMagic)]) != passphraseMagic || header[len(passphraseMagic)] != passphraseVersion {
+		return ErrInvalidFormat
+	}
+
+	offset := len(passphraseMagic) + 1
+	opts := KDFOptions{
+		Time:    binary.BigEndian.Uint32(header[offset:]),
+		Memory:  binary.BigEndian.Uint32(header[offset+4:]),
+		Threads: header[offset+8],
+	}
+	if opts.validate() != nil {
+		return ErrInvalidFormat
+	}
+
+	key, err := DeriveKeyWithOptions(passphrase, header[passphraseHeaderSize-SaltSize:], opts)
+	if err != nil {
+		return err
+	}
+	return DecryptFile(src, dst, key)
+}
@@ -0,0 +1,115 @@
+package crypto
+
+import (
+	"errors"
+	"io"
+)
+
+// ResumableOutput is an output an interrupted encryption or decryption can
+// be resumed in, like an *os.File
+type ResumableOutput interface {
+	io.ReadWriteSeeker
+	Truncate(size int64) error
+}
+
+// ResumeEncryptFile continues an EncryptFile into dst that was interrupted.
+// The complete segments already in dst are authenticated and kept, a
+// partially written segment is cut off and the rest of src is encrypted
+// after them. src must be the input of the interrupted run: resumed
+// segments reuse its nonces, so encrypting different data would break
+// confidentiality. An output shorter than the header starts over.
+func ResumeEncryptFile(src io.ReadSeeker, dst ResumableOutput, key []byte, opts StreamOptions) error {
+	written, err := dst.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if written < int64(headerSize) {
+		if err := rewind(dst, 0); err != nil {
+			return err
+		}
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		return EncryptFileWithOptions(src, dst, key, opts)
+	}
+
+	if _, err := dst.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	opener, err := newSegmentReader(dst, key, nil)
+	if err != nil {
+		return err
+	}
+
+	// Skip over the segments already written
+	offset := int64(headerSize)
+	for !opener.done {
+		err := opener.open()
+		if err == errTruncated {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		offset += 4 + int64(len(opener.plain)+opener.gcm.Overhead())
+		opener.plain = nil
+	}
+
+	if opener.done {
+		// The output is complete, only drop anything written after it
+		if opts.Progress != nil {
+			opts.Progress(opener.processed)
+		}
+		return dst.Truncate(offset)
+	}
+
+	if err := rewind(dst, offset); err != nil {
+		return err
+	}
+	if _, err := src.Seek(opener.processed, io.SeekStart); err != nil {
+		return err
+	}
+
+	sealer := newSegmentWriter(opener.gcm, dst, opener.header, opener.size, opts.Progress)
+	sealer.counter = opener.counter
+	sealer.processed = opener.processed
+	return sealSegments(src, sealer)
+}
+
+// ResumeDecryptFile continues a DecryptFile into dst that was interrupted.
+// The plaintext of the complete segments already in dst is kept and only
+// the remaining segments are written; the skipped segments are still read
+// and authenticated, so tampering with them is detected.
+func ResumeDecryptFile(src io.Reader, dst ResumableOutput, key []byte, opts StreamOptions) error {
+	opener, err := newSegmentReader(src, key, opts.Progress)
+	if err != nil {
+		return err
+	}
+
+	written, err := dst.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	keep := written - written%int64(opener.size)
+	if err := rewind(dst, keep); err != nil {
+		return err
+	}
+
+	if _, err := io.CopyN(io.Discard, opener, keep); err != nil {
+		if err == io.EOF {
+			return errors.New("output is longer than the decrypted input")
+		}
+		return err
+	}
+
+	return copyStream(dst, opener)
+}
+
+// rewind truncates an output and positions it at its new end
+func rewind(dst ResumableOutput, size int64) error {
+	if err := dst.Truncate(size); err != nil {
+		return err
+	}
+	_, err := dst.Seek(size, io.SeekStart)
+	return err
+}
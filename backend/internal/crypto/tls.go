@@ -0,0 +1,111 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+// selfSignedValidity is how long generated certificates are valid for
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// TLSOptions selects the certificate a TLS server presents
+type TLSOptions struct {
+	CertFile   string // PEM certificate chain, used together with KeyFile
+	KeyFile    string // PEM private key of the certificate
+	SelfSigned bool   // Generate a self-signed certificate in memory instead
+}
+
+// Enabled reports whether the options ask for TLS at all
+func (o TLSOptions) Enabled() bool {
+	return o.CertFile != "" || o.KeyFile != "" || o.SelfSigned
+}
+
+// ServerTLSConfig returns a TLS configuration for a server presenting the
+// certificate selected by opts, or nil when TLS isn't enabled
+func ServerTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	if !opts.Enabled() {
+		return nil, nil
+	}
+	if opts.SelfSigned && (opts.CertFile != "" || opts.KeyFile != "") {
+		return nil, errors.New("a self-signed certificate can't be combined with a certificate file")
+	}
+
+	var cert tls.Certificate
+	var err error
+	if opts.SelfSigned {
+		cert, err = SelfSignedCertificate("FileGO", nil)
+	} else {
+		if opts.CertFile == "" || opts.KeyFile == "" {
+			return nil, errors.New("both a certificate and a key file are needed")
+		}
+		cert, err = tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// SelfSignedCertificate generates an ECDSA certificate signed by its own
+// key, for testing. hosts are the DNS names and IP addresses it is valid
+// for, localhost and the loopback addresses when empty.
+func SelfSignedCertificate(commonName string, hosts []string) (tls.Certificate, error) {
+	if len(hosts) == 0 {
+		hosts = []string{"localhost", "127.0.0.1", "::1"}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Hour), // Tolerate clock skew
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
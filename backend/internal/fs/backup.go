@@ -0,0 +1,202 @@
+package fs
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Names used inside chunk export archives
+const (
+	exportChunkPrefix  = "chunks/"
+	exportManifestName = "manifest.json"
+)
+
+// ExportedChunk is a manifest entry of a chunk export
+type ExportedChunk struct {
+	FileID  string `json:"fileId"`
+	ChunkID string `json:"chunkId"`
+	Size    int    `json:"size"`
+}
+
+// ExportManifest lists the chunks in an export archive
+type ExportManifest struct {
+	Created time.Time       `json:"created"`
+	Chunks  []ExportedChunk `json:"chunks"`
+}
+
+// ImportReport summarizes a chunk import
+type ImportReport struct {
+	Imported int      `json:"imported"`
+	Missing  []string `json:"missing,omitempty"` // Manifest entries not found in the archive
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// ExportChunks writes every locally stored chunk to w as a tar archive.
+// Chunks are stored as chunks/<fileID>/<chunkID> and followed by a
+// manifest.json listing them, so the archive is streamed without knowing
+// its contents up front.
+func (fc *FileChunker) ExportChunks(w io.Writer) error {
+	if !fc.isLocalStore() {
+		return ErrStoreNotLocal
+	}
+
+	fileIDs, err := fc.listFileIDs()
+	if err != nil {
+		return err
+	}
+
+	archive := tar.NewWriter(w)
+	manifest := ExportManifest{Created: time.Now(), Chunks: []ExportedChunk{}}
+
+	for _, fileID := range fileIDs {
+		chunkIDs, err := fc.chunkStore().List(fileID)
+		if err != nil {
+			return err
+		}
+
+		for _, chunkID := range chunkIDs {
+			// Read around the chunk cache, a backup shouldn't evict hot chunks
+			stored, err := fc.chunkStore().Get(fileID, chunkID)
+			if errors.Is(err, os.ErrNotExist) {
+				continue // Deleted while exporting
+			}
+			if err != nil {
+				return err
+			}
+
+			// Archives hold chunk content, whether or not it is stored
+			// compressed. Corrupt chunks are exported as they are and
+			// rejected on import.
+			data, err := decodeChunk(fileID, chunkID, stored)
+			if err != nil {
+				data = stored
+			}
+
+			if err := writeTarEntry(archive, exportChunkPrefix+fileID+"/"+chunkID, data); err != nil {
+				return err
+			}
+			manifest.Chunks = append(manifest.Chunks, ExportedChunk{FileID: fileID, ChunkID: chunkID, Size: len(data)})
+		}
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err := writeTarEntry(archive, exportManifestName, data); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// writeTarEntry writes a regular file to a tar archive
+func writeTarEntry(archive *tar.Writer, name string, data []byte) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := archive.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := archive.Write(data)
+	return err
+}
+
+// ImportChunks restores chunks from an archive written by ExportChunks.
+// Every chunk is checked against its ID before it is stored, chunks that
+// don't match are reported and skipped.
+func (fc *FileChunker) ImportChunks(r io.Reader) (*ImportReport, error) {
+	report := &ImportReport{}
+	archive := tar.NewReader(r)
+	imported := make(map[string]bool)
+	var manifest *ExportManifest
+
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if header.Name == exportManifestName {
+			manifest = &ExportManifest{}
+			if err := json.NewDecoder(archive).Decode(manifest); err != nil {
+				return report, fmt.Errorf("invalid manifest: %w", err)
+			}
+			continue
+		}
+
+		fileID, chunkID, ok := parseExportName(header.Name)
+		if !ok {
+			report.Errors = append(report.Errors, fmt.Sprintf("unexpected archive entry %s", header.Name))
+			continue
+		}
+		if header.Size > MaxChunkSize {
+			report.Errors = append(report.Errors, fmt.Sprintf("chunk %s is larger than the maximum chunk size", chunkID))
+			continue
+		}
+
+		data, err := io.ReadAll(archive)
+		if err != nil {
+			return report, fmt.Errorf("failed to read chunk %s: %w", chunkID, err)
+		}
+
+		hash := sha256.Sum256(data)
+		if hex.EncodeToString(hash[:]) != chunkID {
+			report.Errors = append(report.Errors, fmt.Sprintf("chunk %s of file %s does not match its hash", chunkID, fileID))
+			continue
+		}
+
+		if err := fc.StoreChunk(fileID, chunkID, data); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("failed to store chunk %s: %v", chunkID, err))
+			continue
+		}
+
+		imported[fileID+"/"+chunkID] = true
+		report.Imported++
+	}
+
+	if manifest != nil {
+		for _, chunk := range manifest.Chunks {
+			if !imported[chunk.FileID+"/"+chunk.ChunkID] {
+				report.Missing = append(report.Missing, chunk.FileID+"/"+chunk.ChunkID)
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// parseExportName splits a chunks/<fileID>/<chunkID> archive entry name,
+// rejecting anything that could escape the chunk store
+func parseExportName(name string) (fileID, chunkID string, ok bool) {
+	rest, found := strings.CutPrefix(name, exportChunkPrefix)
+	if !found || path.Clean(rest) != rest {
+		return "", "", false
+	}
+
+	fileID, chunkID, found = strings.Cut(rest, "/")
+	if !found || fileID == "" || chunkID == "" || strings.Contains(chunkID, "/") ||
+		strings.HasPrefix(fileID, ".") || strings.HasPrefix(chunkID, ".") {
+		return "", "", false
+	}
+
+	return fileID, chunkID, true
+}
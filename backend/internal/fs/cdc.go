@@ -0,0 +1,159 @@
+package fs
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// ChunkingMode selects how a FileChunker places chunk boundaries
+type ChunkingMode string
+
+const (
+	// ChunkingFixed cuts chunks of the chunker's chunk size
+	ChunkingFixed ChunkingMode = "fixed"
+	// ChunkingContent cuts chunks where a rolling hash of the content
+	// matches, so an insert only changes the chunks around it
+	ChunkingContent ChunkingMode = "content"
+)
+
+// Default chunk sizes for content-defined chunking
+const (
+	DefaultMinChunkSize = 1024 * 16  // 16KB
+	DefaultAvgChunkSize = 1024 * 64  // 64KB
+	DefaultMaxChunkSize = 1024 * 256 // 256KB
+)
+
+// buzhashWindow is the number of bytes the rolling hash covers
+const buzhashWindow = 48
+
+// ChunkingOptions controls how a FileChunker splits content
+type ChunkingOptions struct {
+	Mode    ChunkingMode
+	MinSize int // Smallest content-defined chunk, except the last one
+	AvgSize int // Average content-defined chunk size
+	MaxSize int // Content-defined chunks are cut here if no boundary was found
+}
+
+// DefaultContentChunking returns content-defined chunking with the default sizes
+func DefaultContentChunking() ChunkingOptions {
+	return ChunkingOptions{
+		Mode:    ChunkingContent,
+		MinSize: DefaultMinChunkSize,
+		AvgSize: DefaultAvgChunkSize,
+		MaxSize: DefaultMaxChunkSize,
+	}
+}
+
+// SetChunking sets how content is split into chunks. Chunks stored before
+// keep their boundaries; the same content chunked in another mode gets
+// different chunk IDs.
+func (fc *FileChunker) SetChunking(opts ChunkingOptions) error {
+	switch opts.Mode {
+	case ChunkingFixed:
+	case ChunkingContent:
+		if opts.MinSize < buzhashWindow {
+			return fmt.Errorf("minimum chunk size must be at least %d bytes", buzhashWindow)
+		}
+		if opts.AvgSize <= opts.MinSize || opts.MaxSize <= opts.AvgSize {
+			return fmt.Errorf("chunk sizes must satisfy min < avg < max")
+		}
+		if opts.MaxSize > MaxChunkSize {
+			return fmt.Errorf("maximum chunk size must not exceed %d bytes", MaxChunkSize)
+		}
+	default:
+		return fmt.Errorf("unknown chunking mode: %s", opts.Mode)
+	}
+
+	fc.mu.Lock()
+	fc.chunking = opts
+	fc.mu.Unlock()
+
+	return nil
+}
+
+// Chunking returns how content is split into chunks
+func (fc *FileChunker) Chunking() ChunkingOptions {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+
+	return fc.chunking
+}
+
+// contentSplitter cuts chunks at content-defined boundaries found with a
+// buzhash over the last buzhashWindow bytes
+type contentSplitter struct {
+	minSize int
+	maxSize int
+	mask    uint32 // A boundary is where the hash has all these bits clear
+	buffer  []byte
+}
+
+// newContentSplitter returns a splitter for content-defined chunking
+func newContentSplitter(opts ChunkingOptions) *contentSplitter {
+	// Past the minimum size a boundary is found every 2^n bytes on average
+	n := bits.Len(uint(opts.AvgSize-opts.MinSize)) - 1
+	return &contentSplitter{
+		minSize: opts.MinSize,
+		maxSize: opts.MaxSize,
+		mask:    uint32(1)<<n - 1,
+		buffer:  make([]byte, 0, opts.MaxSize),
+	}
+}
+
+// next reads the next chunk. The returned slice is only valid until the
+// next call. It returns io.EOF once the stream is exhausted.
+func (s *contentSplitter) next(r io.ByteReader) ([]byte, error) {
+	chunk := s.buffer[:0]
+
+	// Only the window before the minimum size needs hashing, boundaries
+	// are never placed before it
+	hashFrom := s.minSize - buzhashWindow
+
+	var hash uint32
+	for len(chunk) < s.maxSize {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			if len(chunk) == 0 {
+				return nil, io.EOF
+			}
+			return chunk, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		chunk = append(chunk, b)
+
+		i := len(chunk) - 1
+		if i < hashFrom {
+			continue
+		}
+		hash = bits.RotateLeft32(hash, 1) ^ buzhashTable[b]
+		if i-hashFrom >= buzhashWindow {
+			hash ^= bits.RotateLeft32(buzhashTable[chunk[i-buzhashWindow]], buzhashWindow)
+		}
+
+		if len(chunk) >= s.minSize && hash&s.mask == 0 {
+			return chunk, nil
+		}
+	}
+
+	return chunk, nil
+}
+
+// buzhashTable maps bytes to random values. It is generated from a fixed
+// seed because chunk boundaries, and so chunk IDs, must be the same on
+// every node and across restarts.
+var buzhashTable = func() [256]uint32 {
+	var table [256]uint32
+	state := uint64(0x9e3779b97f4a7c15)
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		table[i] = uint32(z ^ z>>31)
+	}
+	return table
+}()
@@ -0,0 +1,93 @@
+package fs
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+)
+
+// ErrUnsupportedAlgorithm is returned for unknown checksum algorithms
+var ErrUnsupportedAlgorithm = errors.New("unsupported checksum algorithm")
+
+// Checksum algorithms supported by Checksum
+const (
+	ChecksumSHA256 = "sha256"
+	ChecksumSHA1   = "sha1"
+	ChecksumMD5    = "md5"
+)
+
+// newChecksumHash returns a hash for a checksum algorithm
+func newChecksumHash(algo string) (hash.Hash, error) {
+	switch algo {
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	case ChecksumSHA1:
+		return sha1.New(), nil
+	case ChecksumMD5:
+		return md5.New(), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, algo)
+	}
+}
+
+// Checksum returns the hex digest of a file's content. Digests are
+// computed on first use and cached in the file's metadata until the file
+// is uploaded again; the SHA-256 recorded at upload time is reused.
+func (dfs *DistributedFileSystem) Checksum(filePath, algo string) (string, error) {
+	h, err := newChecksumHash(algo)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := dfs.GetFileInfo(filePath)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir {
+		return "", ErrIsDirectory
+	}
+
+	cached, ok := info.Checksums[algo]
+	if algo == ChecksumSHA256 && info.SHA256 != "" {
+		cached, ok = info.SHA256, true
+	}
+	if ok {
+		return cached, nil
+	}
+
+	file, err := os.Open(filepath.Join(dfs.rootDir, filePath))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	digest := hex.EncodeToString(h.Sum(nil))
+
+	// Cache the digest unless the file changed while it was hashed. The
+	// map is replaced rather than written, copies of it may be read
+	// without dfs.mu.
+	dfs.mu.Lock()
+	if entry, exists := dfs.fileInfo[filePath]; exists && entry.Size == info.Size && entry.ModTime.Equal(info.ModTime) {
+		checksums := maps.Clone(entry.Checksums)
+		if checksums == nil {
+			checksums = make(map[string]string)
+		}
+		checksums[algo] = digest
+		entry.Checksums = checksums
+		dfs.metadataDirty = true
+	}
+	dfs.mu.Unlock()
+
+	return digest, nil
+}
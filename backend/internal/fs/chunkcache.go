@@ -0,0 +1,131 @@
+package fs
+
+import (
+	"container/list"
+	"sync"
+)
+
+// chunkCache is a least recently used cache of chunk data bounded by the
+// total number of bytes it holds
+type chunkCache struct {
+	capacity int64
+	size     int64
+	order    *list.List // Front is the most recently used entry
+	entries  map[string]*list.Element
+	hits     int64
+	misses   int64
+	mu       sync.Mutex
+}
+
+// chunkCacheEntry is an entry of a chunkCache
+type chunkCacheEntry struct {
+	key  string
+	data []byte
+}
+
+// newChunkCache creates a chunk cache holding up to capacity bytes
+func newChunkCache(capacity int64) *chunkCache {
+	return &chunkCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// chunkCacheKey returns the cache key of a chunk
+func chunkCacheKey(fileID, chunkID string) string {
+	return fileID + "/" + chunkID
+}
+
+// get returns a copy of a cached chunk
+func (c *chunkCache) get(fileID, chunkID string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[chunkCacheKey(fileID, chunkID)]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+
+	c.hits++
+	c.order.MoveToFront(elem)
+	data := elem.Value.(*chunkCacheEntry).data
+	return append([]byte(nil), data...), true
+}
+
+// put caches a copy of a chunk, evicting the least recently used chunks to
+// make room. Chunks larger than the whole cache are not cached.
+func (c *chunkCache) put(fileID, chunkID string, data []byte) {
+	if int64(len(data)) > c.capacity {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := chunkCacheKey(fileID, chunkID)
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem)
+	}
+
+	entry := &chunkCacheEntry{key: key, data: append([]byte(nil), data...)}
+	c.entries[key] = c.order.PushFront(entry)
+	c.size += int64(len(data))
+
+	for c.size > c.capacity {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// remove drops a chunk from the cache
+func (c *chunkCache) remove(fileID, chunkID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[chunkCacheKey(fileID, chunkID)]; ok {
+		c.removeElement(elem)
+	}
+}
+
+// removeElement drops an entry. Callers must hold c.mu.
+func (c *chunkCache) removeElement(elem *list.Element) {
+	entry := c.order.Remove(elem).(*chunkCacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= int64(len(entry.data))
+}
+
+// stats returns the number of cache hits and misses
+func (c *chunkCache) stats() (hits, misses int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.hits, c.misses
+}
+
+// SetChunkCacheSize enables an in-memory cache of recently read chunks
+// holding up to size bytes. A size of 0 disables the cache.
+func (fc *FileChunker) SetChunkCacheSize(size int64) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	fc.cache = nil
+	if size > 0 {
+		fc.cache = newChunkCache(size)
+	}
+}
+
+// chunkCache returns the chunk cache, or nil if caching is disabled
+func (fc *FileChunker) chunkCache() *chunkCache {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+
+	return fc.cache
+}
+
+// uncacheChunk drops a chunk from the cache after it changed or was removed
+func (fc *FileChunker) uncacheChunk(fileID, chunkID string) {
+	if cache := fc.chunkCache(); cache != nil {
+		cache.remove(fileID, chunkID)
+	}
+}
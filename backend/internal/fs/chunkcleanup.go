@@ -0,0 +1,48 @@
+package fs
+
+import (
+	"path/filepath"
+)
+
+// SetChunker sets the chunker whose chunks of a file are removed when the
+// file is deleted
+func (dfs *DistributedFileSystem) SetChunker(chunker *FileChunker) {
+	dfs.mu.Lock()
+	defer dfs.mu.Unlock()
+
+	dfs.chunker = chunker
+}
+
+// chunkFileID returns the chunker's file ID for a file's content, its
+// SHA-256. The hash recorded at upload time is used when there is one.
+// Returns "" without a chunker. Callers must hold dfs.mu.
+func (dfs *DistributedFileSystem) chunkFileID(path string) string {
+	if dfs.chunker == nil {
+		return ""
+	}
+	if info, exists := dfs.fileInfo[path]; exists && info.SHA256 != "" {
+		return info.SHA256
+	}
+	hash, err := hashFile(filepath.Join(dfs.rootDir, path))
+	if err != nil {
+		return ""
+	}
+	return hash
+}
+
+// deleteChunks removes the chunks of deleted content unless a remaining
+// file is known to have the same content. Failures are logged, the file
+// itself is gone already. Callers must hold dfs.mu.
+func (dfs *DistributedFileSystem) deleteChunks(fileID string) {
+	if fileID == "" || dfs.chunker == nil {
+		return
+	}
+	for _, info := range dfs.fileInfo {
+		if info.SHA256 == fileID {
+			return
+		}
+	}
+	if err := dfs.chunker.DeleteChunks(fileID); err != nil {
+		dfs.log().Warn("error deleting chunks", "fileId", fileID, "error", err)
+	}
+}
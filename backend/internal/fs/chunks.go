@@ -1,13 +1,16 @@
 package fs
 
 import (
+	"bufio"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 )
 
 // Constants for file chunking
@@ -16,20 +19,56 @@ const (
 	MaxChunkSize     = 1024 * 1024 // 1MB maximum chunk size
 )
 
+// DurabilityMode controls how aggressively chunk writes are flushed to disk
+type DurabilityMode string
+
+const (
+	// DurabilityFast leaves flushing to the OS
+	DurabilityFast DurabilityMode = "fast"
+	// DurabilitySafe fsyncs every chunk as it is written
+	DurabilitySafe DurabilityMode = "safe"
+	// DurabilityBatch fsyncs the chunks and their directory once per batch
+	DurabilityBatch DurabilityMode = "batch"
+)
+
+// ErrChunkUnavailable is returned when a chunk is missing locally and
+// could not be recovered from anywhere else
+var ErrChunkUnavailable = errors.New("chunk is missing and could not be recovered")
+
+// ErrChunkCorrupt is returned when a stored chunk no longer hashes to its ID
+var ErrChunkCorrupt = errors.New("chunk content does not match its hash")
+
+// ChunkLocator fetches a chunk this node is missing from elsewhere in the
+// cluster
+type ChunkLocator func(fileID, chunkID string) ([]byte, error)
+
 // ChunkInfo represents metadata about a file chunk
 type ChunkInfo struct {
-	ID       string `json:"id"`
-	Index    int    `json:"index"`
-	Size     int    `json:"size"`
-	FileID   string `json:"fileId"`
-	Location string `json:"location"` // Node ID where the chunk is stored
+	ID         string `json:"id"`
+	Index      int    `json:"index"`
+	Size       int    `json:"size"`                 // Size of the chunk content
+	StoredSize int    `json:"storedSize,omitempty"` // Size in the chunk store, smaller than Size if compressed
+	FileID     string `json:"fileId"`
+	Location   string `json:"location"` // Node ID where the chunk is stored
 }
 
 // FileChunker handles file chunking operations
 type FileChunker struct {
 	chunkSize  int
+	chunking   ChunkingOptions
 	chunksDir  string
 	chunksMeta map[string]*ChunkInfo
+	files      map[string][]*ChunkInfo // Ordered chunks of every chunked file, by file ID
+	durability DurabilityMode
+	syncFile   func(*os.File) error
+	layout     ChunkLayout
+	store      ChunkStore
+	locate     ChunkLocator // Recovers missing chunks, nil disables recovery
+	cache      *chunkCache  // Recently read chunks, nil disables caching
+	compress   bool         // Gzip chunks before storing them
+	stats      *chunkStats
+	reads      atomic.Int64 // Chunks served by GetLocalChunk
+	writes     atomic.Int64 // Chunks written by ChunkReader and StoreChunk
 	mu         sync.RWMutex
 }
 
@@ -50,12 +89,80 @@ func NewFileChunker(chunksDir string, chunkSize int) (*FileChunker, error) {
 		return nil, fmt.Errorf("failed to create chunks directory: %w", err)
 	}
 
-	return &FileChunker{
+	layout, err := detectLayout(chunksDir)
+	if err != nil {
+		return nil, err
+	}
+
+	fc := &FileChunker{
 		chunkSize:  chunkSize,
+		chunking:   ChunkingOptions{Mode: ChunkingFixed},
 		chunksDir:  chunksDir,
 		chunksMeta: make(map[string]*ChunkInfo),
+		files:      make(map[string][]*ChunkInfo),
+		durability: DurabilityFast,
+		syncFile:   (*os.File).Sync,
+		layout:     layout,
+		stats:      newChunkStats(),
 		mu:         sync.RWMutex{},
-	}, nil
+	}
+	fc.store = &localChunkStore{fc: fc}
+
+	return fc, nil
+}
+
+// SetChunkStore replaces the store chunks are kept in. The chunks
+// directory is still used to stage chunks while a stream is split.
+func (fc *FileChunker) SetChunkStore(store ChunkStore) {
+	fc.mu.Lock()
+	fc.store = store
+	if fc.cache != nil {
+		fc.cache = newChunkCache(fc.cache.capacity)
+	}
+	fc.mu.Unlock()
+}
+
+// SetChunkLocator sets how missing chunks are recovered
+func (fc *FileChunker) SetChunkLocator(locate ChunkLocator) {
+	fc.mu.Lock()
+	fc.locate = locate
+	fc.mu.Unlock()
+}
+
+// chunkStore returns the store chunks are kept in
+func (fc *FileChunker) chunkStore() ChunkStore {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+
+	return fc.store
+}
+
+// isLocalStore reports whether chunks are kept in the chunks directory
+func (fc *FileChunker) isLocalStore() bool {
+	_, ok := fc.chunkStore().(*localChunkStore)
+	return ok
+}
+
+// SetDurability sets the durability mode used for chunk writes
+func (fc *FileChunker) SetDurability(mode DurabilityMode) error {
+	switch mode {
+	case DurabilityFast, DurabilitySafe, DurabilityBatch:
+	default:
+		return fmt.Errorf("unknown durability mode: %s", mode)
+	}
+
+	fc.mu.Lock()
+	fc.durability = mode
+	fc.mu.Unlock()
+
+	return nil
+}
+
+// SetSyncer replaces the function used to fsync files and directories
+func (fc *FileChunker) SetSyncer(syncFile func(*os.File) error) {
+	fc.mu.Lock()
+	fc.syncFile = syncFile
+	fc.mu.Unlock()
 }
 
 // ChunkFile splits a file into chunks
@@ -67,72 +174,236 @@ func (fc *FileChunker) ChunkFile(filePath string) (string, []*ChunkInfo, error)
 	}
 	defer file.Close()
 
-	// Calculate file hash for ID
-	fileID, err := calculateFileHash(file)
+	return fc.ChunkReader(file)
+}
+
+// ChunkReader splits a stream into chunks as it is read. The file ID (the
+// hash of the whole content) is only known once the stream ends, so chunks
+// are written to a staging directory and moved into place at the end.
+func (fc *FileChunker) ChunkReader(r io.Reader) (string, []*ChunkInfo, error) {
+	// Create a staging directory for the chunks
+	stagingRoot := filepath.Join(fc.chunksDir, stagingDirName)
+	if err := os.MkdirAll(stagingRoot, 0755); err != nil {
+		return "", nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	stagingDir, err := os.MkdirTemp(stagingRoot, "chunks-")
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to calculate file hash: %w", err)
+		return "", nil, fmt.Errorf("failed to create staging directory: %w", err)
 	}
+	defer os.RemoveAll(stagingDir)
 
-	// Reset file pointer to beginning
-	if _, err := file.Seek(0, 0); err != nil {
-		return "", nil, fmt.Errorf("failed to reset file pointer: %w", err)
-	}
+	// Hash the whole stream for the file ID while splitting it
+	fileHash := sha256.New()
+	reader := io.TeeReader(r, fileHash)
 
-	// Create a directory for the file chunks
-	fileChunksDir := filepath.Join(fc.chunksDir, fileID)
-	if err := os.MkdirAll(fileChunksDir, 0755); err != nil {
-		return "", nil, fmt.Errorf("failed to create file chunks directory: %w", err)
+	nextChunk := fc.fixedSplitter(reader)
+	if opts := fc.Chunking(); opts.Mode == ChunkingContent {
+		splitter := newContentSplitter(opts)
+		byteReader := bufio.NewReader(reader)
+		nextChunk = func() ([]byte, error) { return splitter.next(byteReader) }
 	}
 
-	// Split the file into chunks
-	buffer := make([]byte, fc.chunkSize)
 	chunks := []*ChunkInfo{}
 	index := 0
 
 	for {
-		n, err := file.Read(buffer)
+		chunk, err := nextChunk()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
 			return "", nil, fmt.Errorf("failed to read file: %w", err)
 		}
-
-		// Only use the bytes that were read
-		chunk := buffer[:n]
+		n := len(chunk)
 
 		// Calculate the chunk hash for ID
 		chunkHash := sha256.Sum256(chunk)
 		chunkID := hex.EncodeToString(chunkHash[:])
 
-		// Create chunk info
-		chunkInfo := &ChunkInfo{
-			ID:     chunkID,
-			Index:  index,
-			Size:   n,
-			FileID: fileID,
+		// Write the chunk to the staging directory as it is stored
+		stored, err := fc.encodeChunk(chunk)
+		if err != nil {
+			return "", nil, err
 		}
-
-		// Write the chunk to disk
-		chunkPath := filepath.Join(fileChunksDir, chunkID)
-		if err := os.WriteFile(chunkPath, chunk, 0644); err != nil {
+		if err := fc.writeChunk(filepath.Join(stagingDir, chunkID), stored); err != nil {
 			return "", nil, fmt.Errorf("failed to write chunk: %w", err)
 		}
+		fc.writes.Add(1)
 
-		// Add the chunk info to the metadata
-		fc.mu.Lock()
-		fc.chunksMeta[chunkID] = chunkInfo
-		fc.mu.Unlock()
-
-		chunks = append(chunks, chunkInfo)
+		chunks = append(chunks, &ChunkInfo{
+			ID:         chunkID,
+			Index:      index,
+			Size:       n,
+			StoredSize: len(stored),
+		})
 		index++
 	}
 
+	fileID := hex.EncodeToString(fileHash.Sum(nil))
+
+	if fc.isLocalStore() {
+		err = fc.commitStagedLocal(stagingDir, fileID, chunks)
+	} else {
+		err = fc.commitStaged(stagingDir, fileID, chunks)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Record the file ID now that it is known
+	fc.mu.Lock()
+	for _, chunkInfo := range chunks {
+		chunkInfo.FileID = fileID
+		if _, known := fc.chunksMeta[chunkInfo.ID]; !known {
+			fc.stats.uniqueChunks++
+		}
+		fc.chunksMeta[chunkInfo.ID] = chunkInfo
+	}
+	fc.files[fileID] = chunks
+	fc.stats.recordFile(chunks)
+	fc.mu.Unlock()
+
 	return fileID, chunks, nil
 }
 
-// ReassembleFile reassembles chunks into a file
+// fixedSplitter returns a function reading chunks of the chunk size from
+// r. The returned slices are only valid until the next call.
+func (fc *FileChunker) fixedSplitter(r io.Reader) func() ([]byte, error) {
+	buffer := make([]byte, fc.chunkSize)
+	return func() ([]byte, error) {
+		n, err := io.ReadFull(r, buffer)
+		if err == io.ErrUnexpectedEOF {
+			err = nil
+		}
+		// Only use the bytes that were read
+		return buffer[:n], err
+	}
+}
+
+// FileChunks returns the chunks of a file chunked by this chunker, in
+// order. Files it doesn't know return an error wrapping os.ErrNotExist.
+func (fc *FileChunker) FileChunks(fileID string) ([]ChunkInfo, error) {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+
+	chunks, exists := fc.files[fileID]
+	if !exists {
+		return nil, fmt.Errorf("file %s: %w", fileID, os.ErrNotExist)
+	}
+
+	copied := make([]ChunkInfo, len(chunks))
+	for i, chunk := range chunks {
+		copied[i] = *chunk
+	}
+	return copied, nil
+}
+
+// DeleteChunks removes every stored chunk of a file and forgets its
+// metadata. Chunk metadata shared with other files chunked by this chunker
+// is kept. Deleting a file without chunks is not an error.
+func (fc *FileChunker) DeleteChunks(fileID string) error {
+	store := fc.chunkStore()
+
+	chunkIDs, err := store.List(fileID)
+	if err != nil {
+		return fmt.Errorf("failed to list chunks of %s: %w", fileID, err)
+	}
+
+	if _, ok := store.(*localChunkStore); ok {
+		if err := os.RemoveAll(fc.fileDir(fileID)); err != nil {
+			return fmt.Errorf("failed to remove chunks of %s: %w", fileID, err)
+		}
+	} else {
+		for _, chunkID := range chunkIDs {
+			if err := store.Delete(fileID, chunkID); err != nil {
+				return fmt.Errorf("failed to delete chunk %s: %w", chunkID, err)
+			}
+		}
+	}
+	for _, chunkID := range chunkIDs {
+		fc.uncacheChunk(fileID, chunkID)
+	}
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	chunks := fc.files[fileID]
+	delete(fc.files, fileID)
+
+	shared := make(map[string]bool)
+	for _, other := range fc.files {
+		for _, chunk := range other {
+			shared[chunk.ID] = true
+		}
+	}
+	for _, chunk := range chunks {
+		if !shared[chunk.ID] {
+			delete(fc.chunksMeta, chunk.ID)
+		}
+	}
+
+	return nil
+}
+
+// commitStagedLocal moves staged chunks into the file's directory, unless
+// the same content was chunked before
+func (fc *FileChunker) commitStagedLocal(stagingDir, fileID string, chunks []*ChunkInfo) error {
+	fileChunksDir := fc.fileDir(fileID)
+	if _, err := os.Stat(fileChunksDir); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(fileChunksDir), 0755); err != nil {
+			return fmt.Errorf("failed to create file chunks directory: %w", err)
+		}
+		if err := os.Rename(stagingDir, fileChunksDir); err != nil {
+			return fmt.Errorf("failed to move chunks into place: %w", err)
+		}
+	}
+
+	written := make([]string, 0, len(chunks))
+	for _, chunkInfo := range chunks {
+		written = append(written, filepath.Join(fileChunksDir, chunkInfo.ID))
+	}
+
+	if err := fc.syncBatch(fileChunksDir, written); err != nil {
+		return fmt.Errorf("failed to sync chunks: %w", err)
+	}
+
+	return nil
+}
+
+// commitStaged copies staged chunks into the configured chunk store,
+// skipping chunks it already holds
+func (fc *FileChunker) commitStaged(stagingDir, fileID string, chunks []*ChunkInfo) error {
+	store := fc.chunkStore()
+
+	for _, chunkInfo := range chunks {
+		exists, err := store.Exists(fileID, chunkInfo.ID)
+		if err != nil {
+			return fmt.Errorf("failed to check chunk %s: %w", chunkInfo.ID, err)
+		}
+		if exists {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(stagingDir, chunkInfo.ID))
+		if err != nil {
+			return fmt.Errorf("failed to read staged chunk %s: %w", chunkInfo.ID, err)
+		}
+		if err := store.Put(fileID, chunkInfo.ID, data); err != nil {
+			return fmt.Errorf("failed to store chunk %s: %w", chunkInfo.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// ReassembleFile reassembles chunks into a file. The chunk indices must
+// be exactly 0 to len(chunks)-1, in any order.
 func (fc *FileChunker) ReassembleFile(fileID string, chunks []*ChunkInfo, outputPath string) error {
+	sortedChunks, err := sortChunks(chunks)
+	if err != nil {
+		return err
+	}
+
 	// Create the output file
 	output, err := os.Create(outputPath)
 	if err != nil {
@@ -140,23 +411,11 @@ func (fc *FileChunker) ReassembleFile(fileID string, chunks []*ChunkInfo, output
 	}
 	defer output.Close()
 
-	// Sort chunks by index
-	sortedChunks := make([]*ChunkInfo, len(chunks))
-	for _, chunk := range chunks {
-		if chunk.Index < 0 || chunk.Index >= len(chunks) {
-			return fmt.Errorf("invalid chunk index: %d", chunk.Index)
-		}
-		sortedChunks[chunk.Index] = chunk
-	}
-
 	// Read each chunk and write it to the output file
-	fileChunksDir := filepath.Join(fc.chunksDir, fileID)
 	for _, chunk := range sortedChunks {
-		// Read the chunk from disk
-		chunkPath := filepath.Join(fileChunksDir, chunk.ID)
-		chunkData, err := os.ReadFile(chunkPath)
+		chunkData, err := fc.GetChunk(fileID, chunk.ID)
 		if err != nil {
-			return fmt.Errorf("failed to read chunk %s: %w", chunk.ID, err)
+			return err
 		}
 
 		// Write the chunk to the output file
@@ -168,38 +427,338 @@ func (fc *FileChunker) ReassembleFile(fileID string, chunks []*ChunkInfo, output
 	return nil
 }
 
-// GetChunk returns the data for a specific chunk
+// sortChunks orders chunks by index, rejecting chunk lists whose indices
+// are not exactly 0 to len(chunks)-1
+func sortChunks(chunks []*ChunkInfo) ([]*ChunkInfo, error) {
+	byIndex := make(map[int]*ChunkInfo, len(chunks))
+	for _, chunk := range chunks {
+		if chunk == nil {
+			return nil, errors.New("chunk list contains a nil chunk")
+		}
+		if chunk.Index < 0 {
+			return nil, fmt.Errorf("invalid chunk index: %d", chunk.Index)
+		}
+		if other, exists := byIndex[chunk.Index]; exists {
+			return nil, fmt.Errorf("duplicate chunk index %d (chunks %s and %s)", chunk.Index, other.ID, chunk.ID)
+		}
+		byIndex[chunk.Index] = chunk
+	}
+
+	// len(chunks) distinct indices cover 0 to len(chunks)-1 unless one is
+	// missing and another lies beyond the end
+	sorted := make([]*ChunkInfo, len(chunks))
+	for i := range sorted {
+		chunk, exists := byIndex[i]
+		if !exists {
+			return nil, fmt.Errorf("missing chunk index %d of %d chunks", i, len(chunks))
+		}
+		sorted[i] = chunk
+	}
+
+	return sorted, nil
+}
+
+// GetChunk returns the data for a specific chunk. Chunks missing from the
+// chunk store are recovered through the chunk locator when one is set.
 func (fc *FileChunker) GetChunk(fileID, chunkID string) ([]byte, error) {
-	chunkPath := filepath.Join(fc.chunksDir, fileID, chunkID)
-	data, err := os.ReadFile(chunkPath)
+	data, err := fc.GetLocalChunk(fileID, chunkID)
+	if errors.Is(err, os.ErrNotExist) {
+		return fc.recoverChunk(fileID, chunkID)
+	}
+	return data, err
+}
+
+// GetLocalChunk returns the data for a specific chunk without trying to
+// recover it when it is missing. Chunks are served from the chunk cache
+// when it is enabled. Chunks read from the store are checked against their
+// ID, corrupt ones fail with ErrChunkCorrupt.
+func (fc *FileChunker) GetLocalChunk(fileID, chunkID string) ([]byte, error) {
+	cache := fc.chunkCache()
+	if cache != nil {
+		if data, ok := cache.get(fileID, chunkID); ok {
+			fc.reads.Add(1)
+			return data, nil
+		}
+	}
+
+	stored, err := fc.chunkStore().Get(fileID, chunkID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read chunk %s: %w", chunkID, err)
 	}
+	data, err := decodeChunk(fileID, chunkID, stored)
+	if err != nil {
+		return nil, err
+	}
+
+	if cache != nil {
+		cache.put(fileID, chunkID, data)
+	}
+	fc.reads.Add(1)
 	return data, nil
 }
 
-// StoreChunk stores a chunk on disk
+// VerifyFile reads every chunk of a file from the chunk store, bypassing
+// the chunk cache, and checks it against its ID and recorded size. All
+// chunks are checked; the error lists every chunk that failed.
+func (fc *FileChunker) VerifyFile(fileID string, chunks []*ChunkInfo) error {
+	var failures []error
+	for _, chunk := range chunks {
+		stored, err := fc.chunkStore().Get(fileID, chunk.ID)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("failed to read chunk %d (%s): %w", chunk.Index, chunk.ID, err))
+			continue
+		}
+		data, err := decodeChunk(fileID, chunk.ID, stored)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("chunk %d: %w", chunk.Index, err))
+			continue
+		}
+		if chunk.Size != len(data) {
+			failures = append(failures, fmt.Errorf("chunk %d (%s): %w: size is %d bytes, expected %d", chunk.Index, chunk.ID, ErrChunkCorrupt, len(data), chunk.Size))
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("file %s failed verification (%d of %d chunks): %w", fileID, len(failures), len(chunks), errors.Join(failures...))
+	}
+	return nil
+}
+
+// verifyChunk returns ErrChunkCorrupt if data does not hash to chunkID
+func verifyChunk(fileID, chunkID string, data []byte) error {
+	hash := sha256.Sum256(data)
+	if actual := hex.EncodeToString(hash[:]); actual != chunkID {
+		return fmt.Errorf("%w: chunk %s of file %s hashes to %s", ErrChunkCorrupt, chunkID, fileID, actual)
+	}
+	return nil
+}
+
+// recoverChunk fetches a missing chunk through the chunk locator, checks it
+// against its ID and stores it again. There is no parity data to
+// reconstruct chunks from, so other nodes are the only source.
+func (fc *FileChunker) recoverChunk(fileID, chunkID string) ([]byte, error) {
+	fc.mu.RLock()
+	locate := fc.locate
+	fc.mu.RUnlock()
+
+	if locate == nil {
+		return nil, fmt.Errorf("%w: chunk %s of file %s (no chunk locator configured)", ErrChunkUnavailable, chunkID, fileID)
+	}
+
+	data, err := locate(fileID, chunkID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: chunk %s of file %s: %v", ErrChunkUnavailable, chunkID, fileID, err)
+	}
+
+	hash := sha256.Sum256(data)
+	if hex.EncodeToString(hash[:]) != chunkID {
+		return nil, fmt.Errorf("%w: chunk %s of file %s: recovered data does not match its hash", ErrChunkUnavailable, chunkID, fileID)
+	}
+
+	if err := fc.StoreChunk(fileID, chunkID, data); err != nil {
+		return nil, fmt.Errorf("failed to store recovered chunk %s: %w", chunkID, err)
+	}
+
+	return data, nil
+}
+
+// StoreChunk stores a chunk in the chunk store, compressed if chunk
+// compression is enabled
 func (fc *FileChunker) StoreChunk(fileID, chunkID string, data []byte) error {
-	// Ensure the file directory exists
-	fileChunksDir := filepath.Join(fc.chunksDir, fileID)
-	if err := os.MkdirAll(fileChunksDir, 0755); err != nil {
-		return fmt.Errorf("failed to create file chunks directory: %w", err)
+	stored, err := fc.encodeChunk(data)
+	if err != nil {
+		return err
+	}
+	fc.uncacheChunk(fileID, chunkID)
+	if err := fc.chunkStore().Put(fileID, chunkID, stored); err != nil {
+		return err
+	}
+	fc.writes.Add(1)
+	return nil
+}
+
+// writeChunk writes chunk data to disk, syncing it in safe mode
+func (fc *FileChunker) writeChunk(chunkPath string, data []byte) error {
+	fc.mu.RLock()
+	mode, syncFile := fc.durability, fc.syncFile
+	fc.mu.RUnlock()
+
+	file, err := os.OpenFile(chunkPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	if mode == DurabilitySafe {
+		if err := syncFile(file); err != nil {
+			file.Close()
+			return err
+		}
+	}
+
+	return file.Close()
+}
+
+// syncBatch flushes a batch of written chunks and their directory in batch mode
+func (fc *FileChunker) syncBatch(dir string, chunkPaths []string) error {
+	fc.mu.RLock()
+	mode, syncFile := fc.durability, fc.syncFile
+	fc.mu.RUnlock()
+
+	if mode != DurabilityBatch {
+		return nil
 	}
 
-	// Write the chunk to disk
-	chunkPath := filepath.Join(fileChunksDir, chunkID)
-	if err := os.WriteFile(chunkPath, data, 0644); err != nil {
-		return fmt.Errorf("failed to write chunk: %w", err)
+	for _, path := range append(chunkPaths, dir) {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		err = syncFile(file)
+		file.Close()
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-// calculateFileHash calculates the SHA-256 hash of a file
-func calculateFileHash(file *os.File) (string, error) {
-	hash := sha256.New()
-	if _, err := io.Copy(hash, file); err != nil {
-		return "", err
+// stagingDirName is the directory under the chunks directory where
+// ChunkReader writes chunks before the file ID is known
+const stagingDirName = ".staging"
+
+// QuarantineDirName is the directory under the chunks directory where
+// corrupt chunks are moved by ScanChunkStore
+const QuarantineDirName = ".quarantine"
+
+// QuarantinedChunk describes a chunk that failed verification during a scan
+type QuarantinedChunk struct {
+	FileID      string `json:"fileId"`
+	ChunkID     string `json:"chunkId"`
+	Reason      string `json:"reason"`
+	Repaired    bool   `json:"repaired"`              // A good copy was fetched from another node
+	RepairError string `json:"repairError,omitempty"` // Why the repair failed, empty if none was tried
+}
+
+// ScanReport summarizes the result of a chunk store scan
+type ScanReport struct {
+	Scanned     int                `json:"scanned"`
+	Healthy     int                `json:"healthy"`
+	Quarantined []QuarantinedChunk `json:"quarantined"`
+	Repaired    int                `json:"repaired"`          // Quarantined chunks replaced by a good copy
+	Corrupt     []QuarantinedChunk `json:"corrupt,omitempty"` // Corrupt chunks found by a read-only scan
+	Errors      []string           `json:"errors,omitempty"`
+}
+
+// ScanChunkStore walks every stored chunk, recomputes its hash and moves
+// chunks whose content no longer matches their ID into the quarantine
+// directory. With a chunk locator set, quarantined chunks are repaired
+// right away by fetching a good copy from another node.
+func (fc *FileChunker) ScanChunkStore() (*ScanReport, error) {
+	return fc.scanChunks(true)
+}
+
+// VerifyChunkStore checks every stored chunk like ScanChunkStore but only
+// reports corrupt chunks instead of moving them
+func (fc *FileChunker) VerifyChunkStore() (*ScanReport, error) {
+	return fc.scanChunks(false)
+}
+
+// scanChunks verifies all stored chunks, optionally quarantining corrupt ones
+func (fc *FileChunker) scanChunks(quarantine bool) (*ScanReport, error) {
+	if !fc.isLocalStore() {
+		return nil, ErrStoreNotLocal
 	}
-	return hex.EncodeToString(hash.Sum(nil)), nil
+
+	report := &ScanReport{Quarantined: []QuarantinedChunk{}}
+
+	fileIDs, err := fc.listFileIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fileID := range fileIDs {
+		entries, err := os.ReadDir(fc.fileDir(fileID))
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("failed to read %s: %v", fileID, err))
+			continue
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+
+			chunkID := entry.Name()
+			report.Scanned++
+
+			data, err := os.ReadFile(filepath.Join(fc.fileDir(fileID), chunkID))
+			if err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("failed to read chunk %s: %v", chunkID, err))
+				continue
+			}
+
+			if _, err := decodeChunk(fileID, chunkID, data); err == nil {
+				report.Healthy++
+				continue
+			}
+
+			corrupt := QuarantinedChunk{
+				FileID:  fileID,
+				ChunkID: chunkID,
+				Reason:  "content hash mismatch",
+			}
+
+			if !quarantine {
+				report.Corrupt = append(report.Corrupt, corrupt)
+				continue
+			}
+
+			if err := fc.quarantineChunk(fileID, chunkID); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("failed to quarantine chunk %s: %v", chunkID, err))
+				continue
+			}
+
+			fc.mu.RLock()
+			canRepair := fc.locate != nil
+			fc.mu.RUnlock()
+			if canRepair {
+				if _, err := fc.recoverChunk(fileID, chunkID); err != nil {
+					corrupt.RepairError = err.Error()
+				} else {
+					corrupt.Repaired = true
+					report.Repaired++
+				}
+			}
+
+			report.Quarantined = append(report.Quarantined, corrupt)
+		}
+	}
+
+	return report, nil
+}
+
+// quarantineChunk moves a chunk into the quarantine directory and forgets it
+func (fc *FileChunker) quarantineChunk(fileID, chunkID string) error {
+	quarantineDir := filepath.Join(fc.chunksDir, QuarantineDirName, fileID)
+	if err := os.MkdirAll(quarantineDir, 0755); err != nil {
+		return err
+	}
+
+	src := filepath.Join(fc.fileDir(fileID), chunkID)
+	if err := os.Rename(src, filepath.Join(quarantineDir, chunkID)); err != nil {
+		return err
+	}
+	fc.uncacheChunk(fileID, chunkID)
+
+	fc.mu.Lock()
+	delete(fc.chunksMeta, chunkID)
+	fc.mu.Unlock()
+
+	return nil
 }
@@ -0,0 +1,79 @@
+package fs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// SetChunkCompression sets whether chunks are gzip-compressed before they
+// are stored. Chunks that don't get smaller are stored as they are. Chunk
+// IDs stay the hash of the uncompressed content, and chunks stored either
+// way can be read whatever the setting.
+func (fc *FileChunker) SetChunkCompression(enabled bool) {
+	fc.mu.Lock()
+	fc.compress = enabled
+	fc.mu.Unlock()
+}
+
+// encodeChunk returns chunk data as it is stored
+func (fc *FileChunker) encodeChunk(data []byte) ([]byte, error) {
+	fc.mu.RLock()
+	compress := fc.compress
+	fc.mu.RUnlock()
+
+	if !compress {
+		return data, nil
+	}
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to compress chunk: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress chunk: %w", err)
+	}
+
+	if buf.Len() >= len(data) {
+		return data, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeChunk returns the content of a stored chunk, decompressing it if
+// it was compressed, and checks it against the chunk ID. Stored data is
+// only taken as compressed if it decompresses to content matching the ID,
+// so uncompressed chunks that happen to hold gzip data read back as is.
+func decodeChunk(fileID, chunkID string, stored []byte) ([]byte, error) {
+	if len(stored) >= 2 && stored[0] == 0x1f && stored[1] == 0x8b {
+		if data, err := gunzipChunk(stored); err == nil && verifyChunk(fileID, chunkID, data) == nil {
+			return data, nil
+		}
+	}
+
+	if err := verifyChunk(fileID, chunkID, stored); err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// gunzipChunk decompresses chunk data, refusing to inflate it beyond the
+// maximum chunk size
+func gunzipChunk(stored []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(stored))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, MaxChunkSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxChunkSize {
+		return nil, fmt.Errorf("chunk decompresses beyond %d bytes", MaxChunkSize)
+	}
+	return data, nil
+}
@@ -0,0 +1,63 @@
+package fs
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sniffLen is how much of the content is looked at to detect its type
+const sniffLen = 512
+
+// DetectContentType returns the MIME type of content starting with head,
+// as sniffed by http.DetectContentType. Where sniffing only finds generic
+// binary or plain text, the type registered for the name's extension is
+// used instead if there is one, e.g. for JSON, CSS or JavaScript.
+func DetectContentType(name string, head []byte) string {
+	if len(head) > sniffLen {
+		head = head[:sniffLen]
+	}
+
+	sniffed := http.DetectContentType(head)
+	if sniffed == "application/octet-stream" || strings.HasPrefix(sniffed, "text/plain") {
+		if byExt := mime.TypeByExtension(strings.ToLower(filepath.Ext(name))); byExt != "" {
+			return byExt
+		}
+	}
+	return sniffed
+}
+
+// headBuffer keeps the first sniffLen bytes written to it
+type headBuffer struct {
+	data []byte
+}
+
+// Write implements io.Writer
+func (b *headBuffer) Write(p []byte) (int, error) {
+	if room := sniffLen - len(b.data); room > 0 {
+		if len(p) < room {
+			room = len(p)
+		}
+		b.data = append(b.data, p[:room]...)
+	}
+	return len(p), nil
+}
+
+// sniffFile detects the content type of a file from its first bytes
+func sniffFile(fullPath string) (string, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return DetectContentType(fullPath, head[:n]), nil
+}
@@ -0,0 +1,185 @@
+package fs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// CopyBuffer copies src to dst through a buffer of size bytes, or with
+// io.Copy's default 32KB buffer when size is 0. Unlike io.CopyBuffer it
+// always uses the buffer, even when dst or src could copy on their own.
+func CopyBuffer(dst io.Writer, src io.Reader, size int) (int64, error) {
+	if size <= 0 {
+		return io.Copy(dst, src)
+	}
+
+	// Hide ReaderFrom and WriterTo so io.CopyBuffer can't bypass the buffer
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, make([]byte, size))
+}
+
+// CopyBufferContext works like CopyBuffer but stops with the context's
+// error as soon as ctx is done, e.g. when the client of a download went away
+func CopyBufferContext(ctx context.Context, dst io.Writer, src io.Reader, size int) (int64, error) {
+	return CopyBuffer(dst, &contextReader{ctx: ctx, r: src}, size)
+}
+
+// contextReader fails reads once its context is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// Read implements io.Reader
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+// SetCopyBufferSize sets the buffer size used to copy file content in
+// uploads, downloads and moves. 0 keeps io.Copy's default.
+func (dfs *DistributedFileSystem) SetCopyBufferSize(size int) {
+	dfs.mu.Lock()
+	defer dfs.mu.Unlock()
+
+	dfs.copyBufferSize = size
+}
+
+// CopyBufferSize returns the buffer size used to copy file content
+func (dfs *DistributedFileSystem) CopyBufferSize() int {
+	dfs.mu.RLock()
+	defer dfs.mu.RUnlock()
+
+	return dfs.copyBufferSize
+}
+
+// CopyOptions controls how a file is copied
+type CopyOptions struct {
+	Overwrite bool // Replace an existing file at the destination
+}
+
+// CopyFile copies a file to a new path, keeping the original. Missing
+// parent directories of the destination are created. Copying onto an
+// existing file fails with os.ErrExist.
+func (dfs *DistributedFileSystem) CopyFile(sourcePath, destPath string) error {
+	return dfs.CopyFileWithOptions(sourcePath, destPath, CopyOptions{})
+}
+
+// CopyFileWithOptions works like CopyFile with explicit options
+func (dfs *DistributedFileSystem) CopyFileWithOptions(sourcePath, destPath string, opts CopyOptions) error {
+	dfs.mu.Lock()
+	defer dfs.mu.Unlock()
+
+	if dfs.isRoot(sourcePath) || dfs.isRoot(destPath) {
+		return ErrRootPath
+	}
+
+	sourceFullPath, err := dfs.resolvePath(sourcePath)
+	if err != nil {
+		return err
+	}
+	destFullPath, err := dfs.resolvePath(destPath)
+	if err != nil {
+		return err
+	}
+	if sourceFullPath == destFullPath {
+		return errors.New("source and destination are the same file")
+	}
+
+	// Only files can be copied for now
+	sourceInfo, err := os.Stat(sourceFullPath)
+	if err != nil {
+		return err
+	}
+	if sourceInfo.IsDir() {
+		return fmt.Errorf("only files can be copied, %s: %w", sourcePath, ErrIsDirectory)
+	}
+
+	if err := dfs.checkPathComponents(destPath, false); err != nil {
+		return err
+	}
+	eventType := FileCreated
+	if destInfo, err := os.Stat(destFullPath); err == nil {
+		if destInfo.IsDir() {
+			return fmt.Errorf("destination %s: %w", destPath, ErrIsDirectory)
+		}
+		if !opts.Overwrite {
+			return fmt.Errorf("%w: %s", os.ErrExist, destPath)
+		}
+		eventType = FileModified
+	}
+
+	destDir := filepath.Dir(destFullPath)
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return err
+	}
+
+	src, err := os.Open(sourceFullPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// Copy to a temporary file renamed into place like uploads, so a failed
+	// copy leaves an existing destination untouched
+	dst, err := createUploadTemp(destDir)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(dst.Name()) // No-op once renamed
+
+	// Hash the content on the way so the copy gets its own checksum and
+	// content type
+	hash := sha256.New()
+	head := &headBuffer{}
+	if _, err := CopyBuffer(dst, io.TeeReader(src, io.MultiWriter(hash, head)), dfs.copyBufferSize); err != nil {
+		dst.Close()
+		return err
+	}
+	if dfs.durability != DurabilityFast {
+		if err := dfs.syncFile(dst); err != nil {
+			dst.Close()
+			return fmt.Errorf("failed to sync file: %w", err)
+		}
+	}
+	if err := dst.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+	if err := os.Rename(dst.Name(), destFullPath); err != nil {
+		return err
+	}
+	if dfs.durability != DurabilityFast {
+		if err := dfs.syncDir(destDir); err != nil {
+			return fmt.Errorf("failed to sync directory: %w", err)
+		}
+	}
+
+	dfs.invalidateMerkle(destPath)
+
+	info, err := os.Stat(destFullPath)
+	if err != nil {
+		return err
+	}
+	dfs.fileInfo[destPath] = &FileInfo{
+		Name:        filepath.Base(destPath),
+		Path:        destPath,
+		Size:        info.Size(),
+		IsDir:       false,
+		ModTime:     info.ModTime(),
+		Replicas:    dfs.inheritedReplicas(destPath),
+		Available:   true,
+		SHA256:      hex.EncodeToString(hash.Sum(nil)),
+		ContentType: DetectContentType(destPath, head.data),
+	}
+	dfs.metadataDirty = true
+	dfs.publishFileEvent(FileEvent{Type: eventType, Path: destPath})
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package fs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// dedupRefsDirName is the directory under a dedup store's directory that
+// records which files reference which chunks
+const dedupRefsDirName = ".refs"
+
+// DedupChunkStore keeps every distinct chunk once, as <dir>/<chunkID>, no
+// matter how many files contain it. Each file's reference to a chunk is
+// recorded as an empty file <dir>/.refs/<fileID>/<chunkID>, so reference
+// counts survive restarts without rewriting an index on every change. A
+// chunk's data is removed when its last reference is deleted.
+type DedupChunkStore struct {
+	dir  string
+	refs map[string]map[string]bool // File IDs referencing each chunk, by chunk ID
+	mu   sync.Mutex
+}
+
+// NewDedupChunkStore opens a deduplicating chunk store in dir, rebuilding
+// the reference counts from the recorded references
+func NewDedupChunkStore(dir string) (*DedupChunkStore, error) {
+	refsDir := filepath.Join(dir, dedupRefsDirName)
+	if err := os.MkdirAll(refsDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create chunk store directory: %w", err)
+	}
+
+	s := &DedupChunkStore{
+		dir:  dir,
+		refs: make(map[string]map[string]bool),
+	}
+
+	fileDirs, err := os.ReadDir(refsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk references: %w", err)
+	}
+	for _, fileDir := range fileDirs {
+		if !fileDir.IsDir() {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(refsDir, fileDir.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read chunk references of %s: %w", fileDir.Name(), err)
+		}
+		for _, entry := range entries {
+			s.addRef(entry.Name(), fileDir.Name())
+		}
+	}
+
+	return s, nil
+}
+
+// Put adds a reference from a file to a chunk, writing the chunk data only
+// if no other file references it yet
+func (s *DedupChunkStore) Put(fileID, chunkID string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.refs[chunkID][fileID] {
+		return nil
+	}
+
+	if len(s.refs[chunkID]) == 0 {
+		if err := writeFileAtomic(s.chunkPath(chunkID), data); err != nil {
+			return fmt.Errorf("failed to write chunk: %w", err)
+		}
+	}
+
+	refDir := filepath.Join(s.dir, dedupRefsDirName, fileID)
+	if err := os.MkdirAll(refDir, 0755); err != nil {
+		return fmt.Errorf("failed to create chunk references directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(refDir, chunkID), nil, 0644); err != nil {
+		return fmt.Errorf("failed to record chunk reference: %w", err)
+	}
+	s.addRef(chunkID, fileID)
+
+	return nil
+}
+
+// Get reads a chunk referenced by a file
+func (s *DedupChunkStore) Get(fileID, chunkID string) ([]byte, error) {
+	s.mu.Lock()
+	referenced := s.refs[chunkID][fileID]
+	s.mu.Unlock()
+
+	if !referenced {
+		return nil, fmt.Errorf("chunk %s: %w", chunkID, os.ErrNotExist)
+	}
+	return os.ReadFile(s.chunkPath(chunkID))
+}
+
+// Delete drops a file's reference to a chunk and removes the chunk data
+// once nothing references it anymore
+func (s *DedupChunkStore) Delete(fileID, chunkID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.refs[chunkID][fileID] {
+		return nil
+	}
+
+	refDir := filepath.Join(s.dir, dedupRefsDirName, fileID)
+	if err := os.Remove(filepath.Join(refDir, chunkID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	os.Remove(refDir) // Only succeeds once the file references nothing
+
+	delete(s.refs[chunkID], fileID)
+	if len(s.refs[chunkID]) > 0 {
+		return nil
+	}
+	delete(s.refs, chunkID)
+
+	if err := os.Remove(s.chunkPath(chunkID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Exists reports whether a file references a chunk
+func (s *DedupChunkStore) Exists(fileID, chunkID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.refs[chunkID][fileID], nil
+}
+
+// List returns the IDs of the chunks a file references
+func (s *DedupChunkStore) List(fileID string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, dedupRefsDirName, fileID))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	chunkIDs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		chunkIDs = append(chunkIDs, entry.Name())
+	}
+
+	return chunkIDs, nil
+}
+
+// RefCount returns the number of files referencing a chunk
+func (s *DedupChunkStore) RefCount(chunkID string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.refs[chunkID])
+}
+
+// addRef records a reference in memory. Callers must hold s.mu or own s.
+func (s *DedupChunkStore) addRef(chunkID, fileID string) {
+	if s.refs[chunkID] == nil {
+		s.refs[chunkID] = make(map[string]bool)
+	}
+	s.refs[chunkID][fileID] = true
+}
+
+// chunkPath returns the path of a chunk's data
+func (s *DedupChunkStore) chunkPath(chunkID string) string {
+	return filepath.Join(s.dir, chunkID)
+}
@@ -0,0 +1,80 @@
+package fs
+
+import (
+	"sync"
+	"time"
+)
+
+// fileEventBuffer is how many events a subscriber may fall behind before
+// it is dropped
+const fileEventBuffer = 64
+
+// FileEventType is the kind of change a FileEvent reports
+type FileEventType string
+
+const (
+	FileCreated  FileEventType = "created"
+	FileModified FileEventType = "modified"
+	FileRemoved  FileEventType = "removed"
+	FileMoved    FileEventType = "moved"
+)
+
+// FileEvent reports a change to a file or directory
+type FileEvent struct {
+	Type     FileEventType `json:"type"`
+	Path     string        `json:"path"`
+	OldPath  string        `json:"oldPath,omitempty"` // Set for moves
+	IsDir    bool          `json:"isDir"`
+	External bool          `json:"external"` // Made outside this file system, e.g. directly on disk
+	Time     time.Time     `json:"time"`
+}
+
+// fileEvents fans file events out to subscribers
+type fileEvents struct {
+	subscribers map[chan FileEvent]struct{}
+	mu          sync.Mutex
+}
+
+// SubscribeFileEvents returns a channel receiving file events and a
+// function ending the subscription. Subscribers that fall behind have
+// their channel closed instead of blocking the file system.
+func (dfs *DistributedFileSystem) SubscribeFileEvents() (<-chan FileEvent, func()) {
+	events := make(chan FileEvent, fileEventBuffer)
+
+	dfs.events.mu.Lock()
+	if dfs.events.subscribers == nil {
+		dfs.events.subscribers = make(map[chan FileEvent]struct{})
+	}
+	dfs.events.subscribers[events] = struct{}{}
+	dfs.events.mu.Unlock()
+
+	cancel := func() {
+		dfs.events.mu.Lock()
+		defer dfs.events.mu.Unlock()
+		if _, ok := dfs.events.subscribers[events]; ok {
+			delete(dfs.events.subscribers, events)
+			close(events)
+		}
+	}
+
+	return events, cancel
+}
+
+// publishFileEvent hands an event to all subscribers without blocking
+func (dfs *DistributedFileSystem) publishFileEvent(event FileEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	dfs.events.mu.Lock()
+	defer dfs.events.mu.Unlock()
+
+	for events := range dfs.events.subscribers {
+		select {
+		case events <- event:
+		default:
+			delete(dfs.events.subscribers, events)
+			close(events)
+		}
+	}
+}
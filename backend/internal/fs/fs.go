@@ -1,57 +1,155 @@
 package fs
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
+// Errors returned by file system operations
+var (
+	ErrIsDirectory    = errors.New("cannot download a directory")
+	ErrParentNotFound = errors.New("parent directory does not exist")
+	ErrPathConflict   = errors.New("path component is a file, not a directory")
+	ErrRootPath       = errors.New("operation is not allowed on the root directory")
+	ErrDirNotEmpty    = errors.New("directory is not empty")
+	ErrNotDirectory   = errors.New("path is not a directory")
+	ErrOutsideRoot    = errors.New("path is outside the root directory")
+	ErrReservedPath   = errors.New("path is reserved for the file system's own files")
+)
+
 // FileInfo represents metadata about a file
 type FileInfo struct {
-	Name      string    `json:"name"`
-	Path      string    `json:"path"`
-	Size      int64     `json:"size"`
-	IsDir     bool      `json:"isDir"`
-	ModTime   time.Time `json:"modTime"`
-	Replicas  int       `json:"replicas"`
-	Available bool      `json:"available"`
+	Name        string            `json:"name"`
+	Path        string            `json:"path"`
+	Size        int64             `json:"size"`
+	IsDir       bool              `json:"isDir"`
+	ModTime     time.Time         `json:"modTime"`
+	Replicas    int               `json:"replicas"`
+	Available   bool              `json:"available"`
+	SHA256      string            `json:"sha256,omitempty"`      // Content hash recorded at upload time
+	Checksums   map[string]string `json:"checksums,omitempty"`   // Digests computed on demand, by algorithm
+	ContentType string            `json:"contentType,omitempty"` // MIME type detected from the content, files only
 }
 
 // DistributedFileSystem manages the distributed file operations
 type DistributedFileSystem struct {
-	rootDir  string
-	fileInfo map[string]*FileInfo
-	mu       sync.RWMutex
+	rootDir    string
+	fileInfo   map[string]*FileInfo
+	rename     func(oldPath, newPath string) error
+	autoMkdir  bool
+	durability DurabilityMode
+	syncFile   func(*os.File) error
+	sizePolicy []ReplicaSizeTier
+	scanner    UploadScanner
+	chunker    *FileChunker // Chunks of deleted files are removed from it, nil if unset
+	closed     bool
+	mu         sync.RWMutex
+
+	copyBufferSize int // Buffer size for copying file content, 0 uses io.Copy's default
+	io             *ioCounter
+	dirReplicas    map[string]int // Replication factor inherited by new files, by directory
+
+	persistMetadata bool          // Set by LoadMetadata
+	metadataDirty   bool          // Metadata changed since the last save
+	stopFlush       chan struct{} // Stops the metadata flush, nil when not flushing
+	saveMu          sync.Mutex
+
+	events  fileEvents
+	watcher io.Closer // Watches for external changes, nil when not watching
+	hidden  []string  // Paths below the root managed by others, see SetHiddenPaths
+
+	merkleHashes map[string]string // Cached Merkle hashes by path
+	merkleGen    uint64            // Bumped whenever cached hashes are invalidated
+	merkleMu     sync.Mutex
+
+	logger atomic.Pointer[slog.Logger] // nil logs to slog.Default()
 }
 
 // NewDistributedFileSystem creates a new instance of the distributed file system
+// rooted at ./data
 func NewDistributedFileSystem() *DistributedFileSystem {
-	// Default root directory is ./data
-	rootDir := "./data"
-	
+	return NewDistributedFileSystemAt("./data")
+}
+
+// NewDistributedFileSystemAt creates a distributed file system storing its
+// files below rootDir
+func NewDistributedFileSystemAt(rootDir string) *DistributedFileSystem {
 	// Create the root directory if it doesn't exist
 	if _, err := os.Stat(rootDir); os.IsNotExist(err) {
 		os.MkdirAll(rootDir, 0755)
 	}
 	
 	return &DistributedFileSystem{
-		rootDir:  rootDir,
-		fileInfo: make(map[string]*FileInfo),
-		mu:       sync.RWMutex{},
+		rootDir:    rootDir,
+		fileInfo:   make(map[string]*FileInfo),
+		rename:     os.Rename,
+		autoMkdir:  true,
+		durability: DurabilityFast,
+		syncFile:   (*os.File).Sync,
+		scanner:    NopScanner{},
+		mu:         sync.RWMutex{},
+		io:         newIOCounter(),
+
+		merkleHashes: make(map[string]string),
+		dirReplicas:  make(map[string]int),
 	}
 }
 
+// Close flushes and releases everything the file system holds. It is safe
+// to call more than once; calls after the first are no-ops.
+func (dfs *DistributedFileSystem) Close() error {
+	dfs.mu.Lock()
+	if dfs.closed {
+		dfs.mu.Unlock()
+		return nil
+	}
+	dfs.closed = true
+	
+	if dfs.watcher != nil {
+		dfs.watcher.Close()
+		dfs.watcher = nil
+	}
+	if dfs.stopFlush != nil {
+		close(dfs.stopFlush)
+		dfs.stopFlush = nil
+	}
+	dfs.mu.Unlock()
+	
+	// Persist the metadata before dropping the cache, it is rebuilt from
+	// disk and the metadata index on the next start
+	err := dfs.SaveMetadata()
+	
+	dfs.mu.Lock()
+	dfs.fileInfo = make(map[string]*FileInfo)
+	dfs.mu.Unlock()
+	
+	return err
+}
+
 // ListFiles returns a list of files in the specified directory
 func (dfs *DistributedFileSystem) ListFiles(dirPath string) ([]FileInfo, error) {
-	dfs.mu.RLock()
-	defer dfs.mu.RUnlock()
+	// Listed entries are cached by refreshFileInfo, which needs the write
+	// lock
+	dfs.mu.Lock()
+	defer dfs.mu.Unlock()
 	
 	// Ensure the path is relative to the root
-	fullPath := filepath.Join(dfs.rootDir, dirPath)
+	fullPath, err := dfs.resolvePath(dirPath)
+	if err != nil {
+		return nil, err
+	}
 	
 	// Check if the directory exists
 	info, err := os.Stat(fullPath)
@@ -60,7 +158,7 @@ func (dfs *DistributedFileSystem) ListFiles(dirPath string) ([]FileInfo, error)
 	}
 	
 	if !info.IsDir() {
-		return nil, errors.New("path is not a directory")
+		return nil, ErrNotDirectory
 	}
 	
 	// Read the directory contents
@@ -77,20 +175,12 @@ func (dfs *DistributedFileSystem) ListFiles(dirPath string) ([]FileInfo, error)
 		}
 		
 		relativePath := filepath.Join(dirPath, entry.Name())
-		fileInfo := FileInfo{
-			Name:      entry.Name(),
-			Path:      relativePath,
-			Size:      info.Size(),
-			IsDir:     entry.IsDir(),
-			ModTime:   info.ModTime(),
-			Replicas:  1, // Default to 1 replica
-			Available: true,
+		if dfs.isInternalFile(relativePath) {
+			continue
 		}
 		
-		files = append(files, fileInfo)
-		
-		// Update the cached file info
-		dfs.fileInfo[relativePath] = &fileInfo
+		fileInfo := dfs.refreshFileInfo(relativePath, info).clone()
+		files = append(files, *fileInfo)
 	}
 	
 	return files, nil
@@ -101,7 +191,14 @@ func (dfs *DistributedFileSystem) CreateDirectory(dirPath string) error {
 	dfs.mu.Lock()
 	defer dfs.mu.Unlock()
 	
-	fullPath := filepath.Join(dfs.rootDir, dirPath)
+	fullPath, err := dfs.resolvePath(dirPath)
+	if err != nil {
+		return err
+	}
+	
+	if err := dfs.checkPathComponents(dirPath, true); err != nil {
+		return err
+	}
 	
 	// Check if the directory already exists
 	if _, err := os.Stat(fullPath); err == nil {
@@ -109,11 +206,13 @@ func (dfs *DistributedFileSystem) CreateDirectory(dirPath string) error {
 	}
 	
 	// Create the directory
-	err := os.MkdirAll(fullPath, 0755)
+	err = os.MkdirAll(fullPath, 0755)
 	if err != nil {
 		return err
 	}
 	
+	dfs.invalidateMerkle(dirPath)
+	
 	// Update file info cache
 	info, _ := os.Stat(fullPath)
 	dfs.fileInfo[dirPath] = &FileInfo{
@@ -125,6 +224,7 @@ func (dfs *DistributedFileSystem) CreateDirectory(dirPath string) error {
 		Replicas:  1,
 		Available: true,
 	}
+	dfs.publishFileEvent(FileEvent{Type: FileCreated, Path: dirPath, IsDir: true})
 	
 	return nil
 }
@@ -134,7 +234,14 @@ func (dfs *DistributedFileSystem) DeleteFile(path string) error {
 	dfs.mu.Lock()
 	defer dfs.mu.Unlock()
 	
-	fullPath := filepath.Join(dfs.rootDir, path)
+	if dfs.isRoot(path) {
+		return ErrRootPath
+	}
+	
+	fullPath, err := dfs.resolvePath(path)
+	if err != nil {
+		return err
+	}
 	
 	// Check if the file exists
 	info, err := os.Stat(fullPath)
@@ -150,10 +257,16 @@ func (dfs *DistributedFileSystem) DeleteFile(path string) error {
 		}
 		
 		if len(entries) > 0 {
-			return errors.New("directory is not empty")
+			return ErrDirNotEmpty
 		}
 	}
 	
+	// Find the chunks of the file before its content is gone
+	var chunkFileID string
+	if !info.IsDir() {
+		chunkFileID = dfs.chunkFileID(path)
+	}
+	
 	// Remove the file or directory
 	err = os.Remove(fullPath)
 	if err != nil {
@@ -162,57 +275,329 @@ func (dfs *DistributedFileSystem) DeleteFile(path string) error {
 	
 	// Remove from cache
 	delete(dfs.fileInfo, path)
+	dfs.deleteChunks(chunkFileID)
+	dfs.publishFileEvent(FileEvent{Type: FileRemoved, Path: path, IsDir: info.IsDir()})
+	if info.IsDir() {
+		delete(dfs.dirReplicas, policyKey(path))
+	}
+	dfs.metadataDirty = true
+	dfs.invalidateMerkle(path)
+	
+	return nil
+}
+
+// DeleteDirectoryRecursive deletes a directory with everything below it
+// and drops their cached metadata and replication policies
+func (dfs *DistributedFileSystem) DeleteDirectoryRecursive(dirPath string) error {
+	dfs.mu.Lock()
+	defer dfs.mu.Unlock()
+	
+	if dfs.isRoot(dirPath) {
+		return ErrRootPath
+	}
+	
+	fullPath, err := dfs.resolvePath(dirPath)
+	if err != nil {
+		return err
+	}
+	
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return ErrNotDirectory
+	}
+	
+	// Find the chunks of the files below before their content is gone
+	var chunkFileIDs []string
+	if dfs.chunker != nil {
+		filepath.WalkDir(fullPath, func(entryPath string, entry os.DirEntry, err error) error {
+			if err == nil && !entry.IsDir() {
+				if rel, err := filepath.Rel(dfs.rootDir, entryPath); err == nil {
+					chunkFileIDs = append(chunkFileIDs, dfs.chunkFileID(rel))
+				}
+			}
+			return nil
+		})
+	}
+	
+	if err := os.RemoveAll(fullPath); err != nil {
+		return err
+	}
+	
+	// Remove the directory and everything below it from the cache
+	dir := policyKey(dirPath)
+	for path := range dfs.fileInfo {
+		key := policyKey(path)
+		if key == dir || strings.HasPrefix(key, dir+"/") {
+			delete(dfs.fileInfo, path)
+		}
+	}
+	for path := range dfs.dirReplicas {
+		if path == dir || strings.HasPrefix(path, dir+"/") {
+			delete(dfs.dirReplicas, path)
+		}
+	}
+	for _, fileID := range chunkFileIDs {
+		dfs.deleteChunks(fileID)
+	}
+	dfs.metadataDirty = true
+	dfs.publishFileEvent(FileEvent{Type: FileRemoved, Path: dirPath, IsDir: true})
+	dfs.invalidateMerkle(dirPath)
 	
 	return nil
 }
 
+// UploadOptions controls how a file is uploaded
+type UploadOptions struct {
+	CreateParents bool // Create missing parent directories
+}
+
+// SetAutoMkdir sets whether uploads create missing parent directories by default
+func (dfs *DistributedFileSystem) SetAutoMkdir(enabled bool) {
+	dfs.mu.Lock()
+	defer dfs.mu.Unlock()
+	
+	dfs.autoMkdir = enabled
+}
+
+// SetDurability sets whether uploads are flushed to disk before they are
+// acknowledged. Fast leaves flushing to the OS, safe and batch fsync the
+// file and its directory (an upload is its own batch).
+func (dfs *DistributedFileSystem) SetDurability(mode DurabilityMode) error {
+	switch mode {
+	case DurabilityFast, DurabilitySafe, DurabilityBatch:
+	default:
+		return fmt.Errorf("unknown durability mode: %s", mode)
+	}
+	
+	dfs.mu.Lock()
+	defer dfs.mu.Unlock()
+	
+	dfs.durability = mode
+	
+	return nil
+}
+
+// SetSyncer replaces the function used to fsync uploaded files and their directories
+func (dfs *DistributedFileSystem) SetSyncer(syncFile func(*os.File) error) {
+	dfs.mu.Lock()
+	defer dfs.mu.Unlock()
+	
+	dfs.syncFile = syncFile
+}
+
+// DefaultUploadOptions returns the upload options configured for the file system
+func (dfs *DistributedFileSystem) DefaultUploadOptions() UploadOptions {
+	dfs.mu.RLock()
+	defer dfs.mu.RUnlock()
+	
+	return UploadOptions{
+		CreateParents: dfs.autoMkdir,
+	}
+}
+
 // UploadFile uploads a file to the specified path
 func (dfs *DistributedFileSystem) UploadFile(filePath string, content io.Reader) error {
+	return dfs.UploadFileWithOptions(filePath, content, dfs.DefaultUploadOptions())
+}
+
+// UploadFileWithOptions uploads a file to the specified path using the given options
+func (dfs *DistributedFileSystem) UploadFileWithOptions(filePath string, content io.Reader, opts UploadOptions) error {
 	dfs.mu.Lock()
 	defer dfs.mu.Unlock()
 	
-	fullPath := filepath.Join(dfs.rootDir, filePath)
+	if dfs.isRoot(filePath) {
+		return ErrRootPath
+	}
 	
-	// Create parent directories if they don't exist
-	dir := filepath.Dir(fullPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	fullPath, err := dfs.resolvePath(filePath)
+	if err != nil {
 		return err
 	}
 	
-	// Create the file
-	file, err := os.Create(fullPath)
+	if err := dfs.checkPathComponents(filePath, false); err != nil {
+		return err
+	}
+	
+	// Create parent directories if they don't exist, or make sure they do
+	dir := filepath.Dir(fullPath)
+	if opts.CreateParents {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	} else if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return fmt.Errorf("%w: %s", ErrParentNotFound, filepath.Dir(filePath))
+	}
+	
+	// Write to a temporary file next to the target and rename it into
+	// place once complete, so an interrupted upload never leaves a
+	// truncated file at the path
+	_, statErr := os.Stat(fullPath)
+	eventType := FileCreated
+	if statErr == nil {
+		eventType = FileModified
+	}
+	file, err := createUploadTemp(dir)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	tmpPath := file.Name()
+	defer os.Remove(tmpPath) // No-op once renamed
+	
+	// New uploads get the replication factor of their directory while
+	// overwrites keep the file's own, stop reading as soon as the content
+	// is larger than the replica size policy allows for that
+	replicas := dfs.inheritedReplicas(filePath)
+	if existing, exists := dfs.fileInfo[filePath]; exists && statErr == nil && !existing.IsDir {
+		replicas = existing.Replicas
+	}
+	limit := dfs.maxFileSize(replicas)
+	if limit > 0 {
+		content = io.LimitReader(content, limit+1)
+	}
 	
-	// Write the content to the file
-	_, err = io.Copy(file, content)
+	// Write the content to the file, hashing it and keeping its first
+	// bytes for content type detection along the way
+	hash := sha256.New()
+	head := &headBuffer{}
+	written, err := CopyBuffer(&countingWriter{w: file, counter: dfs.io}, io.TeeReader(content, io.MultiWriter(hash, head)), dfs.copyBufferSize)
 	if err != nil {
+		file.Close()
 		return err
 	}
+	if limit > 0 && written > limit {
+		file.Close()
+		return fmt.Errorf("%w: limit for %d replicas is %d bytes", ErrReplicaSizeExceeded, replicas, limit)
+	}
+	
+	// Flush the data before acknowledging the upload, and make sure the
+	// close succeeded since it can report delayed write errors
+	if dfs.durability != DurabilityFast {
+		if err := dfs.syncFile(file); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to sync file: %w", err)
+		}
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+	
+	// Let the scanner check the content before it replaces anything, a
+	// rejected upload leaves the previous version in place
+	if err := dfs.scanner.Scan(tmpPath); err != nil {
+		return err
+	}
+	
+	if err := os.Rename(tmpPath, fullPath); err != nil {
+		return err
+	}
+	if dfs.durability != DurabilityFast {
+		if err := dfs.syncDir(dir); err != nil {
+			return fmt.Errorf("failed to sync directory: %w", err)
+		}
+	}
+	dfs.io.add(0, 0, 0, 1)
+	
+	dfs.invalidateMerkle(filePath)
 	
 	// Update the file info cache
 	info, _ := os.Stat(fullPath)
 	dfs.fileInfo[filePath] = &FileInfo{
-		Name:      filepath.Base(filePath),
-		Path:      filePath,
-		Size:      info.Size(),
-		IsDir:     false,
-		ModTime:   info.ModTime(),
-		Replicas:  1,
-		Available: true,
+		Name:        filepath.Base(filePath),
+		Path:        filePath,
+		Size:        info.Size(),
+		IsDir:       false,
+		ModTime:     info.ModTime(),
+		Replicas:    replicas,
+		Available:   true,
+		SHA256:      hex.EncodeToString(hash.Sum(nil)),
+		ContentType: DetectContentType(filePath, head.data),
 	}
+	dfs.metadataDirty = true
+	dfs.publishFileEvent(FileEvent{Type: eventType, Path: filePath})
 	
 	return nil
 }
 
+// syncDir fsyncs a directory so newly created entries in it are durable
+func (dfs *DistributedFileSystem) syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	
+	return dfs.syncFile(d)
+}
+
+// Exists reports whether a file or directory exists at a path
+func (dfs *DistributedFileSystem) Exists(path string) bool {
+	fullPath, err := dfs.resolvePath(path)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(fullPath)
+	return err == nil
+}
+
+// resolvePath returns the path on disk of a path relative to the root.
+// The path is cleaned first, paths that end up outside the root directory,
+// e.g. through "..", fail with ErrOutsideRoot, the file system's own files
+// hidden from listings with ErrReservedPath. Absolute paths are taken
+// relative to the root like everywhere else.
+func (dfs *DistributedFileSystem) resolvePath(path string) (string, error) {
+	fullPath := filepath.Join(dfs.rootDir, path)
+	rel, err := filepath.Rel(filepath.Clean(dfs.rootDir), fullPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %s", ErrOutsideRoot, path)
+	}
+	if dfs.isInternalFile(rel) {
+		return "", fmt.Errorf("%w: %s", ErrReservedPath, path)
+	}
+	return fullPath, nil
+}
+
+// isRoot reports whether a path resolves to the root directory itself,
+// like "", "/" or "."
+func (dfs *DistributedFileSystem) isRoot(path string) bool {
+	return filepath.Join(dfs.rootDir, path) == filepath.Clean(dfs.rootDir)
+}
+
+// checkPathComponents returns ErrPathConflict naming the first parent
+// directory of a path that exists as a file. With self set, the path
+// itself must not be a file either.
+func (dfs *DistributedFileSystem) checkPathComponents(path string, self bool) error {
+	components := strings.Split(filepath.ToSlash(filepath.Clean(path)), "/")
+	if !self {
+		components = components[:len(components)-1]
+	}
+
+	current := ""
+	for _, component := range components {
+		current = filepath.Join(current, component)
+		info, err := os.Stat(filepath.Join(dfs.rootDir, current))
+		if err != nil {
+			return nil // Missing components are created as directories
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%w: %s", ErrPathConflict, filepath.ToSlash(current))
+		}
+	}
+
+	return nil
+}
+
 // DownloadFile returns the content of a file
 func (dfs *DistributedFileSystem) DownloadFile(filePath string) (io.ReadCloser, error) {
 	dfs.mu.RLock()
 	defer dfs.mu.RUnlock()
 	
-	fullPath := filepath.Join(dfs.rootDir, filePath)
+	fullPath, err := dfs.resolvePath(filePath)
+	if err != nil {
+		return nil, err
+	}
 	
 	// Check if the file exists
 	info, err := os.Stat(fullPath)
@@ -221,7 +606,7 @@ func (dfs *DistributedFileSystem) DownloadFile(filePath string) (io.ReadCloser,
 	}
 	
 	if info.IsDir() {
-		return nil, errors.New("cannot download a directory")
+		return nil, ErrIsDirectory
 	}
 	
 	// Open the file
@@ -229,8 +614,9 @@ func (dfs *DistributedFileSystem) DownloadFile(filePath string) (io.ReadCloser,
 	if err != nil {
 		return nil, err
 	}
+	dfs.io.add(0, 0, 1, 0)
 	
-	return file, nil
+	return &countingFile{file: file, counter: dfs.io}, nil
 }
 
 // MoveFile moves a file from one location to another
@@ -238,27 +624,48 @@ func (dfs *DistributedFileSystem) MoveFile(sourcePath, destPath string) error {
 	dfs.mu.Lock()
 	defer dfs.mu.Unlock()
 	
-	sourceFullPath := filepath.Join(dfs.rootDir, sourcePath)
-	destFullPath := filepath.Join(dfs.rootDir, destPath)
+	if dfs.isRoot(sourcePath) || dfs.isRoot(destPath) {
+		return ErrRootPath
+	}
+	
+	sourceFullPath, err := dfs.resolvePath(sourcePath)
+	if err != nil {
+		return err
+	}
+	destFullPath, err := dfs.resolvePath(destPath)
+	if err != nil {
+		return err
+	}
 	
 	// Check if the source file exists
-	_, err := os.Stat(sourceFullPath)
+	sourceInfo, err := os.Stat(sourceFullPath)
 	if err != nil {
 		return err
 	}
 	
+	if err := dfs.checkPathComponents(destPath, false); err != nil {
+		return err
+	}
+	
 	// Create parent directories of destination if they don't exist
 	destDir := filepath.Dir(destFullPath)
 	if err := os.MkdirAll(destDir, 0755); err != nil {
 		return err
 	}
 	
-	// Move the file
-	err = os.Rename(sourceFullPath, destFullPath)
+	// Move the file, falling back to a verified copy when the rename
+	// crosses a device boundary
+	err = dfs.rename(sourceFullPath, destFullPath)
+	if errors.Is(err, syscall.EXDEV) && !sourceInfo.IsDir() {
+		err = moveAcrossDevices(sourceFullPath, destFullPath, dfs.copyBufferSize)
+	}
 	if err != nil {
 		return err
 	}
 	
+	dfs.invalidateMerkle(sourcePath)
+	dfs.invalidateMerkle(destPath)
+	
 	// Update the file info cache
 	if fileInfo, exists := dfs.fileInfo[sourcePath]; exists {
 		fileInfo.Path = destPath
@@ -266,42 +673,202 @@ func (dfs *DistributedFileSystem) MoveFile(sourcePath, destPath string) error {
 		dfs.fileInfo[destPath] = fileInfo
 		delete(dfs.fileInfo, sourcePath)
 	}
+	if sourceInfo.IsDir() {
+		dfs.forgetTree(sourcePath) // Paths below the old location are stale
+		dfs.moveReplicationPolicies(sourcePath, destPath)
+	}
+	dfs.metadataDirty = true
+	dfs.publishFileEvent(FileEvent{Type: FileMoved, Path: destPath, OldPath: sourcePath, IsDir: sourceInfo.IsDir()})
 	
 	return nil
 }
 
+// moveAcrossDevices copies a file to its destination, verifies the copy's
+// hash matches the source and only then removes the source
+func moveAcrossDevices(sourcePath, destPath string, bufferSize int) error {
+	src, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	
+	dst, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
+	
+	// Hash the source while copying it
+	hash := sha256.New()
+	if _, err := CopyBuffer(dst, io.TeeReader(src, hash), bufferSize); err != nil {
+		dst.Close()
+		os.Remove(destPath)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(destPath)
+		return err
+	}
+	
+	// Re-read the destination and make sure it matches what we copied
+	destHash, err := hashFile(destPath)
+	if err != nil {
+		os.Remove(destPath)
+		return err
+	}
+	if destHash != hex.EncodeToString(hash.Sum(nil)) {
+		os.Remove(destPath)
+		return errors.New("copied file does not match source, move aborted")
+	}
+	
+	return os.Remove(sourcePath)
+}
+
+// hashFile returns the hex-encoded SHA-256 hash of a file's content
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
 // GetFileInfo returns metadata about a file
 func (dfs *DistributedFileSystem) GetFileInfo(filePath string) (*FileInfo, error) {
-	dfs.mu.RLock()
-	defer dfs.mu.RUnlock()
+	dfs.mu.Lock()
+	defer dfs.mu.Unlock()
 	
-	// Check the cache first
-	if info, exists := dfs.fileInfo[filePath]; exists {
-		return info, nil
+	fullPath, err := dfs.resolvePath(filePath)
+	if err != nil {
+		return nil, err
 	}
 	
-	// If not in cache, get it from the file system
-	fullPath := filepath.Join(dfs.rootDir, filePath)
-	
+	// The file on disk is the source of truth, the cache only adds what
+	// cannot be read from it
 	info, err := os.Stat(fullPath)
 	if err != nil {
+		if _, exists := dfs.fileInfo[filePath]; exists && os.IsNotExist(err) {
+			delete(dfs.fileInfo, filePath)
+			dfs.metadataDirty = true
+		}
 		return nil, err
 	}
 	
+	fileInfo := dfs.refreshFileInfo(filePath, info)
+	
+	// Files that didn't come through an upload get their type detected
+	// the first time it is asked for
+	if !fileInfo.IsDir && fileInfo.ContentType == "" {
+		if contentType, err := sniffFile(fullPath); err == nil {
+			fileInfo.ContentType = contentType
+			dfs.metadataDirty = true
+		}
+	}
+	
+	// Callers get a copy, the cached entry is only changed under dfs.mu
+	return fileInfo.clone(), nil
+}
+
+// clone returns a copy of the metadata that shares nothing with it
+func (fi *FileInfo) clone() *FileInfo {
+	copied := *fi
+	copied.Checksums = maps.Clone(fi.Checksums)
+	return &copied
+}
+
+// refreshFileInfo brings the cached metadata of a path in line with what
+// is on disk. The replication factor is kept, content hashes and the
+// content type only while the file is unchanged. Callers must hold dfs.mu.
+func (dfs *DistributedFileSystem) refreshFileInfo(filePath string, stat os.FileInfo) *FileInfo {
+	if cached, exists := dfs.fileInfo[filePath]; exists {
+		if cached.IsDir != stat.IsDir() || cached.Size != stat.Size() || !cached.ModTime.Equal(stat.ModTime()) {
+			cached.IsDir = stat.IsDir()
+			cached.Size = stat.Size()
+			cached.ModTime = stat.ModTime()
+			cached.SHA256 = ""
+			cached.Checksums = nil
+			cached.ContentType = ""
+			dfs.metadataDirty = true
+		}
+		return cached
+	}
+	
 	fileInfo := &FileInfo{
 		Name:      filepath.Base(filePath),
 		Path:      filePath,
-		Size:      info.Size(),
-		IsDir:     info.IsDir(),
-		ModTime:   info.ModTime(),
-		Replicas:  1,
+		Size:      stat.Size(),
+		IsDir:     stat.IsDir(),
+		ModTime:   stat.ModTime(),
+		Replicas:  dfs.inheritedReplicas(filePath),
 		Available: true,
 	}
-	
-	// Update the cache
 	dfs.fileInfo[filePath] = fileInfo
 	
-	return fileInfo, nil
+	return fileInfo
+}
+
+// WalkFiles calls fn with the metadata of every file (not directory) in
+// the file system
+func (dfs *DistributedFileSystem) WalkFiles(fn func(info FileInfo) error) error {
+	return dfs.WalkDirectory("", fn)
+}
+
+// WalkDirectory calls fn with the metadata of every file (not directory)
+// below a directory
+func (dfs *DistributedFileSystem) WalkDirectory(dirPath string, fn func(info FileInfo) error) error {
+	rootPath, err := dfs.resolvePath(dirPath)
+	if err != nil {
+		return err
+	}
+	
+	return filepath.WalkDir(rootPath, func(fullPath string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		
+		relativePath, err := filepath.Rel(dfs.rootDir, fullPath)
+		if err != nil {
+			return err
+		}
+		if dfs.isInternalFile(relativePath) {
+			return nil
+		}
+		
+		info, err := dfs.GetFileInfo(relativePath)
+		if err != nil {
+			return err
+		}
+		
+		return fn(*info)
+	})
+}
+
+// VerifyFile checks a file's content against the hash recorded at upload
+// time. Files without a recorded hash are reported as valid.
+func (dfs *DistributedFileSystem) VerifyFile(filePath string) (bool, error) {
+	info, err := dfs.GetFileInfo(filePath)
+	if err != nil {
+		return false, err
+	}
+	
+	if info.SHA256 == "" {
+		return true, nil
+	}
+	
+	hash, err := hashFile(filepath.Join(dfs.rootDir, filePath))
+	if err != nil {
+		return false, err
+	}
+	
+	return hash == info.SHA256, nil
 }
 
 // SetReplicationFactor sets the number of replicas for a file
@@ -313,14 +880,26 @@ func (dfs *DistributedFileSystem) SetReplicationFactor(filePath string, replicas
 		return errors.New("replication factor must be at least 1")
 	}
 	
-	fullPath := filepath.Join(dfs.rootDir, filePath)
+	fullPath, err := dfs.resolvePath(filePath)
+	if err != nil {
+		return err
+	}
 	
 	// Check if the file exists
-	_, err := os.Stat(fullPath)
+	stat, err := os.Stat(fullPath)
 	if err != nil {
 		return err
 	}
 	
+	if !stat.IsDir() {
+		if err := dfs.checkReplicaSize(stat.Size(), replicas); err != nil {
+			return err
+		}
+	} else {
+		// Files created below the directory inherit its replication factor
+		dfs.dirReplicas[policyKey(filePath)] = replicas
+	}
+	
 	// Update the replication factor in the cache
 	if info, exists := dfs.fileInfo[filePath]; exists {
 		info.Replicas = replicas
@@ -340,9 +919,10 @@ func (dfs *DistributedFileSystem) SetReplicationFactor(filePath string, replicas
 			Available: true,
 		}
 	}
+	dfs.metadataDirty = true
 	
 	// In a real distributed system, we would initiate replication here
-	fmt.Printf("Setting replication factor to %d for %s\n", replicas, filePath)
+	dfs.log().Info("setting replication factor", "path", filePath, "replicas", replicas)
 	
 	return nil
 }
@@ -0,0 +1,165 @@
+package fs
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ioWindowSeconds is how far back the I/O rates look
+const ioWindowSeconds = 60
+
+// IOStats describes the file content read and written through uploads and
+// downloads. Rates are per second averages over the last minute, or over
+// the time since the file system was created if that is shorter.
+type IOStats struct {
+	BytesRead        int64   `json:"bytesRead"`
+	BytesWritten     int64   `json:"bytesWritten"`
+	Reads            int64   `json:"reads"`  // Downloads opened
+	Writes           int64   `json:"writes"` // Uploads written
+	WindowSeconds    float64 `json:"windowSeconds"`
+	ReadBytesPerSec  float64 `json:"readBytesPerSec"`
+	WriteBytesPerSec float64 `json:"writeBytesPerSec"`
+	ReadOpsPerSec    float64 `json:"readOpsPerSec"`
+	WriteOpsPerSec   float64 `json:"writeOpsPerSec"`
+}
+
+// ioBucket holds the I/O of one second
+type ioBucket struct {
+	second       int64 // Unix time of the second the bucket holds
+	bytesRead    int64
+	bytesWritten int64
+	reads        int64
+	writes       int64
+}
+
+// ioCounter counts I/O in total and per second over a sliding window
+type ioCounter struct {
+	started time.Time
+	total   ioBucket
+	buckets [ioWindowSeconds]ioBucket
+	now     func() time.Time
+	mu      sync.Mutex
+}
+
+// newIOCounter creates an I/O counter starting now
+func newIOCounter() *ioCounter {
+	return &ioCounter{started: time.Now(), now: time.Now}
+}
+
+// add records I/O in the total and in the bucket of the current second
+func (c *ioCounter) add(bytesRead, bytesWritten, reads, writes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	second := c.now().Unix()
+	bucket := &c.buckets[second%ioWindowSeconds]
+	if bucket.second != second {
+		*bucket = ioBucket{second: second}
+	}
+
+	for _, b := range []*ioBucket{&c.total, bucket} {
+		b.bytesRead += bytesRead
+		b.bytesWritten += bytesWritten
+		b.reads += reads
+		b.writes += writes
+	}
+}
+
+// stats returns the totals and the rates over the window
+func (c *ioCounter) stats() IOStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	var window ioBucket
+	for _, bucket := range c.buckets {
+		if now.Unix()-bucket.second < ioWindowSeconds {
+			window.bytesRead += bucket.bytesRead
+			window.bytesWritten += bucket.bytesWritten
+			window.reads += bucket.reads
+			window.writes += bucket.writes
+		}
+	}
+
+	// Young file systems average over their lifetime, at least a second
+	seconds := now.Sub(c.started).Seconds()
+	if seconds > ioWindowSeconds {
+		seconds = ioWindowSeconds
+	}
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	return IOStats{
+		BytesRead:        c.total.bytesRead,
+		BytesWritten:     c.total.bytesWritten,
+		Reads:            c.total.reads,
+		Writes:           c.total.writes,
+		WindowSeconds:    seconds,
+		ReadBytesPerSec:  float64(window.bytesRead) / seconds,
+		WriteBytesPerSec: float64(window.bytesWritten) / seconds,
+		ReadOpsPerSec:    float64(window.reads) / seconds,
+		WriteOpsPerSec:   float64(window.writes) / seconds,
+	}
+}
+
+// StatReadSeeker is implemented by download readers that can seek and
+// stat the file they read, which allows serving range requests
+type StatReadSeeker interface {
+	io.ReadSeeker
+	Stat() (os.FileInfo, error)
+}
+
+// countingFile is a downloaded file counting the bytes read from it. It
+// hides the file's WriteTo and ReadFrom so no copy bypasses the count.
+type countingFile struct {
+	file    *os.File
+	counter *ioCounter
+}
+
+// Read implements io.Reader
+func (f *countingFile) Read(p []byte) (int, error) {
+	n, err := f.file.Read(p)
+	if n > 0 {
+		f.counter.add(int64(n), 0, 0, 0)
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker
+func (f *countingFile) Seek(offset int64, whence int) (int64, error) {
+	return f.file.Seek(offset, whence)
+}
+
+// Stat returns the file's info
+func (f *countingFile) Stat() (os.FileInfo, error) {
+	return f.file.Stat()
+}
+
+// Close implements io.Closer
+func (f *countingFile) Close() error {
+	return f.file.Close()
+}
+
+// countingWriter counts the bytes written by an upload
+type countingWriter struct {
+	w       io.Writer
+	counter *ioCounter
+}
+
+// Write implements io.Writer
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if n > 0 {
+		w.counter.add(0, int64(n), 0, 0)
+	}
+	return n, err
+}
+
+// IOStats returns the bytes and operations of uploads and downloads, in
+// total and as rates over the last minute
+func (dfs *DistributedFileSystem) IOStats() IOStats {
+	return dfs.io.stats()
+}
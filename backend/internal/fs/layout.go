@@ -0,0 +1,156 @@
+package fs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ChunkLayout describes how per-file chunk directories are arranged
+type ChunkLayout string
+
+const (
+	// LayoutFlat stores chunks under chunksDir/<fileID>
+	LayoutFlat ChunkLayout = "flat"
+	// LayoutSharded stores chunks under chunksDir/<fileID[:2]>/<fileID>
+	LayoutSharded ChunkLayout = "sharded"
+)
+
+// layoutFileName is the file under the chunks directory recording its layout
+const layoutFileName = ".layout"
+
+// shardPrefixLen is the number of file ID characters used as shard name
+const shardPrefixLen = 2
+
+// detectLayout reads the layout recorded in the chunks directory. Empty
+// directories are initialized with the sharded layout, while directories
+// holding chunks from before layouts were recorded are treated as flat.
+func detectLayout(chunksDir string) (ChunkLayout, error) {
+	data, err := os.ReadFile(filepath.Join(chunksDir, layoutFileName))
+	if err == nil {
+		layout := ChunkLayout(strings.TrimSpace(string(data)))
+		if layout != LayoutFlat && layout != LayoutSharded {
+			return "", fmt.Errorf("unknown chunk layout: %s", layout)
+		}
+		return layout, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read chunk layout: %w", err)
+	}
+
+	entries, err := os.ReadDir(chunksDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read chunks directory: %w", err)
+	}
+
+	layout := LayoutSharded
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			layout = LayoutFlat
+			break
+		}
+	}
+
+	if err := writeLayout(chunksDir, layout); err != nil {
+		return "", err
+	}
+	return layout, nil
+}
+
+// writeLayout records the layout of a chunks directory
+func writeLayout(chunksDir string, layout ChunkLayout) error {
+	if err := os.WriteFile(filepath.Join(chunksDir, layoutFileName), []byte(layout), 0644); err != nil {
+		return fmt.Errorf("failed to write chunk layout: %w", err)
+	}
+	return nil
+}
+
+// Layout returns the layout used by the chunker
+func (fc *FileChunker) Layout() ChunkLayout {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+
+	return fc.layout
+}
+
+// fileDir returns the directory holding the chunks of a file
+func (fc *FileChunker) fileDir(fileID string) string {
+	fc.mu.RLock()
+	layout := fc.layout
+	fc.mu.RUnlock()
+
+	if layout == LayoutSharded && len(fileID) > shardPrefixLen {
+		return filepath.Join(fc.chunksDir, fileID[:shardPrefixLen], fileID)
+	}
+	return filepath.Join(fc.chunksDir, fileID)
+}
+
+// listFileIDs returns the IDs of all files with stored chunks
+func (fc *FileChunker) listFileIDs() ([]string, error) {
+	entries, err := os.ReadDir(fc.chunksDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunks directory: %w", err)
+	}
+
+	var fileIDs []string
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		if fc.Layout() == LayoutFlat {
+			fileIDs = append(fileIDs, entry.Name())
+			continue
+		}
+
+		shardEntries, err := os.ReadDir(filepath.Join(fc.chunksDir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read shard %s: %w", entry.Name(), err)
+		}
+		for _, shardEntry := range shardEntries {
+			if shardEntry.IsDir() {
+				fileIDs = append(fileIDs, shardEntry.Name())
+			}
+		}
+	}
+
+	return fileIDs, nil
+}
+
+// MigrateToSharded moves a flat chunk store into the sharded layout
+func (fc *FileChunker) MigrateToSharded() error {
+	if fc.Layout() == LayoutSharded {
+		return nil
+	}
+
+	fileIDs, err := fc.listFileIDs()
+	if err != nil {
+		return err
+	}
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	for _, fileID := range fileIDs {
+		if len(fileID) <= shardPrefixLen {
+			continue
+		}
+
+		shardDir := filepath.Join(fc.chunksDir, fileID[:shardPrefixLen])
+		if err := os.MkdirAll(shardDir, 0755); err != nil {
+			return fmt.Errorf("failed to create shard directory: %w", err)
+		}
+
+		if err := os.Rename(filepath.Join(fc.chunksDir, fileID), filepath.Join(shardDir, fileID)); err != nil {
+			return fmt.Errorf("failed to move chunks of %s: %w", fileID, err)
+		}
+	}
+
+	if err := writeLayout(fc.chunksDir, LayoutSharded); err != nil {
+		return err
+	}
+	fc.layout = LayoutSharded
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// MaxListDepth is the deepest a recursive listing descends, whatever depth
+// was asked for
+const MaxListDepth = 64
+
+// ErrTreeTooDeep is returned by recursive listings of trees nested deeper
+// than MaxListDepth
+var ErrTreeTooDeep = errors.New("directory tree is nested too deeply")
+
+// Sort keys for ListFilesPaged
+const (
+	ListSortName    = "name"
+	ListSortSize    = "size"
+	ListSortModTime = "modTime"
+)
+
+// ListOptions selects one page of a sorted directory listing
+type ListOptions struct {
+	Sort       string // ListSortName, ListSortSize or ListSortModTime, empty sorts by name
+	Descending bool
+	Offset     int // Number of entries to skip
+	Limit      int // Maximum number of entries, 0 for no limit
+	Recursive  bool
+	Depth      int // Levels a recursive listing descends, 0 for no limit
+}
+
+// ListFilesPaged returns one page of a directory listing together with the
+// number of entries in the whole directory, or the whole subtree for
+// recursive listings. Ties are broken by name so
+// pages are stable. An offset past the end yields an empty page.
+func (dfs *DistributedFileSystem) ListFilesPaged(dirPath string, opts ListOptions) ([]FileInfo, int, error) {
+	if opts.Offset < 0 || opts.Limit < 0 {
+		return nil, 0, fmt.Errorf("offset and limit must not be negative")
+	}
+
+	var compare func(a, b *FileInfo) int
+	switch opts.Sort {
+	case "", ListSortName:
+		compare = func(a, b *FileInfo) int { return 0 }
+	case ListSortSize:
+		compare = func(a, b *FileInfo) int { return compareInt64(a.Size, b.Size) }
+	case ListSortModTime:
+		compare = func(a, b *FileInfo) int { return a.ModTime.Compare(b.ModTime) }
+	default:
+		return nil, 0, fmt.Errorf("unknown sort key %q", opts.Sort)
+	}
+
+	var files []FileInfo
+	var err error
+	if opts.Recursive {
+		files, err = dfs.ListFilesRecursive(dirPath, opts.Depth)
+	} else {
+		files, err = dfs.ListFiles(dirPath)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		cmp := compare(&files[i], &files[j])
+		if cmp == 0 {
+			cmp = strings.Compare(files[i].Name, files[j].Name)
+		}
+		if cmp == 0 {
+			cmp = strings.Compare(files[i].Path, files[j].Path)
+		}
+		if opts.Descending {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+
+	total := len(files)
+	start := min(opts.Offset, total)
+	end := total
+	if opts.Limit > 0 && start+opts.Limit < end {
+		end = start + opts.Limit
+	}
+
+	return append([]FileInfo{}, files[start:end]...), total, nil
+}
+
+// ListFilesRecursive lists a directory and everything below it, up to
+// maxDepth levels deep (1 lists the directory itself, 0 for no limit).
+// Entries carry their path relative to the root and come in path order,
+// each directory before its contents. Symbolic links are listed but never
+// followed, so links can't lead the walk in circles. Trees nested deeper
+// than MaxListDepth fail with ErrTreeTooDeep unless maxDepth stops the
+// walk before.
+func (dfs *DistributedFileSystem) ListFilesRecursive(dirPath string, maxDepth int) ([]FileInfo, error) {
+	if maxDepth < 0 {
+		return nil, fmt.Errorf("depth must not be negative")
+	}
+
+	// Listed entries are cached by refreshFileInfo, which needs the write
+	// lock
+	dfs.mu.Lock()
+	defer dfs.mu.Unlock()
+
+	fullPath, err := dfs.resolvePath(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, ErrNotDirectory
+	}
+
+	var files []FileInfo
+	if err := dfs.listTree(dirPath, 1, maxDepth, &files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// listTree appends the entries of a directory at the given depth, and
+// those of its subdirectories, to files. Callers must hold dfs.mu.
+func (dfs *DistributedFileSystem) listTree(dirPath string, depth, maxDepth int, files *[]FileInfo) error {
+	if depth > MaxListDepth {
+		return fmt.Errorf("%w: more than %d levels below the listed directory", ErrTreeTooDeep, MaxListDepth)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dfs.rootDir, dirPath))
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue // Removed while listing
+		}
+
+		relativePath := filepath.Join(dirPath, entry.Name())
+		if dfs.isInternalFile(relativePath) {
+			continue
+		}
+		*files = append(*files, *dfs.refreshFileInfo(relativePath, info).clone())
+
+		// Info doesn't follow symbolic links, so links to directories
+		// aren't descended into
+		if info.IsDir() && (maxDepth == 0 || depth < maxDepth) {
+			if err := dfs.listTree(relativePath, depth+1, maxDepth, files); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// compareInt64 compares two integers like strings.Compare
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
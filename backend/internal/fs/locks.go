@@ -0,0 +1,127 @@
+package fs
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Errors returned by the lock manager
+var (
+	ErrLocked      = errors.New("file is locked by another holder")
+	ErrNotLocked   = errors.New("file is not locked")
+	ErrLockHolder  = errors.New("lock is held by another holder")
+	ErrInvalidTTL  = errors.New("lock TTL must be positive")
+	ErrEmptyHolder = errors.New("lock holder token is required")
+)
+
+// FileLock represents an advisory lock on a file
+type FileLock struct {
+	Path    string    `json:"path"`
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// LockManager keeps track of advisory file locks. Locks are keyed by the
+// path relative to the root, so "a.txt", "/a.txt" and "./a.txt" share one.
+type LockManager struct {
+	locks map[string]*FileLock
+	mu    sync.Mutex
+}
+
+// NewLockManager creates a new lock manager
+func NewLockManager() *LockManager {
+	return &LockManager{
+		locks: make(map[string]*FileLock),
+		mu:    sync.Mutex{},
+	}
+}
+
+// Acquire takes or renews a lock on a path for the given holder
+func (lm *LockManager) Acquire(path, holder string, ttl time.Duration) (*FileLock, error) {
+	if holder == "" {
+		return nil, ErrEmptyHolder
+	}
+	if ttl <= 0 {
+		return nil, ErrInvalidTTL
+	}
+	key := policyKey(path)
+	if key == "" {
+		return nil, ErrRootPath
+	}
+
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	if lock, exists := lm.locks[key]; exists && lock.Holder != holder && time.Now().Before(lock.Expires) {
+		return nil, ErrLocked
+	}
+
+	lock := &FileLock{
+		Path:    key,
+		Holder:  holder,
+		Expires: time.Now().Add(ttl),
+	}
+	lm.locks[key] = lock
+
+	return lock, nil
+}
+
+// Release releases a lock held by the given holder
+func (lm *LockManager) Release(path, holder string) error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	key := policyKey(path)
+	lock, exists := lm.locks[key]
+	if !exists || time.Now().After(lock.Expires) {
+		delete(lm.locks, key)
+		return ErrNotLocked
+	}
+
+	if lock.Holder != holder {
+		return ErrLockHolder
+	}
+
+	delete(lm.locks, key)
+
+	return nil
+}
+
+// Check returns ErrLocked if a path is locked by someone other than holder
+func (lm *LockManager) Check(path, holder string) error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	lock, exists := lm.locks[policyKey(path)]
+	if exists && lock.Holder != holder && time.Now().Before(lock.Expires) {
+		return ErrLocked
+	}
+
+	return nil
+}
+
+// Sweep removes all expired locks
+func (lm *LockManager) Sweep() {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	now := time.Now()
+	for key, lock := range lm.locks {
+		if now.After(lock.Expires) {
+			delete(lm.locks, key)
+		}
+	}
+}
+
+// StartSweeper periodically removes expired locks in the background
+func (lm *LockManager) StartSweeper(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			lm.Sweep()
+		}
+	}()
+}
@@ -0,0 +1,18 @@
+package fs
+
+import (
+	"log/slog"
+)
+
+// SetLogger sets the logger of the file system, nil logs to slog.Default()
+func (dfs *DistributedFileSystem) SetLogger(logger *slog.Logger) {
+	dfs.logger.Store(logger)
+}
+
+// log returns the file system's logger
+func (dfs *DistributedFileSystem) log() *slog.Logger {
+	if logger := dfs.logger.Load(); logger != nil {
+		return logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,170 @@
+package fs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// MerkleNode is a node of a directory Merkle tree. A file's hash is the
+// SHA-256 of its content; a directory's hash covers the names, types and
+// hashes of its entries, so two subtrees share a hash only if they hold
+// the same files under the same names.
+type MerkleNode struct {
+	Name     string        `json:"name"`
+	Path     string        `json:"path"`
+	Hash     string        `json:"hash"`
+	IsDir    bool          `json:"isDir"`
+	Children []*MerkleNode `json:"children,omitempty"`
+}
+
+// MerkleTree computes the Merkle tree of a subtree. Only depth levels below
+// dirPath are returned, hashes always cover the whole subtree. Subtree
+// hashes are cached until a file system operation changes the subtree;
+// changes made to the data directory behind the file system's back are
+// not noticed.
+func (dfs *DistributedFileSystem) MerkleTree(dirPath string, depth int) (*MerkleNode, error) {
+	if _, err := dfs.resolvePath(dirPath); err != nil {
+		return nil, err
+	}
+	key := merkleKey(dirPath)
+
+	dfs.merkleMu.Lock()
+	gen := dfs.merkleGen
+	dfs.merkleMu.Unlock()
+
+	return dfs.merkleNode(key, depth, gen)
+}
+
+// merkleNode builds the node for a path, expanding children up to depth
+func (dfs *DistributedFileSystem) merkleNode(key string, depth int, gen uint64) (*MerkleNode, error) {
+	info, err := os.Stat(filepath.Join(dfs.rootDir, key))
+	if err != nil {
+		return nil, err
+	}
+
+	node := &MerkleNode{
+		Name:  filepath.Base(key),
+		Path:  key,
+		IsDir: info.IsDir(),
+	}
+
+	if cached, ok := dfs.cachedMerkleHash(key); ok && (depth <= 0 || !info.IsDir()) {
+		node.Hash = cached
+		return node, nil
+	}
+
+	if !info.IsDir() {
+		hash, err := dfs.fileContentHash(key)
+		if err != nil {
+			return nil, err
+		}
+		node.Hash = hash
+		dfs.cacheMerkleHash(key, hash, gen)
+		return node, nil
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dfs.rootDir, key))
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	dirHash := sha256.New()
+	for _, entry := range entries {
+		if dfs.isInternalFile(filepath.Join(key, entry.Name())) {
+			continue
+		}
+		child, err := dfs.merkleNode(filepath.Join(key, entry.Name()), depth-1, gen)
+		if err != nil {
+			return nil, err
+		}
+
+		kind := "f"
+		if child.IsDir {
+			kind = "d"
+		}
+		dirHash.Write([]byte(kind + "\x00" + entry.Name() + "\x00" + child.Hash + "\n"))
+
+		if depth > 0 {
+			node.Children = append(node.Children, child)
+		}
+	}
+
+	node.Hash = hex.EncodeToString(dirHash.Sum(nil))
+	dfs.cacheMerkleHash(key, node.Hash, gen)
+
+	return node, nil
+}
+
+// fileContentHash returns the content hash of a file, using the hash
+// recorded at upload time when there is one
+func (dfs *DistributedFileSystem) fileContentHash(key string) (string, error) {
+	dfs.mu.RLock()
+	var recorded string
+	if info, exists := dfs.fileInfo[key]; exists {
+		recorded = info.SHA256
+	}
+	dfs.mu.RUnlock()
+
+	if recorded != "" {
+		return recorded, nil
+	}
+
+	return hashFile(filepath.Join(dfs.rootDir, key))
+}
+
+// cachedMerkleHash returns the cached hash of a path
+func (dfs *DistributedFileSystem) cachedMerkleHash(key string) (string, bool) {
+	dfs.merkleMu.Lock()
+	defer dfs.merkleMu.Unlock()
+
+	hash, ok := dfs.merkleHashes[key]
+	return hash, ok
+}
+
+// cacheMerkleHash caches the hash of a path unless the tree changed since
+// the computation started at generation gen
+func (dfs *DistributedFileSystem) cacheMerkleHash(key, hash string, gen uint64) {
+	dfs.merkleMu.Lock()
+	defer dfs.merkleMu.Unlock()
+
+	if dfs.merkleGen == gen {
+		dfs.merkleHashes[key] = hash
+	}
+}
+
+// invalidateMerkle drops the cached hashes of a path, everything below it
+// and all of its ancestors
+func (dfs *DistributedFileSystem) invalidateMerkle(path string) {
+	key := merkleKey(path)
+
+	dfs.merkleMu.Lock()
+	defer dfs.merkleMu.Unlock()
+
+	dfs.merkleGen++
+
+	for cached := range dfs.merkleHashes {
+		if key == "." || strings.HasPrefix(cached, key+"/") {
+			delete(dfs.merkleHashes, cached)
+		}
+	}
+
+	for {
+		delete(dfs.merkleHashes, key)
+		if key == "." {
+			break
+		}
+		key = filepath.Dir(key)
+	}
+}
+
+// merkleKey normalizes a path relative to the root directory
+func merkleKey(path string) string {
+	return filepath.Clean(strings.TrimPrefix(filepath.ToSlash(path), "/"))
+}
@@ -0,0 +1,236 @@
+package fs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MetadataFile is the index under the root directory the file metadata is
+// persisted to. It is hidden from listings.
+const MetadataFile = ".distfs-metadata.json"
+
+// fileMetadata is the persisted part of a file's metadata. Size and ModTime
+// tell whether the content hash still matches the file on disk.
+type fileMetadata struct {
+	Replicas    int               `json:"replicas"`
+	SHA256      string            `json:"sha256,omitempty"`
+	Checksums   map[string]string `json:"checksums,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Size        int64             `json:"size"`
+	ModTime     time.Time         `json:"modTime"`
+}
+
+// metadataIndex is the on-disk format of the metadata index
+type metadataIndex struct {
+	Files       map[string]fileMetadata `json:"files"`
+	DirReplicas map[string]int          `json:"dirReplicas,omitempty"`
+}
+
+// LoadMetadata loads the persisted file metadata and replication policies
+// and keeps persisting them from now on. Entries of files that are gone
+// are dropped, content hashes of files changed behind the file system's
+// back are discarded.
+func (dfs *DistributedFileSystem) LoadMetadata() error {
+	dfs.mu.Lock()
+	defer dfs.mu.Unlock()
+
+	dfs.persistMetadata = true
+
+	data, err := os.ReadFile(dfs.metadataPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read file metadata: %w", err)
+	}
+
+	var index metadataIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return fmt.Errorf("failed to parse file metadata: %w", err)
+	}
+
+	for path, meta := range index.Files {
+		stat, err := os.Stat(filepath.Join(dfs.rootDir, path))
+		if err != nil {
+			dfs.metadataDirty = true
+			continue
+		}
+
+		info := &FileInfo{
+			Name:      filepath.Base(path),
+			Path:      path,
+			Size:      stat.Size(),
+			IsDir:     stat.IsDir(),
+			ModTime:   stat.ModTime(),
+			Replicas:  meta.Replicas,
+			Available: true,
+		}
+		if stat.Size() == meta.Size && stat.ModTime().Equal(meta.ModTime) {
+			info.SHA256 = meta.SHA256
+			info.Checksums = meta.Checksums
+			info.ContentType = meta.ContentType
+		} else {
+			dfs.metadataDirty = true
+		}
+		if info.Replicas < 1 {
+			info.Replicas = 1
+		}
+		dfs.fileInfo[path] = info
+	}
+	for dir, replicas := range index.DirReplicas {
+		dfs.dirReplicas[dir] = replicas
+	}
+
+	return nil
+}
+
+// SaveMetadata writes the file metadata to the index if it changed since
+// the last save. The index is replaced atomically.
+func (dfs *DistributedFileSystem) SaveMetadata() error {
+	dfs.saveMu.Lock() // Keeps an older snapshot from overwriting a newer one
+	defer dfs.saveMu.Unlock()
+
+	dfs.mu.Lock()
+	if !dfs.persistMetadata || !dfs.metadataDirty {
+		dfs.mu.Unlock()
+		return nil
+	}
+
+	index := metadataIndex{
+		Files:       make(map[string]fileMetadata, len(dfs.fileInfo)),
+		DirReplicas: make(map[string]int, len(dfs.dirReplicas)),
+	}
+	for path, info := range dfs.fileInfo {
+		index.Files[path] = fileMetadata{
+			Replicas:    info.Replicas,
+			SHA256:      info.SHA256,
+			Checksums:   info.Checksums,
+			ContentType: info.ContentType,
+			Size:        info.Size,
+			ModTime:     info.ModTime,
+		}
+	}
+	for dir, replicas := range dfs.dirReplicas {
+		index.DirReplicas[dir] = replicas
+	}
+	dfs.metadataDirty = false
+	path := dfs.metadataPath()
+	dfs.mu.Unlock()
+
+	if err := writeJSONAtomic(path, index); err != nil {
+		dfs.mu.Lock()
+		dfs.metadataDirty = true // Try again on the next save
+		dfs.mu.Unlock()
+		return fmt.Errorf("failed to save file metadata: %w", err)
+	}
+
+	return nil
+}
+
+// StartMetadataFlush saves the file metadata every interval while it has
+// unsaved changes, until the file system is closed
+func (dfs *DistributedFileSystem) StartMetadataFlush(interval time.Duration) {
+	dfs.mu.Lock()
+	defer dfs.mu.Unlock()
+
+	if interval <= 0 || dfs.stopFlush != nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	dfs.stopFlush = stop
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := dfs.SaveMetadata(); err != nil {
+					dfs.log().Error("error saving file metadata", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// metadataPath returns the path of the metadata index
+func (dfs *DistributedFileSystem) metadataPath() string {
+	return filepath.Join(dfs.rootDir, MetadataFile)
+}
+
+// isMetadataFile reports whether a path relative to the root is the
+// metadata index or one of its temporary files
+func isMetadataFile(path string) bool {
+	key := policyKey(path)
+	return key == MetadataFile || strings.HasPrefix(key, MetadataFile+".tmp-")
+}
+
+// uploadTempPrefix starts the names of the temporary files uploads are
+// written to before they are renamed into place
+const uploadTempPrefix = ".distfs-upload-"
+
+// isInternalFile reports whether a path relative to the root is one of the
+// file system's own files, which are hidden from listings: the metadata
+// index, a temporary file, e.g. one left behind by a crash mid-upload, or
+// anything below a path hidden with SetHiddenPaths
+func (dfs *DistributedFileSystem) isInternalFile(path string) bool {
+	return isMetadataFile(path) || strings.HasPrefix(filepath.Base(path), uploadTempPrefix) ||
+		isIgnoredPath(filepath.FromSlash(policyKey(path)), dfs.hidden)
+}
+
+// createUploadTemp creates a temporary file in dir for content that is
+// renamed into place once complete. It gets the permissions of a regular
+// file instead of CreateTemp's owner-only ones.
+func createUploadTemp(dir string) (*os.File, error) {
+	file, err := os.CreateTemp(dir, uploadTempPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Chmod(0644); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return file, nil
+}
+
+// writeJSONAtomic writes v as JSON to path with writeFileAtomic
+func writeJSONAtomic(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it over path, so readers never see a partial file
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,158 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrReplicaSizeExceeded is returned when a file is too large for its
+// replication factor under the replica size policy
+var ErrReplicaSizeExceeded = errors.New("file size exceeds the limit for its replication factor")
+
+// ReplicaSizeTier limits the size of files whose replication factor is
+// between MinReplicas and MaxReplicas (inclusive, 0 means unbounded)
+type ReplicaSizeTier struct {
+	MinReplicas int   `json:"minReplicas"`
+	MaxReplicas int   `json:"maxReplicas"`
+	MaxFileSize int64 `json:"maxFileSize"`
+}
+
+// matches reports whether the tier covers a replication factor
+func (t ReplicaSizeTier) matches(replicas int) bool {
+	return replicas >= t.MinReplicas && (t.MaxReplicas == 0 || replicas <= t.MaxReplicas)
+}
+
+// SetReplicaSizePolicy replaces the replica size policy. Tiers may
+// overlap, the smallest limit of all matching tiers applies.
+func (dfs *DistributedFileSystem) SetReplicaSizePolicy(tiers []ReplicaSizeTier) error {
+	for i, tier := range tiers {
+		if tier.MinReplicas < 1 {
+			return fmt.Errorf("tier %d: minReplicas must be at least 1", i)
+		}
+		if tier.MaxReplicas != 0 && tier.MaxReplicas < tier.MinReplicas {
+			return fmt.Errorf("tier %d: maxReplicas must not be below minReplicas", i)
+		}
+		if tier.MaxFileSize <= 0 {
+			return fmt.Errorf("tier %d: maxFileSize must be positive", i)
+		}
+	}
+
+	dfs.mu.Lock()
+	defer dfs.mu.Unlock()
+
+	dfs.sizePolicy = append([]ReplicaSizeTier(nil), tiers...)
+
+	return nil
+}
+
+// ReplicaSizePolicy returns the replica size policy
+func (dfs *DistributedFileSystem) ReplicaSizePolicy() []ReplicaSizeTier {
+	dfs.mu.RLock()
+	defer dfs.mu.RUnlock()
+
+	return append([]ReplicaSizeTier{}, dfs.sizePolicy...)
+}
+
+// maxFileSize returns the largest file size allowed with a replication
+// factor, or 0 if it is unlimited. Callers must hold dfs.mu.
+func (dfs *DistributedFileSystem) maxFileSize(replicas int) int64 {
+	var limit int64
+	for _, tier := range dfs.sizePolicy {
+		if tier.matches(replicas) && (limit == 0 || tier.MaxFileSize < limit) {
+			limit = tier.MaxFileSize
+		}
+	}
+	return limit
+}
+
+// checkReplicaSize returns ErrReplicaSizeExceeded if a file of the given
+// size may not have the given replication factor. Callers must hold dfs.mu.
+func (dfs *DistributedFileSystem) checkReplicaSize(size int64, replicas int) error {
+	if limit := dfs.maxFileSize(replicas); limit > 0 && size > limit {
+		return fmt.Errorf("%w: %d bytes with %d replicas, limit is %d bytes", ErrReplicaSizeExceeded, size, replicas, limit)
+	}
+	return nil
+}
+
+// DirectoryReplication is the replication factor inherited by files
+// created below a directory
+type DirectoryReplication struct {
+	Path     string `json:"path"`
+	Replicas int    `json:"replicas"`
+}
+
+// ReplicationPolicies returns the directory replication policies, sorted
+// by path
+func (dfs *DistributedFileSystem) ReplicationPolicies() []DirectoryReplication {
+	dfs.mu.RLock()
+	defer dfs.mu.RUnlock()
+
+	policies := make([]DirectoryReplication, 0, len(dfs.dirReplicas))
+	for dir, replicas := range dfs.dirReplicas {
+		policies = append(policies, DirectoryReplication{Path: dir, Replicas: replicas})
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].Path < policies[j].Path })
+	return policies
+}
+
+// RemoveReplicationPolicy removes the replication policy of a directory.
+// Files already created below it keep their replication factor.
+func (dfs *DistributedFileSystem) RemoveReplicationPolicy(dirPath string) error {
+	dfs.mu.Lock()
+	defer dfs.mu.Unlock()
+
+	dir := policyKey(dirPath)
+	if _, exists := dfs.dirReplicas[dir]; !exists {
+		return fmt.Errorf("no replication policy for %q: %w", dirPath, os.ErrNotExist)
+	}
+	delete(dfs.dirReplicas, dir)
+	dfs.metadataDirty = true
+
+	return nil
+}
+
+// inheritedReplicas returns the replication factor a new file gets from
+// the closest directory above it with a policy, or 1 if there is none.
+// Callers must hold dfs.mu.
+func (dfs *DistributedFileSystem) inheritedReplicas(filePath string) int {
+	dir := policyKey(filepath.Dir(filePath))
+	for {
+		if replicas, exists := dfs.dirReplicas[dir]; exists {
+			return replicas
+		}
+		if dir == "" {
+			return 1
+		}
+		dir = policyKey(filepath.Dir(dir))
+	}
+}
+
+// moveReplicationPolicies moves the policies of a moved directory and
+// the directories below it along. Callers must hold dfs.mu.
+func (dfs *DistributedFileSystem) moveReplicationPolicies(sourcePath, destPath string) {
+	source, dest := policyKey(sourcePath), policyKey(destPath)
+	moved := make(map[string]int)
+	for dir, replicas := range dfs.dirReplicas {
+		if dir == source || strings.HasPrefix(dir, source+"/") {
+			moved[dest+strings.TrimPrefix(dir, source)] = replicas
+			delete(dfs.dirReplicas, dir)
+		}
+	}
+	for dir, replicas := range moved {
+		dfs.dirReplicas[dir] = replicas
+	}
+}
+
+// policyKey normalizes a directory path for the policy map, the root
+// directory is ""
+func policyKey(dirPath string) string {
+	dir := strings.Trim(filepath.ToSlash(filepath.Clean(dirPath)), "/")
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
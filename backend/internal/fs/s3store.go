@@ -0,0 +1,286 @@
+package fs
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Options configures an S3-compatible chunk store
+type S3Options struct {
+	Endpoint  string // Base URL of the service, e.g. https://s3.us-east-1.amazonaws.com
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Prefix    string // Key prefix chunks are stored under
+}
+
+// S3ChunkStore keeps chunks in an S3-compatible object store. Objects are
+// addressed path-style as <bucket>/<prefix><fileID>/<chunkID> and requests
+// are signed with AWS Signature Version 4.
+type S3ChunkStore struct {
+	options S3Options
+	client  *http.Client
+}
+
+// NewS3ChunkStore creates a chunk store backed by an S3-compatible service
+func NewS3ChunkStore(options S3Options) (*S3ChunkStore, error) {
+	if options.Endpoint == "" || options.Bucket == "" {
+		return nil, errors.New("s3 endpoint and bucket are required")
+	}
+	if _, err := url.Parse(options.Endpoint); err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %w", err)
+	}
+	if options.Region == "" {
+		options.Region = "us-east-1"
+	}
+	options.Endpoint = strings.TrimRight(options.Endpoint, "/")
+
+	return &S3ChunkStore{
+		options: options,
+		client:  &http.Client{Timeout: 60 * time.Second},
+	}, nil
+}
+
+// Put uploads a chunk
+func (s *S3ChunkStore) Put(fileID, chunkID string, data []byte) error {
+	resp, err := s.do(http.MethodPut, s.key(fileID, chunkID), nil, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s.statusError(resp)
+	}
+	return nil
+}
+
+// Get downloads a chunk
+func (s *S3ChunkStore) Get(fileID, chunkID string) ([]byte, error) {
+	resp, err := s.do(http.MethodGet, s.key(fileID, chunkID), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("chunk %s: %w", chunkID, os.ErrNotExist)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, s.statusError(resp)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// Delete removes a chunk
+func (s *S3ChunkStore) Delete(fileID, chunkID string) error {
+	resp, err := s.do(http.MethodDelete, s.key(fileID, chunkID), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s.statusError(resp)
+	}
+	return nil
+}
+
+// Exists reports whether a chunk is stored
+func (s *S3ChunkStore) Exists(fileID, chunkID string) (bool, error) {
+	resp, err := s.do(http.MethodHead, s.key(fileID, chunkID), nil, nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, s.statusError(resp)
+	}
+}
+
+// listBucketResult is the part of a ListObjectsV2 response we use
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List returns the chunk IDs stored for a file
+func (s *S3ChunkStore) List(fileID string) ([]string, error) {
+	prefix := s.options.Prefix + fileID + "/"
+	chunkIDs := []string{}
+	token := ""
+
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			err := s.statusError(resp)
+			resp.Body.Close()
+			return nil, err
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode s3 listing: %w", err)
+		}
+
+		for _, object := range result.Contents {
+			chunkIDs = append(chunkIDs, strings.TrimPrefix(object.Key, prefix))
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	return chunkIDs, nil
+}
+
+// key returns the object key of a chunk
+func (s *S3ChunkStore) key(fileID, chunkID string) string {
+	return s.options.Prefix + fileID + "/" + chunkID
+}
+
+// statusError builds an error from an unexpected response
+func (s *S3ChunkStore) statusError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("s3 request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+// do sends a signed request for an object key, or the bucket itself when
+// key is empty
+func (s *S3ChunkStore) do(method, key string, query url.Values, body []byte) (*http.Response, error) {
+	path := "/" + s.options.Bucket
+	if key != "" {
+		path += "/" + key
+	}
+
+	target, err := url.Parse(s.options.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %w", err)
+	}
+	target.Path = strings.TrimRight(target.Path, "/") + path
+	target.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+
+	s.sign(req, body, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3 request failed: %w", err)
+	}
+	return resp, nil
+}
+
+// sign adds AWS Signature Version 4 headers to a request
+func (s *S3ChunkStore) sign(req *http.Request, body []byte, now time.Time) {
+	payloadHash := sha256.Sum256(body)
+	payloadHex := hex.EncodeToString(payloadHash[:])
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHex)
+
+	if s.options.AccessKey == "" {
+		return
+	}
+
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHex + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHex,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := date + "/" + s.options.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.options.SecretKey), date)
+	signingKey = hmacSHA256(signingKey, s.options.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.options.AccessKey, scope, signedHeaders, signature))
+}
+
+// canonicalQuery encodes query parameters sorted by key, as required by SigV4
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, sigv4Escape(k)+"="+sigv4Escape(v))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
+
+// sigv4Escape percent-encodes everything except unreserved characters
+func sigv4Escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// hmacSHA256 computes an HMAC-SHA256 of data with the given key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
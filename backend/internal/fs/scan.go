@@ -0,0 +1,99 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ErrUploadRejected is returned when an upload scanner rejects a file
+var ErrUploadRejected = errors.New("upload rejected by scanner")
+
+// UploadScanner inspects an uploaded file after it is written and before
+// it is made available. Scan returns an error wrapping ErrUploadRejected
+// to reject the file; any other error fails the upload as well.
+type UploadScanner interface {
+	Scan(path string) error
+}
+
+// NopScanner accepts every upload. It is the default scanner.
+type NopScanner struct{}
+
+// Scan implements UploadScanner
+func (NopScanner) Scan(path string) error {
+	return nil
+}
+
+// CommandScanner scans uploads with an external command, e.g. an
+// antivirus scanner. The path of the uploaded file is appended to the
+// arguments; a non-zero exit status rejects the file.
+type CommandScanner struct {
+	Command string
+	Args    []string
+	Timeout time.Duration // Time limit for one scan, 0 for no limit
+}
+
+// NewCommandScanner creates a scanner from a command line like
+// "clamscan --no-summary", split on whitespace
+func NewCommandScanner(commandLine string, timeout time.Duration) (*CommandScanner, error) {
+	fields := strings.Fields(commandLine)
+	if len(fields) == 0 {
+		return nil, errors.New("scan command is empty")
+	}
+
+	return &CommandScanner{
+		Command: fields[0],
+		Args:    fields[1:],
+		Timeout: timeout,
+	}, nil
+}
+
+// Scan implements UploadScanner
+func (s *CommandScanner) Scan(path string) error {
+	ctx := context.Background()
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.Command, append(append([]string(nil), s.Args...), path)...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return fmt.Errorf("scan of %s timed out after %v", path, s.Timeout)
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		reason := strings.TrimSpace(output.String())
+		if reason == "" {
+			reason = exitErr.Error()
+		}
+		return fmt.Errorf("%w: %s", ErrUploadRejected, reason)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to run scan command: %w", err)
+	}
+
+	return nil
+}
+
+// SetUploadScanner sets the scanner uploads are checked with. nil
+// restores the default, which accepts everything.
+func (dfs *DistributedFileSystem) SetUploadScanner(scanner UploadScanner) {
+	dfs.mu.Lock()
+	defer dfs.mu.Unlock()
+
+	if scanner == nil {
+		scanner = NopScanner{}
+	}
+	dfs.scanner = scanner
+}
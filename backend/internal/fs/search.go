@@ -0,0 +1,119 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Result caps of Search
+const (
+	DefaultSearchLimit = 100
+	MaxSearchLimit     = 1000
+)
+
+// SearchOptions selects how Search matches names
+type SearchOptions struct {
+	Glob          bool // Match the pattern as a glob instead of a substring
+	CaseSensitive bool
+	Limit         int // Maximum number of results, 0 for DefaultSearchLimit, capped at MaxSearchLimit
+}
+
+// errSearchDone stops a search walk once enough results are found
+var errSearchDone = errors.New("search result limit reached")
+
+// Search finds the files and directories below root whose name contains
+// pattern, or matches it as a glob with opts.Glob. Glob patterns with a
+// slash are matched against the path relative to root instead, e.g.
+// "docs/*.md". Matching ignores case unless opts.CaseSensitive is set.
+// Results come in path order and stop at the limit. Symbolic links are
+// not followed.
+func (dfs *DistributedFileSystem) Search(root, pattern string, opts SearchOptions) ([]FileInfo, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("search pattern must not be empty")
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	limit = min(limit, MaxSearchLimit)
+
+	if !opts.CaseSensitive {
+		pattern = strings.ToLower(pattern)
+	}
+	matchPath := opts.Glob && strings.Contains(pattern, "/")
+	if opts.Glob {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
+		}
+	}
+
+	// Matches are cached by refreshFileInfo, which needs the write lock
+	dfs.mu.Lock()
+	defer dfs.mu.Unlock()
+
+	rootPath, err := dfs.resolvePath(root)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(rootPath)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, ErrNotDirectory
+	}
+
+	var results []FileInfo
+	err = filepath.WalkDir(rootPath, func(fullPath string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if fullPath == rootPath {
+			return nil
+		}
+
+		searchPath, err := filepath.Rel(rootPath, fullPath)
+		if err != nil {
+			return err
+		}
+		relativePath := filepath.Join(root, searchPath)
+		if dfs.isInternalFile(relativePath) {
+			return nil
+		}
+
+		subject := entry.Name()
+		if matchPath {
+			subject = filepath.ToSlash(searchPath)
+		}
+		if !opts.CaseSensitive {
+			subject = strings.ToLower(subject)
+		}
+		var matched bool
+		if opts.Glob {
+			matched, _ = filepath.Match(pattern, subject)
+		} else {
+			matched = strings.Contains(subject, pattern)
+		}
+		if !matched {
+			return nil
+		}
+
+		stat, err := entry.Info()
+		if err != nil {
+			return nil // Removed while searching
+		}
+		results = append(results, *dfs.refreshFileInfo(relativePath, stat).clone())
+		if len(results) >= limit {
+			return errSearchDone
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errSearchDone) {
+		return nil, err
+	}
+
+	return results, nil
+}
@@ -0,0 +1,134 @@
+package fs
+
+// chunkSizeBuckets are the upper bounds of the chunk size histogram
+var chunkSizeBuckets = []float64{4096, 8192, 16384, 32768, 65536, 131072, 262144, 524288, 1048576}
+
+// chunkCountBuckets are the upper bounds of the chunks per file histogram
+var chunkCountBuckets = []float64{1, 2, 4, 8, 16, 32, 64, 128, 256, 512, 1024}
+
+// HistogramBucket is a cumulative histogram bucket: Count observations
+// were less than or equal to UpperBound
+type HistogramBucket struct {
+	UpperBound float64 `json:"le"`
+	Count      int64   `json:"count"`
+}
+
+// Histogram is a cumulative histogram in the style of Prometheus. The
+// +Inf bucket is left out since it always equals Count.
+type Histogram struct {
+	Buckets []HistogramBucket `json:"buckets"`
+	Count   int64             `json:"count"`
+	Sum     float64           `json:"sum"`
+}
+
+// newHistogram creates an empty histogram with the given bucket bounds
+func newHistogram(bounds []float64) *Histogram {
+	h := &Histogram{Buckets: make([]HistogramBucket, 0, len(bounds))}
+	for _, bound := range bounds {
+		h.Buckets = append(h.Buckets, HistogramBucket{UpperBound: bound})
+	}
+	return h
+}
+
+// observe records a value
+func (h *Histogram) observe(value float64) {
+	for i := range h.Buckets {
+		if value <= h.Buckets[i].UpperBound {
+			h.Buckets[i].Count++
+		}
+	}
+	h.Count++
+	h.Sum += value
+}
+
+// clone returns a copy of the histogram
+func (h *Histogram) clone() Histogram {
+	c := *h
+	c.Buckets = append([]HistogramBucket(nil), h.Buckets...)
+	return c
+}
+
+// ChunkStats describes the chunks produced by a chunker since it started
+type ChunkStats struct {
+	Files            int64     `json:"files"`
+	TotalChunks      int64     `json:"totalChunks"`
+	UniqueChunks     int64     `json:"uniqueChunks"`
+	TotalBytes       int64     `json:"totalBytes"`
+	AverageChunkSize float64   `json:"averageChunkSize"`
+	DedupRatio       float64   `json:"dedupRatio"` // Unique chunks / total chunks, 1 means no duplicates
+	MaxChunksPerFile int       `json:"maxChunksPerFile"`
+	ChunkSizes       Histogram `json:"chunkSizes"`
+	ChunksPerFile    Histogram `json:"chunksPerFile"`
+	CacheHits        int64     `json:"cacheHits"`
+	CacheMisses      int64     `json:"cacheMisses"`
+	ChunkReads       int64     `json:"chunkReads"`  // Chunks read, from the cache or the chunk store
+	ChunkWrites      int64     `json:"chunkWrites"` // Chunks written, by chunking files or storing chunks
+}
+
+// chunkStats accumulates ChunkStats, guarded by the chunker's mutex
+type chunkStats struct {
+	files            int64
+	totalChunks      int64
+	uniqueChunks     int64 // Chunks whose content wasn't stored yet when chunked
+	totalBytes       int64
+	maxChunksPerFile int
+	chunkSizes       *Histogram
+	chunksPerFile    *Histogram
+}
+
+// newChunkStats creates empty chunk statistics
+func newChunkStats() *chunkStats {
+	return &chunkStats{
+		chunkSizes:    newHistogram(chunkSizeBuckets),
+		chunksPerFile: newHistogram(chunkCountBuckets),
+	}
+}
+
+// recordFile adds the chunks of one chunked file to the statistics
+func (s *chunkStats) recordFile(chunks []*ChunkInfo) {
+	s.files++
+	s.totalChunks += int64(len(chunks))
+	for _, chunk := range chunks {
+		s.totalBytes += int64(chunk.Size)
+		s.chunkSizes.observe(float64(chunk.Size))
+	}
+	s.chunksPerFile.observe(float64(len(chunks)))
+	if len(chunks) > s.maxChunksPerFile {
+		s.maxChunksPerFile = len(chunks)
+	}
+}
+
+// Stats returns statistics about the chunks produced by ChunkFile and
+// ChunkReader. Unique chunks are counted by content hash when chunked, so
+// like the total they aren't lowered when chunks are deleted.
+func (fc *FileChunker) Stats() ChunkStats {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+
+	var hits, misses int64
+	if fc.cache != nil {
+		hits, misses = fc.cache.stats()
+	}
+
+	s := fc.stats
+	stats := ChunkStats{
+		Files:            s.files,
+		TotalChunks:      s.totalChunks,
+		UniqueChunks:     s.uniqueChunks,
+		TotalBytes:       s.totalBytes,
+		MaxChunksPerFile: s.maxChunksPerFile,
+		ChunkSizes:       s.chunkSizes.clone(),
+		ChunksPerFile:    s.chunksPerFile.clone(),
+		DedupRatio:       1,
+		CacheHits:        hits,
+		CacheMisses:      misses,
+		ChunkReads:       fc.reads.Load(),
+		ChunkWrites:      fc.writes.Load(),
+	}
+	if s.totalChunks > 0 {
+		stats.AverageChunkSize = float64(s.totalBytes) / float64(s.totalChunks)
+		stats.DedupRatio = float64(stats.UniqueChunks) / float64(s.totalChunks)
+	}
+
+	return stats
+}
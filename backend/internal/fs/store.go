@@ -0,0 +1,169 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// ChunkStore is where a FileChunker keeps chunk data. Get returns an error
+// wrapping os.ErrNotExist when a chunk is not stored.
+type ChunkStore interface {
+	Put(fileID, chunkID string, data []byte) error
+	Get(fileID, chunkID string) ([]byte, error)
+	Delete(fileID, chunkID string) error
+	Exists(fileID, chunkID string) (bool, error)
+	List(fileID string) ([]string, error) // Chunk IDs stored for a file
+}
+
+// ErrStoreNotLocal is returned by operations that only work on chunks kept
+// in the chunks directory
+var ErrStoreNotLocal = errors.New("operation requires the local chunk store")
+
+// localChunkStore keeps chunks in the chunker's directory, honouring its
+// layout and durability mode. It is the default store of a FileChunker.
+type localChunkStore struct {
+	fc *FileChunker
+}
+
+// Put writes a chunk to disk
+func (s *localChunkStore) Put(fileID, chunkID string, data []byte) error {
+	// Ensure the file directory exists
+	fileChunksDir := s.fc.fileDir(fileID)
+	if err := os.MkdirAll(fileChunksDir, 0755); err != nil {
+		return fmt.Errorf("failed to create file chunks directory: %w", err)
+	}
+
+	chunkPath := filepath.Join(fileChunksDir, chunkID)
+	if err := s.fc.writeChunk(chunkPath, data); err != nil {
+		return fmt.Errorf("failed to write chunk: %w", err)
+	}
+
+	if err := s.fc.syncBatch(fileChunksDir, []string{chunkPath}); err != nil {
+		return fmt.Errorf("failed to sync chunk: %w", err)
+	}
+
+	return nil
+}
+
+// Get reads a chunk from disk
+func (s *localChunkStore) Get(fileID, chunkID string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.fc.fileDir(fileID), chunkID))
+}
+
+// Delete removes a chunk from disk
+func (s *localChunkStore) Delete(fileID, chunkID string) error {
+	err := os.Remove(filepath.Join(s.fc.fileDir(fileID), chunkID))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Exists reports whether a chunk is on disk
+func (s *localChunkStore) Exists(fileID, chunkID string) (bool, error) {
+	_, err := os.Stat(filepath.Join(s.fc.fileDir(fileID), chunkID))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// List returns the chunk IDs stored on disk for a file
+func (s *localChunkStore) List(fileID string) ([]string, error) {
+	entries, err := os.ReadDir(s.fc.fileDir(fileID))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	chunkIDs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			chunkIDs = append(chunkIDs, entry.Name())
+		}
+	}
+
+	return chunkIDs, nil
+}
+
+// MemoryChunkStore keeps chunks in memory. Useful for tests and caches.
+type MemoryChunkStore struct {
+	chunks map[string]map[string][]byte // Maps file ID to chunk ID to data
+	mu     sync.RWMutex
+}
+
+// NewMemoryChunkStore creates an empty in-memory chunk store
+func NewMemoryChunkStore() *MemoryChunkStore {
+	return &MemoryChunkStore{
+		chunks: make(map[string]map[string][]byte),
+		mu:     sync.RWMutex{},
+	}
+}
+
+// Put stores a copy of the chunk data
+func (s *MemoryChunkStore) Put(fileID, chunkID string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.chunks[fileID] == nil {
+		s.chunks[fileID] = make(map[string][]byte)
+	}
+	s.chunks[fileID][chunkID] = append([]byte(nil), data...)
+
+	return nil
+}
+
+// Get returns a copy of the chunk data
+func (s *MemoryChunkStore) Get(fileID, chunkID string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, exists := s.chunks[fileID][chunkID]
+	if !exists {
+		return nil, fmt.Errorf("chunk %s: %w", chunkID, os.ErrNotExist)
+	}
+
+	return append([]byte(nil), data...), nil
+}
+
+// Delete removes a chunk
+func (s *MemoryChunkStore) Delete(fileID, chunkID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.chunks[fileID], chunkID)
+	if len(s.chunks[fileID]) == 0 {
+		delete(s.chunks, fileID)
+	}
+
+	return nil
+}
+
+// Exists reports whether a chunk is stored
+func (s *MemoryChunkStore) Exists(fileID, chunkID string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, exists := s.chunks[fileID][chunkID]
+	return exists, nil
+}
+
+// List returns the chunk IDs stored for a file
+func (s *MemoryChunkStore) List(fileID string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	chunkIDs := make([]string, 0, len(s.chunks[fileID]))
+	for chunkID := range s.chunks[fileID] {
+		chunkIDs = append(chunkIDs, chunkID)
+	}
+	sort.Strings(chunkIDs)
+
+	return chunkIDs, nil
+}
@@ -0,0 +1,138 @@
+package fs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrWatchUnsupported is returned by Watch on platforms without a file
+// change notification API
+var ErrWatchUnsupported = errors.New("watching for external changes is not supported on this platform")
+
+// RootDir returns the directory files are stored in
+func (dfs *DistributedFileSystem) RootDir() string {
+	return dfs.rootDir
+}
+
+// Watch starts watching the root directory for changes made outside the
+// file system, like another process writing to the data directory. Such
+// changes update the metadata cache and are published as external file
+// events. Paths under ignore (relative to the root) and hidden paths are
+// not watched.
+func (dfs *DistributedFileSystem) Watch(ignore []string) error {
+	dfs.mu.Lock()
+	defer dfs.mu.Unlock()
+
+	if dfs.watcher != nil {
+		return errors.New("already watching")
+	}
+
+	cleaned := append([]string(nil), dfs.hidden...)
+	for _, path := range ignore {
+		cleaned = append(cleaned, filepath.Clean(path))
+	}
+
+	watcher, err := startWatcher(dfs, cleaned)
+	if err != nil {
+		return err
+	}
+	dfs.watcher = watcher
+
+	return nil
+}
+
+// SetHiddenPaths hides paths relative to the root that other components
+// keep their data in, like the chunk store, and everything below them.
+// They are left out of listings, searches, Merkle trees and walks, aren't
+// watched, and operations on them fail with ErrReservedPath. Set them
+// before the file system is used.
+func (dfs *DistributedFileSystem) SetHiddenPaths(paths []string) {
+	dfs.mu.Lock()
+	defer dfs.mu.Unlock()
+
+	dfs.hidden = dfs.hidden[:0]
+	for _, path := range paths {
+		if key := policyKey(path); key != "" {
+			dfs.hidden = append(dfs.hidden, filepath.FromSlash(key))
+		}
+	}
+}
+
+// isIgnoredPath reports whether a relative path is under one of the ignored paths
+func isIgnoredPath(path string, ignore []string) bool {
+	for _, ignored := range ignore {
+		if path == ignored || strings.HasPrefix(path, ignored+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyExternalChange brings the metadata cache in line with a path that
+// changed on disk and publishes an event for it. Changes the cache already
+// reflects were made through the file system itself and are skipped.
+func (dfs *DistributedFileSystem) applyExternalChange(path string) {
+	if dfs.isInternalFile(path) {
+		return
+	}
+
+	dfs.mu.Lock()
+	cached, known := dfs.fileInfo[path]
+	info, err := os.Stat(filepath.Join(dfs.rootDir, path))
+
+	var event FileEvent
+	switch {
+	case err != nil && known:
+		delete(dfs.fileInfo, path)
+		if cached.IsDir {
+			dfs.forgetTree(path)
+		}
+		event = FileEvent{Type: FileRemoved, Path: path, IsDir: cached.IsDir}
+	case err != nil:
+		dfs.mu.Unlock()
+		return
+	case known && cached.IsDir == info.IsDir() && (info.IsDir() ||
+		cached.Size == info.Size() && cached.ModTime.Equal(info.ModTime())):
+		dfs.mu.Unlock()
+		return
+	case known:
+		cached.IsDir = info.IsDir()
+		cached.Size = info.Size()
+		cached.ModTime = info.ModTime()
+		cached.SHA256 = "" // Stale, the content changed behind our back
+		cached.Checksums = nil
+		cached.ContentType = ""
+		event = FileEvent{Type: FileModified, Path: path, IsDir: info.IsDir()}
+	default:
+		dfs.fileInfo[path] = &FileInfo{
+			Name:      filepath.Base(path),
+			Path:      path,
+			Size:      info.Size(),
+			IsDir:     info.IsDir(),
+			ModTime:   info.ModTime(),
+			Replicas:  dfs.inheritedReplicas(path),
+			Available: true,
+		}
+		event = FileEvent{Type: FileCreated, Path: path, IsDir: info.IsDir()}
+	}
+
+	dfs.metadataDirty = true
+	dfs.invalidateMerkle(path)
+	dfs.mu.Unlock()
+
+	event.External = true
+	dfs.publishFileEvent(event)
+}
+
+// forgetTree drops the cached metadata of everything below a directory.
+// Callers must hold dfs.mu.
+func (dfs *DistributedFileSystem) forgetTree(dirPath string) {
+	prefix := dirPath + string(filepath.Separator)
+	for path := range dfs.fileInfo {
+		if strings.HasPrefix(path, prefix) {
+			delete(dfs.fileInfo, path)
+		}
+	}
+}
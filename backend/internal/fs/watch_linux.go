@@ -0,0 +1,155 @@
+//go:build linux
+
+package fs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// inotifyMask is the set of inotify events the watcher listens for
+const inotifyMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ATTRIB
+
+// inotifyWatcher watches a directory tree with inotify
+type inotifyWatcher struct {
+	dfs    *DistributedFileSystem
+	file   *os.File
+	fd     int
+	ignore []string
+	dirs   map[int32]string // Relative directory path by watch descriptor
+	mu     sync.Mutex
+}
+
+// startWatcher watches every directory under the root and handles events
+// until the watcher is closed
+func startWatcher(dfs *DistributedFileSystem, ignore []string) (*inotifyWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize inotify: %w", err)
+	}
+
+	w := &inotifyWatcher{
+		dfs: dfs,
+		// A non-blocking descriptor is served by the runtime poller, so
+		// closing the file unblocks a pending read
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		fd:     fd,
+		ignore: ignore,
+		dirs:   make(map[int32]string),
+	}
+
+	if err := w.addTree(""); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+
+	go w.run()
+
+	return w, nil
+}
+
+// addTree watches a directory and everything below it
+func (w *inotifyWatcher) addTree(root string) error {
+	return filepath.WalkDir(filepath.Join(w.dfs.rootDir, root), func(fullPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil // Removed while we were walking
+			}
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+
+		path, err := filepath.Rel(w.dfs.rootDir, fullPath)
+		if err != nil {
+			return err
+		}
+		if path == "." {
+			path = ""
+		}
+		if isIgnoredPath(path, w.ignore) {
+			return filepath.SkipDir
+		}
+
+		wd, err := syscall.InotifyAddWatch(w.fd, fullPath, inotifyMask)
+		if err != nil {
+			return fmt.Errorf("failed to watch %s: %w", fullPath, err)
+		}
+
+		w.mu.Lock()
+		w.dirs[int32(wd)] = path
+		w.mu.Unlock()
+
+		return nil
+	})
+}
+
+// run reads and handles inotify events until the watcher is closed
+func (w *inotifyWatcher) run() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+			w.handle(event.Wd, event.Mask, string(bytes.TrimRight(nameBytes, "\x00")))
+		}
+	}
+}
+
+// handle handles a single inotify event
+func (w *inotifyWatcher) handle(wd int32, mask uint32, name string) {
+	w.mu.Lock()
+	dir, ok := w.dirs[wd]
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(w.dirs, wd) // The directory is gone
+	}
+	w.mu.Unlock()
+
+	if !ok || name == "" {
+		return
+	}
+
+	path := filepath.Join(dir, name)
+	if isIgnoredPath(path, w.ignore) {
+		return
+	}
+
+	// Watch new directories, including anything created in them before
+	// the watch was in place
+	if mask&syscall.IN_ISDIR != 0 && mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+		w.addTree(path)
+		filepath.WalkDir(filepath.Join(w.dfs.rootDir, path), func(fullPath string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if rel, err := filepath.Rel(w.dfs.rootDir, fullPath); err == nil && !isIgnoredPath(rel, w.ignore) {
+				w.dfs.applyExternalChange(rel)
+			}
+			return nil
+		})
+		return
+	}
+
+	w.dfs.applyExternalChange(path)
+}
+
+// Close stops watching
+func (w *inotifyWatcher) Close() error {
+	return w.file.Close()
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package fs
+
+// unsupportedWatcher stands in for the watcher on platforms without inotify
+type unsupportedWatcher struct{}
+
+// startWatcher returns ErrWatchUnsupported, inotify is only available on Linux
+func startWatcher(dfs *DistributedFileSystem, ignore []string) (*unsupportedWatcher, error) {
+	return nil, ErrWatchUnsupported
+}
+
+// Close implements io.Closer
+func (w *unsupportedWatcher) Close() error {
+	return nil
+}
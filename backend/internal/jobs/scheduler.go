@@ -0,0 +1,311 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Priority orders queued jobs, higher priorities run first
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+)
+
+// priorityNames are the names of the priorities in the API
+var priorityNames = map[Priority]string{
+	PriorityLow:    "low",
+	PriorityNormal: "normal",
+	PriorityHigh:   "high",
+}
+
+// ParsePriority returns the priority with the given name
+func ParsePriority(name string) (Priority, error) {
+	for priority, priorityName := range priorityNames {
+		if priorityName == name {
+			return priority, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown priority: %s", name)
+}
+
+// String returns the name of a priority
+func (p Priority) String() string {
+	if name, exists := priorityNames[p]; exists {
+		return name
+	}
+	return strconv.Itoa(int(p))
+}
+
+// MarshalJSON encodes a priority by name
+func (p Priority) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.String())
+}
+
+// Job states
+const (
+	StateQueued    = "queued"
+	StateRunning   = "running"
+	StateCompleted = "completed"
+	StateFailed    = "failed"
+	StateCancelled = "cancelled"
+)
+
+// ErrJobNotFound is returned for unknown job IDs
+var ErrJobNotFound = errors.New("job not found")
+
+// ErrJobFinished is returned when cancelling a job that already ended
+var ErrJobFinished = errors.New("job has already finished")
+
+// DefaultAgingInterval is how long a queued job waits before it is
+// treated as one priority higher, so low priority jobs can't starve
+const DefaultAgingInterval = time.Minute
+
+// Progress reports how much of its work a running task has done
+type Progress func(done, total int)
+
+// Task is the work of a job. It should return soon after ctx is
+// cancelled.
+type Task func(ctx context.Context, progress Progress) error
+
+// Job describes a scheduled background task
+type Job struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Priority    Priority   `json:"priority"`
+	State       string     `json:"state"`
+	Done        int        `json:"done"`
+	Total       int        `json:"total"`
+	Error       string     `json:"error,omitempty"`
+	QueuedAt    time.Time  `json:"queuedAt"`
+	StartedAt   *time.Time `json:"startedAt,omitempty"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+
+	task   Task
+	seq    uint64 // Submission order, keeps equal priorities first in first out
+	cancel context.CancelFunc
+	done   chan struct{} // Closed when the job ends
+}
+
+// Scheduler runs background tasks like scrubs and integrity checks with
+// a global concurrency cap. Queued jobs are started by priority, oldest
+// first within a priority, and gain a priority level for every aging
+// interval they wait.
+type Scheduler struct {
+	maxConcurrent int
+	aging         time.Duration
+	jobs          map[string]*Job
+	queue         []*Job
+	running       int
+	seq           uint64
+	mu            sync.Mutex
+}
+
+// NewScheduler creates a scheduler running at most maxConcurrent jobs at
+// once (at least one)
+func NewScheduler(maxConcurrent int) *Scheduler {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+
+	return &Scheduler{
+		maxConcurrent: maxConcurrent,
+		aging:         DefaultAgingInterval,
+		jobs:          make(map[string]*Job),
+		mu:            sync.Mutex{},
+	}
+}
+
+// SetAgingInterval sets how long a queued job waits before its priority
+// is raised by one level. 0 disables aging.
+func (s *Scheduler) SetAgingInterval(interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.aging = interval
+}
+
+// Submit queues a task and returns a snapshot of its job
+func (s *Scheduler) Submit(name string, priority Priority, task Task) Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	job := &Job{
+		ID:       uuid.New().String(),
+		Name:     name,
+		Priority: priority,
+		State:    StateQueued,
+		QueuedAt: time.Now(),
+		task:     task,
+		seq:      s.seq,
+		done:     make(chan struct{}),
+	}
+	s.jobs[job.ID] = job
+	s.queue = append(s.queue, job)
+
+	s.startQueued()
+
+	return *job
+}
+
+// Get returns a snapshot of a job
+func (s *Scheduler) Get(id string) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, exists := s.jobs[id]
+	if !exists {
+		return Job{}, ErrJobNotFound
+	}
+	return *job, nil
+}
+
+// Wait waits for a job to end and returns its final snapshot, or ctx's
+// error if ctx is done first
+func (s *Scheduler) Wait(ctx context.Context, id string) (Job, error) {
+	s.mu.Lock()
+	job, exists := s.jobs[id]
+	s.mu.Unlock()
+
+	if !exists {
+		return Job{}, ErrJobNotFound
+	}
+
+	select {
+	case <-job.done:
+		return s.Get(id)
+	case <-ctx.Done():
+		return Job{}, ctx.Err()
+	}
+}
+
+// List returns snapshots of all jobs, most recently queued first
+func (s *Scheduler) List() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, *job)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].seq > jobs[j].seq })
+	return jobs
+}
+
+// Cancel cancels a job. Queued jobs are dropped right away, running jobs
+// have their context cancelled and end once their task returns.
+func (s *Scheduler) Cancel(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, exists := s.jobs[id]
+	if !exists {
+		return ErrJobNotFound
+	}
+
+	switch job.State {
+	case StateQueued:
+		for i, queued := range s.queue {
+			if queued == job {
+				s.queue = append(s.queue[:i], s.queue[i+1:]...)
+				break
+			}
+		}
+		now := time.Now()
+		job.State = StateCancelled
+		job.CompletedAt = &now
+		close(job.done)
+	case StateRunning:
+		job.cancel()
+	default:
+		return ErrJobFinished
+	}
+
+	return nil
+}
+
+// startQueued starts queued jobs while there is capacity. Callers must
+// hold s.mu.
+func (s *Scheduler) startQueued() {
+	for s.running < s.maxConcurrent && len(s.queue) > 0 {
+		now := time.Now()
+		next := 0
+		for i := 1; i < len(s.queue); i++ {
+			if s.before(s.queue[i], s.queue[next], now) {
+				next = i
+			}
+		}
+
+		job := s.queue[next]
+		s.queue = append(s.queue[:next], s.queue[next+1:]...)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		job.cancel = cancel
+		job.State = StateRunning
+		job.StartedAt = &now
+		s.running++
+
+		go s.run(ctx, job)
+	}
+}
+
+// before reports whether queued job a should start before job b
+func (s *Scheduler) before(a, b *Job, now time.Time) bool {
+	pa, pb := s.effectivePriority(a, now), s.effectivePriority(b, now)
+	if pa != pb {
+		return pa > pb
+	}
+	return a.seq < b.seq
+}
+
+// effectivePriority returns a queued job's priority raised by the time it
+// has waited
+func (s *Scheduler) effectivePriority(job *Job, now time.Time) Priority {
+	if s.aging <= 0 {
+		return job.Priority
+	}
+	return job.Priority + Priority(now.Sub(job.QueuedAt)/s.aging)
+}
+
+// run runs a job's task and starts the next queued job once it ends
+func (s *Scheduler) run(ctx context.Context, job *Job) {
+	progress := func(done, total int) {
+		s.mu.Lock()
+		job.Done, job.Total = done, total
+		s.mu.Unlock()
+	}
+
+	err := job.task(ctx, progress)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	job.CompletedAt = &now
+	switch {
+	case ctx.Err() != nil:
+		job.State = StateCancelled
+	case err != nil:
+		job.State = StateFailed
+		job.Error = err.Error()
+	default:
+		job.State = StateCompleted
+	}
+	job.cancel()
+	close(job.done)
+	s.running--
+
+	s.startQueued()
+}
@@ -0,0 +1,154 @@
+package node
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned when connecting to a peer whose circuit
+// breaker is open after repeated connect failures
+var ErrCircuitOpen = errors.New("circuit breaker open, peer recently unreachable")
+
+// States of a peer circuit breaker
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// BreakerStatus describes the circuit breaker of a peer address
+type BreakerStatus struct {
+	Address   string     `json:"address"`
+	State     string     `json:"state"`
+	Failures  int        `json:"failures"`            // Consecutive connect failures
+	OpenUntil *time.Time `json:"openUntil,omitempty"` // Set while the breaker is open
+}
+
+// circuit tracks the connect failures of one peer address
+type circuit struct {
+	failures int
+	state    string
+	openedAt time.Time
+	probing  bool // A half-open probe is in flight
+}
+
+// circuitBreaker fast-fails connects to addresses that failed threshold
+// times in a row until cooldown has passed. After the cooldown a single
+// probe is let through: success closes the circuit, failure reopens it.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	circuits  map[string]*circuit
+	now       func() time.Time
+	mu        sync.Mutex
+}
+
+// newCircuitBreaker creates a circuit breaker
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		circuits:  make(map[string]*circuit),
+		now:       time.Now,
+	}
+}
+
+// allow returns ErrCircuitOpen if a connect to address must fast-fail
+func (b *circuitBreaker) allow(address string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.circuits[address]
+	if !ok {
+		return nil
+	}
+
+	switch c.state {
+	case BreakerOpen:
+		if b.now().Sub(c.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		c.state = BreakerHalfOpen
+		c.probing = true
+	case BreakerHalfOpen:
+		if c.probing {
+			return ErrCircuitOpen
+		}
+		c.probing = true
+	}
+
+	return nil
+}
+
+// success closes the circuit of address
+func (b *circuitBreaker) success(address string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.circuits, address)
+}
+
+// failure records a failed connect, opening the circuit once the threshold
+// is reached or when a half-open probe fails
+func (b *circuitBreaker) failure(address string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.circuits[address]
+	if !ok {
+		c = &circuit{state: BreakerClosed}
+		b.circuits[address] = c
+	}
+
+	c.failures++
+	c.probing = false
+	if c.state == BreakerHalfOpen || c.failures >= b.threshold {
+		c.state = BreakerOpen
+		c.openedAt = b.now()
+	}
+}
+
+// abort ends a connect attempt that neither succeeded nor failed, like one
+// cancelled by the caller, so a half-open circuit can be probed again
+func (b *circuitBreaker) abort(address string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if c, ok := b.circuits[address]; ok {
+		c.probing = false
+	}
+}
+
+// statuses returns the breakers of all addresses with recent failures
+func (b *circuitBreaker) statuses() []BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	statuses := make([]BreakerStatus, 0, len(b.circuits))
+	for address, c := range b.circuits {
+		status := BreakerStatus{
+			Address:  address,
+			State:    c.state,
+			Failures: c.failures,
+		}
+		if c.state == BreakerOpen {
+			until := c.openedAt.Add(b.cooldown)
+			status.OpenUntil = &until
+		}
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Address < statuses[j].Address
+	})
+
+	return statuses
+}
+
+// BreakerStatuses returns the circuit breakers of peer addresses that
+// recently failed to connect
+func (p *P2PNetwork) BreakerStatuses() []BreakerStatus {
+	return p.breaker.statuses()
+}
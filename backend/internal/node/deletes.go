@@ -0,0 +1,231 @@
+package node
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// ReplicaDeleter removes the replica of a file held by a node
+type ReplicaDeleter func(nodeID, fileKey string) error
+
+// PendingDelete is a replica that could not be deleted yet because its
+// node was unreachable. It is retried when the node comes back.
+type PendingDelete struct {
+	NodeID    string    `json:"nodeId"`
+	FileKey   string    `json:"fileKey"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"lastError"`
+	Since     time.Time `json:"since"`
+}
+
+// ReplicaDeleteReport tells which replicas of a deleted file were removed
+// and which are pending until their node is reachable again
+type ReplicaDeleteReport struct {
+	Deleted []string `json:"deleted"` // Node IDs
+	Pending []string `json:"pending"` // Node IDs
+}
+
+// SetReplicaDeleter sets how replicas are removed from other nodes. Without
+// a deleter, deleting a file leaves its replicas and placement alone.
+func (nm *NodeManager) SetReplicaDeleter(deleter ReplicaDeleter) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	nm.deleter = deleter
+}
+
+// DeleteReplicas removes the replicas of a deleted file from the nodes in
+// its placement, except localID which deleted its own copy. The placement
+// is forgotten; replicas on unreachable nodes are kept as pending deletes.
+func (nm *NodeManager) DeleteReplicas(fileKey, localID string) ReplicaDeleteReport {
+	nm.mu.Lock()
+	deleter := nm.deleter
+	holders := nm.placements[fileKey]
+	if deleter != nil {
+		delete(nm.placements, fileKey)
+		delete(nm.confirmed, fileKey)
+	}
+	nm.mu.Unlock()
+
+	report := ReplicaDeleteReport{Deleted: []string{}, Pending: []string{}}
+	if deleter == nil {
+		return report
+	}
+
+	for _, id := range holders {
+		if id == localID {
+			continue
+		}
+
+		if err := deleter(id, fileKey); err != nil {
+			nm.addPendingDelete(id, fileKey, err)
+			report.Pending = append(report.Pending, id)
+			continue
+		}
+		report.Deleted = append(report.Deleted, id)
+	}
+
+	return report
+}
+
+// RetryPendingDeletes retries the pending deletes of a node, e.g. once it
+// is reachable again, and returns how many succeeded
+func (nm *NodeManager) RetryPendingDeletes(nodeID string) int {
+	nm.mu.Lock()
+	deleter := nm.deleter
+	var fileKeys []string
+	for fileKey := range nm.pendingDeletes[nodeID] {
+		fileKeys = append(fileKeys, fileKey)
+	}
+	nm.mu.Unlock()
+
+	if deleter == nil {
+		return 0
+	}
+
+	deleted := 0
+	for _, fileKey := range fileKeys {
+		if err := deleter(nodeID, fileKey); err != nil {
+			nm.addPendingDelete(nodeID, fileKey, err)
+			continue
+		}
+
+		nm.mu.Lock()
+		delete(nm.pendingDeletes[nodeID], fileKey)
+		if len(nm.pendingDeletes[nodeID]) == 0 {
+			delete(nm.pendingDeletes, nodeID)
+		}
+		nm.mu.Unlock()
+		deleted++
+	}
+
+	return deleted
+}
+
+// PendingDeletes returns the replicas waiting to be deleted
+func (nm *NodeManager) PendingDeletes() []PendingDelete {
+	nm.mu.RLock()
+	defer nm.mu.RUnlock()
+
+	pending := []PendingDelete{}
+	for _, deletes := range nm.pendingDeletes {
+		for _, pd := range deletes {
+			pending = append(pending, *pd)
+		}
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].NodeID != pending[j].NodeID {
+			return pending[i].NodeID < pending[j].NodeID
+		}
+		return pending[i].FileKey < pending[j].FileKey
+	})
+	return pending
+}
+
+// addPendingDelete records a failed attempt to delete a replica
+func (nm *NodeManager) addPendingDelete(nodeID, fileKey string, err error) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	if nm.pendingDeletes[nodeID] == nil {
+		nm.pendingDeletes[nodeID] = make(map[string]*PendingDelete)
+	}
+	pd, exists := nm.pendingDeletes[nodeID][fileKey]
+	if !exists {
+		pd = &PendingDelete{NodeID: nodeID, FileKey: fileKey, Since: time.Now()}
+		nm.pendingDeletes[nodeID][fileKey] = pd
+	}
+	pd.Attempts++
+	pd.LastError = err.Error()
+}
+
+// ReplicaDelete is the payload of a delete replica message
+type ReplicaDelete struct {
+	Path      string `json:"path"`
+	Signature string `json:"signature,omitempty"` // HMAC-SHA256 with the cluster secret
+}
+
+// sign returns the signature of a delete sent by a node under a cluster
+// secret
+func (d ReplicaDelete) sign(secret, nodeID string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "delete\n%s\n%s", nodeID, d.Path)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// DeleteReplica asks a connected peer to delete its replica of a file. It
+// can be used as the node manager's ReplicaDeleter.
+func (p *P2PNetwork) DeleteReplica(nodeID, path string) error {
+	peer, err := p.activePeer(nodeID)
+	if err != nil {
+		return err
+	}
+
+	del := ReplicaDelete{Path: path}
+	if p.options.ClusterSecret != "" {
+		del.Signature = del.sign(p.options.ClusterSecret, p.options.NodeID)
+	}
+
+	payload, err := json.Marshal(del)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.SendRequest(peer, NewMessage(MessageTypeDeleteReplica, payload), p.options.TransferTimeout)
+	if err != nil {
+		return err
+	}
+	if resp.Type != MessageTypeReplicaDeleted {
+		return fmt.Errorf("expected replica deleted from peer %s, got message type %d", nodeID, resp.Type)
+	}
+
+	return nil
+}
+
+// handleDeleteReplica deletes the local replica of a file. Only identified
+// peers sharing the file's placement can delete it, and they must sign the
+// delete when a cluster secret is configured. A replica that is already
+// gone counts as deleted.
+func (p *P2PNetwork) handleDeleteReplica(peer *Peer, msg *Message) error {
+	var req ReplicaDelete
+	if err := json.Unmarshal(msg.Payload, &req); err != nil || req.Path == "" {
+		return &PeerError{Code: ErrorCodeBadRequest, Message: "delete replica needs a path"}
+	}
+
+	p.mu.RLock()
+	peerID := peer.ID
+	p.mu.RUnlock()
+
+	if peerID == "" {
+		return &PeerError{Code: ErrorCodeUnauthorized, Message: "replicas can only be deleted after the handshake"}
+	}
+	if p.options.ClusterSecret != "" && !hmac.Equal([]byte(req.Signature), []byte(req.sign(p.options.ClusterSecret, peerID))) {
+		return &PeerError{Code: ErrorCodeUnauthorized, Message: "invalid delete replica signature"}
+	}
+	if !p.nodeManager.SharesPlacement(req.Path, peerID) {
+		return &PeerError{Code: ErrorCodeUnauthorized, Message: fmt.Sprintf("node %s does not share the placement of %s", peerID, req.Path)}
+	}
+
+	p.mu.RLock()
+	fileSystem := p.fileSystem
+	p.mu.RUnlock()
+
+	if fileSystem == nil {
+		return &PeerError{Code: ErrorCodeNotFound, Message: "this node does not store files"}
+	}
+
+	if err := fileSystem.DeleteFile(req.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return &PeerError{Code: ErrorCodeInternal, Message: err.Error()}
+	}
+	p.nodeManager.RemovePlacement(req.Path)
+
+	return p.replyJSON(peer, msg, MessageTypeReplicaDeleted, req)
+}
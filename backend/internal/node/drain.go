@@ -0,0 +1,120 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Drain states
+const (
+	DrainRunning   = "running"
+	DrainCompleted = "completed"
+	DrainFailed    = "failed"
+)
+
+// DrainStatus reports the progress of draining a node
+type DrainStatus struct {
+	NodeID      string     `json:"nodeId"`
+	State       string     `json:"state"`
+	Total       int        `json:"total"`
+	Migrated    int        `json:"migrated"`
+	Remaining   int        `json:"remaining"`
+	Errors      []string   `json:"errors"`
+	StartedAt   time.Time  `json:"startedAt"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+}
+
+// StartDrain marks a node inactive so it receives no new replicas and
+// starts moving its existing replicas to other nodes in the background
+func (nm *NodeManager) StartDrain(id string) (*DrainStatus, error) {
+	if _, err := nm.GetNode(id); err != nil {
+		return nil, err
+	}
+
+	nm.mu.Lock()
+	if drain, exists := nm.drains[id]; exists && drain.State == DrainRunning {
+		nm.mu.Unlock()
+		return nil, errors.New("node is already draining")
+	}
+	nm.mu.Unlock()
+
+	if err := nm.UpdateNodeStatus(id, "inactive"); err != nil {
+		return nil, err
+	}
+
+	files := nm.FilesOnNode(id)
+	drain := &DrainStatus{
+		NodeID:    id,
+		State:     DrainRunning,
+		Total:     len(files),
+		Remaining: len(files),
+		Errors:    []string{},
+		StartedAt: time.Now(),
+	}
+
+	nm.mu.Lock()
+	nm.drains[id] = drain
+	nm.mu.Unlock()
+
+	go nm.runDrain(id, files)
+
+	return nm.GetDrainStatus(id)
+}
+
+// GetDrainStatus returns a snapshot of a node's drain progress
+func (nm *NodeManager) GetDrainStatus(id string) (*DrainStatus, error) {
+	nm.mu.RLock()
+	defer nm.mu.RUnlock()
+
+	drain, exists := nm.drains[id]
+	if !exists {
+		return nil, errors.New("node is not being drained")
+	}
+
+	status := *drain
+	status.Errors = append([]string{}, drain.Errors...)
+	return &status, nil
+}
+
+// runDrain moves every replica held by a node to another node
+func (nm *NodeManager) runDrain(id string, files []string) {
+	for _, fileKey := range files {
+		holders := nm.GetPlacement(fileKey)
+
+		// Pick a replacement that doesn't hold the file yet
+		targets := nm.SelectStorageNodes(PlacementRequest{
+			Key:      fileKey,
+			Replicas: 1,
+			Existing: holders,
+		})
+
+		nm.mu.Lock()
+		drain := nm.drains[id]
+		if len(targets) == 0 {
+			drain.Errors = append(drain.Errors, fmt.Sprintf("no node available for %s", fileKey))
+			nm.mu.Unlock()
+			continue
+		}
+		nm.mu.Unlock()
+
+		nm.replacePlacement(fileKey, id, targets[0])
+
+		nm.mu.Lock()
+		drain.Migrated++
+		drain.Remaining--
+		nm.mu.Unlock()
+	}
+
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	drain := nm.drains[id]
+	now := time.Now()
+	drain.CompletedAt = &now
+	if drain.Remaining == 0 {
+		drain.State = DrainCompleted
+	} else {
+		drain.State = DrainFailed
+	}
+}
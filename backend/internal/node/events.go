@@ -0,0 +1,139 @@
+package node
+
+import (
+	"sync"
+	"time"
+)
+
+// eventBuffer is how many events a subscriber may fall behind before it
+// is dropped
+const eventBuffer = 64
+
+// NodeEventType is the kind of change a NodeEvent reports
+type NodeEventType string
+
+const (
+	NodeRegistered    NodeEventType = "registered"
+	NodeStatusChanged NodeEventType = "statusChanged"
+	NodeRemoved       NodeEventType = "removed"
+	NodeHeartbeat     NodeEventType = "heartbeat"
+)
+
+// NodeEvent reports a change to a node
+type NodeEvent struct {
+	Type           NodeEventType `json:"type"`
+	NodeID         string        `json:"nodeId"`
+	Status         string        `json:"status,omitempty"`         // Status after the change
+	PreviousStatus string        `json:"previousStatus,omitempty"` // Set for status changes
+	Time           time.Time     `json:"time"`
+}
+
+// eventFanout hands events to subscribers without blocking the publisher
+type eventFanout[T any] struct {
+	subscribers map[chan T]struct{}
+	mu          sync.Mutex
+}
+
+// subscribe returns a channel receiving events and a function ending the
+// subscription. Subscribers that fall behind have their channel closed.
+func (f *eventFanout[T]) subscribe() (<-chan T, func()) {
+	events := make(chan T, eventBuffer)
+
+	f.mu.Lock()
+	if f.subscribers == nil {
+		f.subscribers = make(map[chan T]struct{})
+	}
+	f.subscribers[events] = struct{}{}
+	f.mu.Unlock()
+
+	cancel := func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if _, ok := f.subscribers[events]; ok {
+			delete(f.subscribers, events)
+			close(events)
+		}
+	}
+
+	return events, cancel
+}
+
+// publish hands an event to all subscribers without blocking
+func (f *eventFanout[T]) publish(event T) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for events := range f.subscribers {
+		select {
+		case events <- event:
+		default:
+			delete(f.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// SubscribeNodeEvents returns a channel receiving node events and a
+// function ending the subscription. Subscribers that fall behind have
+// their channel closed instead of blocking the node manager.
+func (nm *NodeManager) SubscribeNodeEvents() (<-chan NodeEvent, func()) {
+	return nm.events.subscribe()
+}
+
+// publishNodeEvent hands an event to all subscribers without blocking
+func (nm *NodeManager) publishNodeEvent(event NodeEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	nm.events.publish(event)
+}
+
+// PeerEventType is the kind of change a PeerEvent reports
+type PeerEventType string
+
+const (
+	PeerConnected    PeerEventType = "connected"
+	PeerDisconnected PeerEventType = "disconnected"
+)
+
+// PeerEvent reports a peer connection being established or lost
+type PeerEvent struct {
+	Type    PeerEventType `json:"type"`
+	PeerID  string        `json:"peerId"`
+	Address string        `json:"address"`
+	Time    time.Time     `json:"time"`
+}
+
+// SubscribePeerEvents returns a channel receiving peer events and a
+// function ending the subscription. Subscribers that fall behind have
+// their channel closed instead of blocking the network.
+func (p *P2PNetwork) SubscribePeerEvents() (<-chan PeerEvent, func()) {
+	return p.events.subscribe()
+}
+
+// publishPeerEvent hands an event about a peer to all subscribers
+func (p *P2PNetwork) publishPeerEvent(eventType PeerEventType, peer *Peer) {
+	p.mu.RLock()
+	id := peer.ID
+	p.mu.RUnlock()
+
+	p.events.publish(PeerEvent{Type: eventType, PeerID: id, Address: peer.Address, Time: time.Now()})
+}
+
+// setStatus changes a node's status and publishes the change, if it is
+// one. Callers must hold nm.mu.
+func (nm *NodeManager) setStatus(node *Node, status string) {
+	if node.Status == status {
+		return
+	}
+
+	previous := node.Status
+	node.Status = status
+	nm.dirty = true
+	nm.publishNodeEvent(NodeEvent{
+		Type:           NodeStatusChanged,
+		NodeID:         node.ID,
+		Status:         status,
+		PreviousStatus: previous,
+	})
+}
@@ -0,0 +1,97 @@
+package node
+
+import (
+	"time"
+)
+
+// SetLocalNodeID sets the ID this node is registered under. The health
+// monitor never fails the local node, it doesn't heartbeat itself.
+func (nm *NodeManager) SetLocalNodeID(id string) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	nm.localID = id
+}
+
+// StartHealthMonitor checks every interval for active nodes that were not
+// seen for longer than timeout and marks them failed, until Stop is
+// called. Failed nodes become active again with their next heartbeat.
+func (nm *NodeManager) StartHealthMonitor(interval, timeout time.Duration) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	if interval <= 0 || timeout <= 0 || nm.stopHealth != nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	nm.stopHealth = stop
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				for _, id := range nm.FailStaleNodes(timeout) {
+					nm.log().Warn("node sent no heartbeat, marked failed", "nodeId", id, "timeout", timeout)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops the health monitor and the registry auto save
+func (nm *NodeManager) Stop() {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	if nm.stopHealth != nil {
+		close(nm.stopHealth)
+		nm.stopHealth = nil
+	}
+	if nm.stopSave != nil {
+		close(nm.stopSave)
+		nm.stopSave = nil
+	}
+}
+
+// FailStaleNodes marks active nodes not seen for longer than timeout
+// failed and returns their IDs
+func (nm *NodeManager) FailStaleNodes(timeout time.Duration) []string {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	now := time.Now()
+	var failed []string
+	for id, node := range nm.nodes {
+		if id == nm.localID || node.Status != "active" || now.Sub(node.LastSeen) <= timeout {
+			continue
+		}
+
+		nm.setStatus(node, "failed")
+		nm.staleFailed[id] = true
+		failed = append(failed, id)
+	}
+	if len(failed) > 0 {
+		nm.dirty = true
+	}
+
+	return failed
+}
+
+// reviveNode makes a node the health monitor failed active again now that
+// it was heard from. Callers must hold nm.mu.
+func (nm *NodeManager) reviveNode(node *Node) {
+	if !nm.staleFailed[node.ID] {
+		return
+	}
+
+	delete(nm.staleFailed, node.ID)
+	if node.Status == "failed" {
+		nm.setStatus(node, "active")
+	}
+}
@@ -0,0 +1,97 @@
+package node
+
+import (
+	"time"
+)
+
+// keepAlive pings all active peers every PingTimeout/3 and reaps the ones
+// that stayed silent for longer than PingTimeout, until the network is
+// stopped. Dead TCP connections are otherwise only noticed when a write
+// to them fails.
+func (p *P2PNetwork) keepAlive() {
+	interval := p.options.PingTimeout / 3
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+			p.reapDeadPeers()
+			p.pingPeers()
+		}
+	}
+}
+
+// pingPeers sends a ping to every active peer
+func (p *P2PNetwork) pingPeers() {
+	p.mu.RLock()
+	peers := make([]*Peer, 0, len(p.peers))
+	for _, peer := range p.peers {
+		if peer.IsActive {
+			peers = append(peers, peer)
+		}
+	}
+	p.mu.RUnlock()
+
+	for _, peer := range peers {
+		if err := p.Ping(peer); err != nil {
+			p.peerLog(peer).Warn("error pinging peer", "error", err)
+		}
+	}
+}
+
+// BroadcastHeartbeat sends a ping carrying this node's capacity and
+// status to every active peer right away, instead of waiting for the next
+// keepalive round
+func (p *P2PNetwork) BroadcastHeartbeat() (*BroadcastResult, error) {
+	return p.BroadcastMessage(NewMessage(MessageTypePing, p.heartbeat()))
+}
+
+// reapDeadPeers marks active peers that sent nothing for longer than the
+// ping timeout, and peers a broadcast failed to reach, inactive, closes
+// their connection and deregisters their node. Reaped peers are evicted
+// after the peer retention like any other disconnected peer.
+func (p *P2PNetwork) reapDeadPeers() int {
+	now := time.Now()
+
+	p.mu.Lock()
+	var dead []*Peer
+	for _, peer := range p.peers {
+		if peer.sendFailed || (peer.IsActive && now.Sub(peer.LastActive) > p.options.PingTimeout) {
+			peer.sendFailed = false
+			peer.writeMu.Lock()
+			peer.IsActive = false
+			peer.writeMu.Unlock()
+			peer.LastActive = now // Retention counts from the reap
+			dead = append(dead, peer)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, peer := range dead {
+		p.peerLog(peer).Warn("peer is unreachable, disconnecting")
+		if peer.Conn != nil {
+			peer.Conn.Close()
+		}
+		if peer.ID != "" {
+			p.nodeManager.RemoveNode(peer.ID)
+		}
+	}
+
+	return len(dead)
+}
+
+// flagDeliveryFailure marks a peer a message could not be sent to, so the
+// next reaper run deregisters it
+func (p *P2PNetwork) flagDeliveryFailure(peer *Peer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	peer.sendFailed = true
+}
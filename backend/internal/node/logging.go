@@ -0,0 +1,31 @@
+package node
+
+import (
+	"log/slog"
+)
+
+// SetLogger sets the logger of the node manager, nil logs to slog.Default()
+func (nm *NodeManager) SetLogger(logger *slog.Logger) {
+	nm.logger.Store(logger)
+}
+
+// log returns the node manager's logger
+func (nm *NodeManager) log() *slog.Logger {
+	if logger := nm.logger.Load(); logger != nil {
+		return logger
+	}
+	return slog.Default()
+}
+
+// peerLog returns the network's logger with the address and node ID of a
+// peer as fields. Callers must not hold p.mu.
+func (p *P2PNetwork) peerLog(peer *Peer) *slog.Logger {
+	p.mu.RLock()
+	id := peer.ID
+	p.mu.RUnlock()
+
+	if id == "" {
+		return p.logger.With("peer", peer.Address)
+	}
+	return p.logger.With("peer", peer.Address, "nodeId", id)
+}
@@ -0,0 +1,99 @@
+package node
+
+import (
+	"strconv"
+	"sync"
+)
+
+// messageTypeNames name the message types in statistics
+var messageTypeNames = map[MessageType]string{
+	MessageTypePing:             "ping",
+	MessageTypePong:             "pong",
+	MessageTypeNodeDiscovery:    "nodeDiscovery",
+	MessageTypeNodeAnnouncement: "nodeAnnouncement",
+	MessageTypeFileRequest:      "fileRequest",
+	MessageTypeFileInfo:         "fileInfo",
+	MessageTypeFileChunk:        "fileChunk",
+	MessageTypeError:            "error",
+	MessageTypeHandshake:        "handshake",
+	MessageTypeNodeRegistration: "nodeRegistration",
+	MessageTypeDeleteReplica:    "deleteReplica",
+	MessageTypeReplicaDeleted:   "replicaDeleted",
+}
+
+// String returns the name of a message type, or its number for types this
+// node doesn't know
+func (t MessageType) String() string {
+	if name, ok := messageTypeNames[t]; ok {
+		return name
+	}
+	return strconv.Itoa(int(t))
+}
+
+// MessageStats counts the P2P messages sent and received since the network
+// was created, by message type name
+type MessageStats struct {
+	Sent     map[string]int64 `json:"sent"`
+	Received map[string]int64 `json:"received"`
+}
+
+// messageCounter counts messages by type
+type messageCounter struct {
+	sent     map[MessageType]int64
+	received map[MessageType]int64
+	mu       sync.Mutex
+}
+
+// countSent counts a message delivered to a peer
+func (c *messageCounter) countSent(msgType MessageType) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.sent == nil {
+		c.sent = make(map[MessageType]int64)
+	}
+	c.sent[msgType]++
+}
+
+// countReceived counts a message read from a peer
+func (c *messageCounter) countReceived(msgType MessageType) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.received == nil {
+		c.received = make(map[MessageType]int64)
+	}
+	c.received[msgType]++
+}
+
+// MessageStats returns the number of messages sent and received by type
+func (p *P2PNetwork) MessageStats() MessageStats {
+	p.messages.mu.Lock()
+	defer p.messages.mu.Unlock()
+
+	stats := MessageStats{
+		Sent:     make(map[string]int64, len(p.messages.sent)),
+		Received: make(map[string]int64, len(p.messages.received)),
+	}
+	for msgType, count := range p.messages.sent {
+		stats.Sent[msgType.String()] = count
+	}
+	for msgType, count := range p.messages.received {
+		stats.Received[msgType.String()] = count
+	}
+	return stats
+}
+
+// sendMessage encodes a message and sends it to a peer
+func (p *P2PNetwork) sendMessage(peer *Peer, msg *Message) error {
+	encodedMsg, err := EncodeMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	if err := peer.Send(encodedMsg); err != nil {
+		return err
+	}
+	p.messages.countSent(msg.Type)
+	return nil
+}
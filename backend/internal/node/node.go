@@ -2,51 +2,112 @@ package node
 
 import (
 	"errors"
+	"log/slog"
 	"net/url"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Node represents a node in the distributed file system
 type Node struct {
-	ID          string    `json:"id"`
-	Address     string    `json:"address"`
-	Status      string    `json:"status"` // "active", "inactive", "failed"
-	StorageUsed int64     `json:"storageUsed"`
-	StorageMax  int64     `json:"storageMax"`
-	LastSeen    time.Time `json:"lastSeen"`
+	ID          string            `json:"id"`
+	Address     string            `json:"address"`
+	Status      string            `json:"status"` // "active", "inactive", "failed"
+	StorageUsed int64             `json:"storageUsed"`
+	StorageMax  int64             `json:"storageMax"`
+	LastSeen    time.Time         `json:"lastSeen"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Zone        string            `json:"zone,omitempty"` // Failure domain (zone or rack)
+	Latency     time.Duration     `json:"latency"`        // Last measured round trip time, 0 if unknown
 }
 
 // NodeManager manages the nodes in the distributed file system
 type NodeManager struct {
-	nodes     map[string]*Node
-	nodeAddrs map[string]string // Maps address to ID
-	mu        sync.RWMutex
+	nodes          map[string]*Node
+	nodeAddrs      map[string]string // Maps address to ID
+	placement      PlacementStrategy
+	zoneSpread     bool                // Spread replicas across zones
+	placements     map[string][]string // Maps file key to the node IDs holding it
+	confirmed      map[string][]string // Maps file key to the node IDs a copy was received from
+	drains         map[string]*DrainStatus
+	reserve        StorageReserve // Free space kept back on every node
+	readCounter    int            // Rotates round-robin replica reads
+	deleter        ReplicaDeleter
+	pendingDeletes map[string]map[string]*PendingDelete // Maps node ID to file key to replicas left to delete
+	registryPath   string                               // File the nodes are saved to, empty to keep them in memory only
+	dirty          bool                                 // Nodes changed since the last save
+	stopSave       chan struct{}
+	localID        string          // Never failed by the health monitor
+	staleFailed    map[string]bool // Nodes the health monitor failed
+	stopHealth     chan struct{}
+	events         eventFanout[NodeEvent]
+	logger         atomic.Pointer[slog.Logger] // nil logs to slog.Default()
+	saveMu         sync.Mutex
+	mu             sync.RWMutex
 }
 
 // NewNodeManager creates a new instance of the NodeManager
 func NewNodeManager() *NodeManager {
 	return &NodeManager{
-		nodes:     make(map[string]*Node),
-		nodeAddrs: make(map[string]string),
-		mu:        sync.RWMutex{},
+		nodes:          make(map[string]*Node),
+		nodeAddrs:      make(map[string]string),
+		placement:      FreeSpacePlacement{},
+		placements:     make(map[string][]string),
+		confirmed:      make(map[string][]string),
+		drains:         make(map[string]*DrainStatus),
+		pendingDeletes: make(map[string]map[string]*PendingDelete),
+		staleFailed:    make(map[string]bool),
+		mu:             sync.RWMutex{},
 	}
 }
 
+// SetPlacementStrategy sets the strategy used to pick storage nodes
+func (nm *NodeManager) SetPlacementStrategy(strategy PlacementStrategy) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	
+	nm.placement = strategy
+}
+
+// SetNodeLabels replaces the labels of a node
+func (nm *NodeManager) SetNodeLabels(id string, labels map[string]string) error {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	
+	node, exists := nm.nodes[id]
+	if !exists {
+		return errors.New("node not found")
+	}
+	
+	node.Labels = labels
+	nm.dirty = true
+	
+	return nil
+}
+
 // RegisterNode registers a new node or updates an existing one
 func (nm *NodeManager) RegisterNode(id, address string, storageMax int64) (*Node, error) {
+	node, _, err := nm.UpsertNode(id, address, storageMax)
+	return node, err
+}
+
+// UpsertNode works like RegisterNode and also reports whether the node was
+// newly created (true) or an existing node was updated (false)
+func (nm *NodeManager) UpsertNode(id, address string, storageMax int64) (*Node, bool, error) {
 	nm.mu.Lock()
 	defer nm.mu.Unlock()
 	
 	// Validate the address
 	_, err := url.Parse(address)
 	if err != nil {
-		return nil, errors.New("invalid node address")
+		return nil, false, errors.New("invalid node address")
 	}
 	
 	// Check if the address is already registered to another node
 	if existingID, found := nm.nodeAddrs[address]; found && existingID != id {
-		return nil, errors.New("address already registered to another node")
+		return nil, false, errors.New("address already registered to another node")
 	}
 	
 	// Create or update the node
@@ -61,18 +122,24 @@ func (nm *NodeManager) RegisterNode(id, address string, storageMax int64) (*Node
 			LastSeen:    time.Now(),
 		}
 		nm.nodes[id] = node
+		nm.publishNodeEvent(NodeEvent{Type: NodeRegistered, NodeID: id, Status: node.Status})
 	} else {
-		// Update existing node
+		// Update existing node, releasing its old address
+		if node.Address != address {
+			delete(nm.nodeAddrs, node.Address)
+		}
 		node.Address = address
-		node.Status = "active"
+		nm.setStatus(node, "active")
 		node.StorageMax = storageMax
 		node.LastSeen = time.Now()
 	}
 	
 	// Update the address mapping
 	nm.nodeAddrs[address] = id
+	delete(nm.staleFailed, id)
+	nm.dirty = true
 	
-	return node, nil
+	return node, !exists, nil
 }
 
 // GetNode returns a node by its ID
@@ -115,8 +182,10 @@ func (nm *NodeManager) UpdateNodeStatus(id, status string) error {
 		return errors.New("invalid node status")
 	}
 	
-	node.Status = status
+	nm.setStatus(node, status)
 	node.LastSeen = time.Now()
+	delete(nm.staleFailed, id)
+	nm.dirty = true
 	
 	return nil
 }
@@ -141,6 +210,7 @@ func (nm *NodeManager) UpdateNodeStorage(id string, storageUsed int64) error {
 	
 	node.StorageUsed = storageUsed
 	node.LastSeen = time.Now()
+	nm.dirty = true
 	
 	return nil
 }
@@ -160,6 +230,9 @@ func (nm *NodeManager) RemoveNode(id string) error {
 	
 	// Remove the node
 	delete(nm.nodes, id)
+	delete(nm.staleFailed, id)
+	nm.dirty = true
+	nm.publishNodeEvent(NodeEvent{Type: NodeRemoved, NodeID: id, Status: node.Status})
 	
 	return nil
 }
@@ -175,6 +248,53 @@ func (nm *NodeManager) HeartbeatNode(id string) error {
 	}
 	
 	node.LastSeen = time.Now()
+	nm.reviveNode(node)
+	nm.publishNodeEvent(NodeEvent{Type: NodeHeartbeat, NodeID: id, Status: node.Status})
+	
+	return nil
+}
+
+// ApplyHeartbeat updates a node's capacity and status from a heartbeat it
+// sent. Invalid values are ignored rather than rejected, heartbeats are
+// best effort.
+func (nm *NodeManager) ApplyHeartbeat(id string, storageUsed, storageMax int64, status string) error {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	
+	node, exists := nm.nodes[id]
+	if !exists {
+		return errors.New("node not found")
+	}
+	
+	nm.reviveNode(node)
+	
+	if storageMax > 0 && storageUsed >= 0 && storageUsed <= storageMax {
+		node.StorageUsed = storageUsed
+		node.StorageMax = storageMax
+	}
+	
+	// Drained nodes stay inactive whatever they report
+	if _, drained := nm.drains[id]; !drained && (status == "active" || status == "inactive") {
+		nm.setStatus(node, status)
+	}
+	node.LastSeen = time.Now()
+	nm.dirty = true
+	nm.publishNodeEvent(NodeEvent{Type: NodeHeartbeat, NodeID: id, Status: node.Status})
+	
+	return nil
+}
+
+// UpdateNodeLatency records the last measured round trip time to a node
+func (nm *NodeManager) UpdateNodeLatency(id string, latency time.Duration) error {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	
+	node, exists := nm.nodes[id]
+	if !exists {
+		return errors.New("node not found")
+	}
+	
+	node.Latency = latency
 	
 	return nil
 }
@@ -182,38 +302,96 @@ func (nm *NodeManager) HeartbeatNode(id string) error {
 // GetOptimalStorageNodes returns a list of node IDs that are optimal for storing a file
 // based on available space and distribution
 func (nm *NodeManager) GetOptimalStorageNodes(fileSize int64, replicaCount int) []string {
+	return nm.SelectStorageNodes(PlacementRequest{
+		FileSize: fileSize,
+		Replicas: replicaCount,
+	})
+}
+
+// SelectStorageNodes returns the nodes a file should be stored on according
+// to the configured placement strategy
+func (nm *NodeManager) SelectStorageNodes(req PlacementRequest) []string {
+	result := nm.SelectStorageNodesDetailed(req)
+	
+	ids := make([]string, len(result.Selected))
+	for i, selected := range result.Selected {
+		ids[i] = selected.ID
+	}
+	return ids
+}
+
+// SelectStorageNodesDetailed works like SelectStorageNodes but also
+// explains the selection: the available space of the selected nodes and
+// why every other node was passed over
+func (nm *NodeManager) SelectStorageNodesDetailed(req PlacementRequest) PlacementResult {
 	nm.mu.RLock()
 	defer nm.mu.RUnlock()
 	
-	// Filter active nodes with enough space
+	existing := make(map[string]bool, len(req.Existing))
+	for _, id := range req.Existing {
+		existing[id] = true
+	}
+	
+	// Filter active nodes with enough space above the reserve that don't hold the file yet
 	var eligibleNodes []*Node
+	var rejected []RejectedNode
 	for _, node := range nm.nodes {
-		if node.Status == "active" && (node.StorageMax - node.StorageUsed) >= fileSize {
+		switch {
+		case node.Status != "active":
+			rejected = append(rejected, RejectedNode{ID: node.ID, Reason: RejectInactive})
+		case existing[node.ID]:
+			rejected = append(rejected, RejectedNode{ID: node.ID, Reason: RejectHoldsFile})
+		case (node.StorageMax - node.StorageUsed) < req.FileSize:
+			rejected = append(rejected, RejectedNode{ID: node.ID, Reason: RejectFull})
+		case nm.usableFree(node) < req.FileSize:
+			rejected = append(rejected, RejectedNode{ID: node.ID, Reason: RejectReserve})
+		default:
 			eligibleNodes = append(eligibleNodes, node)
 		}
 	}
 	
-	// Sort nodes by available space (descending)
-	// In a real implementation, we would also consider network topology, load, etc.
-	// This is a simplified version
-	for i := 0; i < len(eligibleNodes)-1; i++ {
-		for j := i + 1; j < len(eligibleNodes); j++ {
-			iAvail := eligibleNodes[i].StorageMax - eligibleNodes[i].StorageUsed
-			jAvail := eligibleNodes[j].StorageMax - eligibleNodes[j].StorageUsed
-			if jAvail > iAvail {
-				eligibleNodes[i], eligibleNodes[j] = eligibleNodes[j], eligibleNodes[i]
-			}
+	// With zone spreading the strategy ranks all eligible nodes and the
+	// replicas are taken from that ranking one zone at a time
+	var selectedIDs []string
+	if nm.zoneSpread {
+		ranking := req
+		ranking.Replicas = len(eligibleNodes)
+		ranked := make([]*Node, 0, len(eligibleNodes))
+		for _, id := range nm.placement.SelectNodes(eligibleNodes, ranking) {
+			ranked = append(ranked, nm.nodes[id])
 		}
+		selectedIDs = spreadAcrossZones(ranked, req.Replicas)
+	} else {
+		selectedIDs = nm.placement.SelectNodes(eligibleNodes, req)
 	}
 	
-	// Get the top N nodes
-	resultCount := min(replicaCount, len(eligibleNodes))
-	result := make([]string, resultCount)
+	result := PlacementResult{Selected: []PlacedNode{}}
+	chosen := make(map[string]bool)
+	for _, id := range selectedIDs {
+		node := nm.nodes[id]
+		chosen[id] = true
+		result.Selected = append(result.Selected, PlacedNode{
+			ID:        id,
+			Address:   node.Address,
+			Available: nm.usableFree(node),
+		})
+	}
 	
-	for i := 0; i < resultCount; i++ {
-		result[i] = eligibleNodes[i].ID
+	// Eligible nodes the strategy passed over
+	for _, node := range eligibleNodes {
+		if chosen[node.ID] {
+			continue
+		}
+		reason := RejectNotSelected
+		if !hasLabels(node, req.Labels) {
+			reason = RejectLabels
+		}
+		rejected = append(rejected, RejectedNode{ID: node.ID, Reason: reason})
 	}
 	
+	sort.Slice(rejected, func(i, j int) bool { return rejected[i].ID < rejected[j].ID })
+	result.Rejected = rejected
+	
 	return result
 }
 
@@ -1,51 +1,130 @@
 package node
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"math/rand"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/google/uuid"
+	"github.com/user/distfs/internal/fs"
 )
 
 // P2POptions contains configuration options for the P2P network
 type P2POptions struct {
-	Port        int
-	NodeID      string
-	MaxPeers    int
-	PingTimeout time.Duration
+	Port              int
+	ListenAddr        string // host:port to listen on, overrides Port when set
+	NodeID            string
+	MaxPeers          int           // Cap on connected peers, inbound and outbound, 0 for no limit
+	PingTimeout       time.Duration // Peers silent for longer are disconnected, they are pinged every third of it
+	ConnectTimeout    time.Duration // Deadline for connecting to a peer
+	PeerRetention     time.Duration // How long disconnected peers are kept before eviction
+	MaxConnHandlers   int
+	MessageWorkers    int            // Workers shared by all connections for slow data messages
+	MaxDiscoveryPeers int            // Cap on peers included in a discovery response
+	DiscoveryTTL      time.Duration  // How long unconnected discovered addresses are kept
+	BreakerThreshold  int            // Consecutive connect failures that open a peer's circuit breaker
+	BreakerCooldown   time.Duration  // How long an open circuit breaker fast-fails connects
+	MaxMessageSize    int            // Largest accepted message in bytes, peers sending larger frames are dropped
+	AdvertiseAddr     string         // HTTP API address announced to peers for self-registration, empty to not register
+	StorageMax        int64          // Storage capacity announced to peers for self-registration
+	Zone              string         // Failure domain announced to peers for self-registration
+	ClusterSecret     string         // Shared secret signing self-registrations, empty accepts unsigned ones
+	ReconnectMin      time.Duration  // Delay before reconnecting to a lost persistent peer, doubled on every failure
+	ReconnectMax      time.Duration  // Cap on the delay between reconnect attempts
+	TransferTimeout   time.Duration  // How long a file transfer waits for the peer's next message
+	TLS               *P2PTLSOptions // Wraps connections in TLS when set
+	Logger            *slog.Logger   // Defaults to slog.Default()
 }
 
 // DefaultP2POptions returns default configuration options
 func DefaultP2POptions() P2POptions {
 	return P2POptions{
-		Port:        9000,
-		NodeID:      "",
-		MaxPeers:    50,
-		PingTimeout: 30 * time.Second,
+		Port:              9000,
+		NodeID:            "",
+		MaxPeers:          50,
+		PingTimeout:       30 * time.Second,
+		ConnectTimeout:    5 * time.Second,
+		PeerRetention:     10 * time.Minute,
+		MaxConnHandlers:   100,
+		MessageWorkers:    16,
+		MaxDiscoveryPeers: 20,
+		DiscoveryTTL:      10 * time.Minute,
+		BreakerThreshold:  5,
+		BreakerCooldown:   30 * time.Second,
+		MaxMessageSize:    4 * 1024 * 1024,
+		ReconnectMin:      time.Second,
+		ReconnectMax:      time.Minute,
+		TransferTimeout:   30 * time.Second,
 	}
 }
 
 // P2PNetwork represents the peer-to-peer network
 type P2PNetwork struct {
-	options     P2POptions
-	peers       map[string]*Peer
-	mu          sync.RWMutex
-	handlers    map[MessageType]MessageHandler
-	listener    net.Listener
-	isRunning   bool
-	nodeManager *NodeManager
+	options       P2POptions
+	logger        *slog.Logger
+	peers         map[string]*Peer
+	mu            sync.RWMutex
+	handlers      map[MessageType]MessageHandler
+	listener      net.Listener
+	isRunning     bool
+	nodeManager   *NodeManager
+	connSlots     chan struct{}
+	workers       chan struct{}              // Bounds concurrently running data message handlers
+	discovered    map[string]time.Time       // Discovered addresses not yet connected
+	breaker       *circuitBreaker            // Fast-fails connects to unreachable peers
+	persistent    map[string]*reconnectState // Addresses to stay connected to
+	reconnectWake chan struct{}
+	pending       map[string]*pendingRequest // Requests waiting for responses, by request ID
+	fileSystem    *fs.DistributedFileSystem
+	chunker       *fs.FileChunker // Serves file requests from peers, nil to serve none
+	events        eventFanout[PeerEvent]
+	messages      messageCounter
+	tlsServer     *tls.Config     // Accepts TLS connections, nil without TLS
+	tlsClient     *tls.Config     // Dials TLS connections, nil without TLS
+	ctx           context.Context // Cancelled by Stop to abort pending connects
+	cancel        context.CancelFunc
 }
 
 // Peer represents a network peer
 type Peer struct {
-	ID         string
-	Address    string
-	Conn       net.Conn
-	LastActive time.Time
-	IsActive   bool
+	ID            string
+	Address       string
+	Conn          net.Conn
+	LastActive    time.Time
+	IsActive      bool
+	Latency       time.Duration // Round trip time of the last ping
+	BytesSent     atomic.Int64  // Bytes written to the connection, including framing
+	BytesReceived atomic.Int64  // Bytes read from the connection, including framing
+	pingSent      time.Time
+	sendFailed    bool          // A broadcast failed to reach the peer, guarded by the network's mu
+	inbound       bool          // The peer connected to us
+	handshook     chan struct{} // Closed once the peer's handshake set its ID
+	closed        chan struct{} // Closed when the read loop ends
+	writeMu       sync.Mutex    // Keeps frames from concurrent handlers from interleaving, guards IsActive writes
+}
+
+// BroadcastResult reports how far a broadcast got
+type BroadcastResult struct {
+	Peers     int                `json:"peers"` // Active peers the message was sent to
+	Delivered int                `json:"delivered"`
+	Failed    []BroadcastFailure `json:"failed"`
+}
+
+// BroadcastFailure is a peer a broadcast failed to reach
+type BroadcastFailure struct {
+	PeerID  string `json:"peerId"`
+	Address string `json:"address"`
+	Error   string `json:"error"`
 }
 
 // MessageType defines the type of message being sent
@@ -61,12 +140,54 @@ const (
 	MessageTypeFileInfo
 	MessageTypeFileChunk
 	MessageTypeError
+	MessageTypeHandshake
+	MessageTypeNodeRegistration
+	MessageTypeDeleteReplica
+	MessageTypeReplicaDeleted
+)
+
+// ErrorCode identifies why a peer rejected a message
+type ErrorCode int
+
+const (
+	// Error codes carried by MessageTypeError messages
+	ErrorCodeBadRequest ErrorCode = iota + 1
+	ErrorCodeUnauthorized
+	ErrorCodeNotFound
+	ErrorCodeInternal
+	ErrorCodeBusy // All workers are busy, the message can be retried later
 )
 
-// Message represents a P2P network message
+// PeerError is the payload of a MessageTypeError message. Handlers can
+// return a *PeerError to have it sent back to the originating peer.
+type PeerError struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+// Error implements the error interface
+func (e *PeerError) Error() string {
+	return fmt.Sprintf("peer error (code %d): %s", e.Code, e.Message)
+}
+
+// ErrPeerClosed is returned when sending to a peer whose connection is closed
+var ErrPeerClosed = errors.New("peer connection is closed")
+
+// ErrMaxPeers is returned when connecting to a peer would exceed MaxPeers
+var ErrMaxPeers = errors.New("maximum number of connected peers reached")
+
+// Handshake is the payload of a handshake message, sent by both sides
+// right after a connection is established
+type Handshake struct {
+	NodeID string `json:"id"`
+}
+
+// Message represents a P2P network message. Responses carry the request
+// ID of the message they answer.
 type Message struct {
-	Type    MessageType `json:"type"`
-	Payload []byte      `json:"payload"`
+	Type      MessageType `json:"type"`
+	Payload   []byte      `json:"payload"`
+	RequestID string      `json:"requestId,omitempty"`
 }
 
 // MessageHandler is a function that handles a message from a peer
@@ -74,41 +195,121 @@ type MessageHandler func(peer *Peer, msg *Message) error
 
 // NewP2PNetwork creates a new P2P network
 func NewP2PNetwork(options P2POptions, nodeManager *NodeManager) *P2PNetwork {
+	// Fall back to the default handler limit if none is configured
+	if options.MaxConnHandlers <= 0 {
+		options.MaxConnHandlers = DefaultP2POptions().MaxConnHandlers
+	}
+	if options.ConnectTimeout <= 0 {
+		options.ConnectTimeout = DefaultP2POptions().ConnectTimeout
+	}
+	if options.PeerRetention <= 0 {
+		options.PeerRetention = DefaultP2POptions().PeerRetention
+	}
+	if options.MessageWorkers <= 0 {
+		options.MessageWorkers = DefaultP2POptions().MessageWorkers
+	}
+	if options.MaxDiscoveryPeers <= 0 {
+		options.MaxDiscoveryPeers = DefaultP2POptions().MaxDiscoveryPeers
+	}
+	if options.DiscoveryTTL <= 0 {
+		options.DiscoveryTTL = DefaultP2POptions().DiscoveryTTL
+	}
+	if options.BreakerThreshold <= 0 {
+		options.BreakerThreshold = DefaultP2POptions().BreakerThreshold
+	}
+	if options.BreakerCooldown <= 0 {
+		options.BreakerCooldown = DefaultP2POptions().BreakerCooldown
+	}
+	if options.MaxMessageSize <= 0 {
+		options.MaxMessageSize = DefaultP2POptions().MaxMessageSize
+	}
+	if options.ReconnectMin <= 0 {
+		options.ReconnectMin = DefaultP2POptions().ReconnectMin
+	}
+	if options.ReconnectMax < options.ReconnectMin {
+		options.ReconnectMax = max(DefaultP2POptions().ReconnectMax, options.ReconnectMin)
+	}
+	if options.TransferTimeout <= 0 {
+		options.TransferTimeout = DefaultP2POptions().TransferTimeout
+	}
+	if options.NodeID == "" {
+		options.NodeID = uuid.New().String()
+	}
+	if options.Logger == nil {
+		options.Logger = slog.Default()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
 	return &P2PNetwork{
-		options:     options,
-		peers:       make(map[string]*Peer),
-		mu:          sync.RWMutex{},
-		handlers:    make(map[MessageType]MessageHandler),
-		isRunning:   false,
-		nodeManager: nodeManager,
+		options:       options,
+		logger:        options.Logger,
+		peers:         make(map[string]*Peer),
+		mu:            sync.RWMutex{},
+		handlers:      make(map[MessageType]MessageHandler),
+		isRunning:     false,
+		nodeManager:   nodeManager,
+		connSlots:     make(chan struct{}, options.MaxConnHandlers),
+		workers:       make(chan struct{}, options.MessageWorkers),
+		discovered:    make(map[string]time.Time),
+		breaker:       newCircuitBreaker(options.BreakerThreshold, options.BreakerCooldown),
+		persistent:    make(map[string]*reconnectState),
+		reconnectWake: make(chan struct{}, 1),
+		pending:       make(map[string]*pendingRequest),
+		ctx:           ctx,
+		cancel:        cancel,
 	}
 }
 
 // Start starts the P2P network
 func (p *P2PNetwork) Start() error {
 	addr := fmt.Sprintf(":%d", p.options.Port)
+	if p.options.ListenAddr != "" {
+		addr = p.options.ListenAddr
+	}
+	if p.options.TLS != nil {
+		server, client, err := p.options.TLS.tlsConfigs(p.options.NodeID)
+		if err != nil {
+			return fmt.Errorf("failed to start P2P network: %w", err)
+		}
+		p.tlsServer, p.tlsClient = server, client
+	}
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		return fmt.Errorf("failed to start P2P network: %w", err)
 	}
 
+	p.mu.Lock()
 	p.listener = listener
 	p.isRunning = true
+	p.mu.Unlock()
 
 	// Start accepting connections
 	go p.acceptConnections()
+	go p.sweepPeers()
+	go p.reconnectPeers()
+	go p.keepAlive()
 
 	// Register default handlers
 	p.RegisterHandler(MessageTypePing, p.handlePing)
 	p.RegisterHandler(MessageTypePong, p.handlePong)
 	p.RegisterHandler(MessageTypeNodeDiscovery, p.handleNodeDiscovery)
 	p.RegisterHandler(MessageTypeNodeAnnouncement, p.handleNodeAnnouncement)
+	p.RegisterHandler(MessageTypeError, p.handleError)
+	p.RegisterHandler(MessageTypeHandshake, p.handleHandshake)
+	p.RegisterHandler(MessageTypeNodeRegistration, p.handleNodeRegistration)
+	p.RegisterHandler(MessageTypeFileRequest, p.handleFileRequest)
+	p.RegisterHandler(MessageTypeFileInfo, p.handleFileTransferMessage)
+	p.RegisterHandler(MessageTypeFileChunk, p.handleFileTransferMessage)
+	p.RegisterHandler(MessageTypeDeleteReplica, p.handleDeleteReplica)
 
 	return nil
 }
 
 // Stop stops the P2P network
 func (p *P2PNetwork) Stop() {
+	p.cancel()
+
 	if p.listener != nil {
 		p.listener.Close()
 	}
@@ -134,24 +335,58 @@ func (p *P2PNetwork) RegisterHandler(msgType MessageType, handler MessageHandler
 	p.handlers[msgType] = handler
 }
 
-// BroadcastMessage sends a message to all connected peers
-func (p *P2PNetwork) BroadcastMessage(msg *Message) {
-	p.mu.RLock()
-	defer p.mu.RUnlock()
+// BroadcastMessage sends a message to all connected peers and reports
+// which peers it reached. Peers a send fails on are disconnected and
+// flagged for the dead peer reaper.
+func (p *P2PNetwork) BroadcastMessage(msg *Message) (*BroadcastResult, error) {
+	encodedMsg, err := EncodeMessage(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode broadcast message type %d: %w", msg.Type, err)
+	}
 
+	// Send outside the lock so a slow peer doesn't block the network
+	p.mu.RLock()
+	peers := make([]*Peer, 0, len(p.peers))
 	for _, peer := range p.peers {
 		if peer.IsActive {
-			encodedMsg, err := EncodeMessage(msg)
-			if err != nil {
-				continue
-			}
-			peer.Send(encodedMsg)
+			peers = append(peers, peer)
+		}
+	}
+	p.mu.RUnlock()
+
+	result := &BroadcastResult{Peers: len(peers), Failed: []BroadcastFailure{}}
+	for _, peer := range peers {
+		if err := peer.Send(encodedMsg); err != nil {
+			p.peerLog(peer).Warn("error broadcasting message", "type", msg.Type.String(), "error", err)
+			p.flagDeliveryFailure(peer)
+			result.Failed = append(result.Failed, BroadcastFailure{PeerID: peer.ID, Address: peer.Address, Error: err.Error()})
+			continue
 		}
+		p.messages.countSent(msg.Type)
+		result.Delivered++
 	}
+
+	return result, nil
 }
 
 // ConnectToPeer connects to a peer at the given address
 func (p *P2PNetwork) ConnectToPeer(address string) (*Peer, error) {
+	return p.ConnectToPeerCtx(p.ctx, address)
+}
+
+// ConnectToPeerCtx connects to a peer at the given address, giving up when
+// ctx is done or the connect timeout passes, whichever comes first
+func (p *P2PNetwork) ConnectToPeerCtx(parent context.Context, address string) (*Peer, error) {
+	return p.connectToPeer(parent, address, true)
+}
+
+// connectToPeer connects to a peer. Without checkBreaker an open circuit
+// breaker doesn't fast-fail the connect, for callers with their own
+// backoff; the outcome is still recorded.
+func (p *P2PNetwork) connectToPeer(parent context.Context, address string, checkBreaker bool) (*Peer, error) {
+	ctx, cancel := context.WithTimeout(parent, p.options.ConnectTimeout)
+	defer cancel()
+
 	// Check if we're already connected to this peer
 	p.mu.RLock()
 	for _, existingPeer := range p.peers {
@@ -162,19 +397,39 @@ func (p *P2PNetwork) ConnectToPeer(address string) (*Peer, error) {
 	}
 	p.mu.RUnlock()
 
+	if p.atPeerLimit() {
+		return nil, fmt.Errorf("failed to connect to peer %s: %w", address, ErrMaxPeers)
+	}
+
+	// Fast-fail peers that keep failing until their cooldown has passed
+	if checkBreaker {
+		if err := p.breaker.allow(address); err != nil {
+			return nil, fmt.Errorf("failed to connect to peer %s: %w", address, err)
+		}
+	}
+
 	// Connect to the peer
-	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err == nil {
+		conn, err = p.clientTLS(ctx, conn)
+	}
+	if err == nil {
+		if err = ctx.Err(); err != nil {
+			conn.Close()
+		}
+	}
 	if err != nil {
+		// Only count failures of the peer, not cancellation by the caller
+		if parent.Err() != nil {
+			p.breaker.abort(address)
+		} else {
+			p.breaker.failure(address)
+		}
 		return nil, fmt.Errorf("failed to connect to peer %s: %w", address, err)
 	}
-
 	// Create the peer
-	peer := &Peer{
-		Address:    address,
-		Conn:       conn,
-		LastActive: time.Now(),
-		IsActive:   true,
-	}
+	peer := newPeer(address, conn, false)
 
 	// Start handling messages from the peer
 	go p.handleConnection(peer)
@@ -184,12 +439,70 @@ func (p *P2PNetwork) ConnectToPeer(address string) (*Peer, error) {
 	p.peers[address] = peer
 	p.mu.Unlock()
 
-	// Register the peer with the node manager
-	p.nodeManager.RegisterNode(peer.ID, address, 0)
+	// Exchange node IDs, the peer is registered with the node manager once
+	// its handshake arrives
+	err = p.sendHandshake(peer)
+	if err == nil {
+		select {
+		case <-peer.handshook:
+		case <-peer.closed:
+			err = errors.New("connection closed during handshake")
+		case <-ctx.Done():
+			err = fmt.Errorf("handshake timed out: %w", ctx.Err())
+		}
+	}
+	if err != nil {
+		conn.Close()
+		p.mu.Lock()
+		if p.peers[address] == peer {
+			delete(p.peers, address)
+		}
+		p.mu.Unlock()
+
+		if parent.Err() != nil {
+			p.breaker.abort(address)
+		} else {
+			p.breaker.failure(address)
+		}
+		return nil, fmt.Errorf("failed to connect to peer %s: %w", address, err)
+	}
+	p.breaker.success(address)
 
 	return peer, nil
 }
 
+// newPeer creates a peer for an established connection
+func newPeer(address string, conn net.Conn, inbound bool) *Peer {
+	return &Peer{
+		Address:    address,
+		Conn:       conn,
+		LastActive: time.Now(),
+		IsActive:   true,
+		inbound:    inbound,
+		handshook:  make(chan struct{}),
+		closed:     make(chan struct{}),
+	}
+}
+
+// atPeerLimit reports whether as many peers are connected as MaxPeers
+// allows
+func (p *P2PNetwork) atPeerLimit() bool {
+	if p.options.MaxPeers <= 0 {
+		return false
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	connected := 0
+	for _, peer := range p.peers {
+		if peer.IsActive {
+			connected++
+		}
+	}
+	return connected >= p.options.MaxPeers
+}
+
 // DisconnectPeer disconnects from a peer
 func (p *P2PNetwork) DisconnectPeer(peerID string) error {
 	p.mu.Lock()
@@ -210,6 +523,7 @@ func (p *P2PNetwork) DisconnectPeer(peerID string) error {
 			peerToRemove.Conn.Close()
 		}
 		delete(p.peers, peerToRemove.Address)
+		delete(p.persistent, peerToRemove.Address)
 		return nil
 	}
 
@@ -234,31 +548,68 @@ func (p *P2PNetwork) GetNodeID() string {
 	return p.options.NodeID
 }
 
-// GetPort returns the port this node is listening on
+// GetPort returns the port this node is listening on. Until it listens,
+// or when the listener has no TCP address, the configured port is returned.
 func (p *P2PNetwork) GetPort() int {
+	p.mu.RLock()
+	listener := p.listener
+	p.mu.RUnlock()
+
+	if listener != nil {
+		if addr, ok := listener.Addr().(*net.TCPAddr); ok {
+			return addr.Port
+		}
+	}
 	return p.options.Port
 }
 
+// ListenAddr returns the address the P2P network is configured to listen on
+func (p *P2PNetwork) ListenAddr() string {
+	if p.options.ListenAddr != "" {
+		return p.options.ListenAddr
+	}
+	return fmt.Sprintf(":%d", p.options.Port)
+}
+
 // acceptConnections accepts incoming connections
 func (p *P2PNetwork) acceptConnections() {
 	for p.isRunning {
 		conn, err := p.listener.Accept()
 		if err != nil {
-			if p.isRunning {
-				fmt.Printf("Error accepting connection: %v\n", err)
+			// Stop cancels the context before closing the listener
+			if p.ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
 			}
+			p.logger.Error("error accepting connection", "error", err)
+			continue
+		}
+
+		if p.atPeerLimit() {
+			p.logger.Warn("rejecting connection, too many connected peers", "peer", conn.RemoteAddr().String(), "limit", p.options.MaxPeers)
+			conn.Close()
+			continue
+		}
+
+		// Reserve a handler slot, rejecting the connection if none are free
+		select {
+		case p.connSlots <- struct{}{}:
+		default:
+			p.logger.Warn("rejecting connection, too many concurrent connections", "peer", conn.RemoteAddr().String(), "limit", p.options.MaxConnHandlers)
+			conn.Close()
 			continue
 		}
 
 		// Handle the connection in a separate goroutine
 		go func(c net.Conn) {
+			defer func() { <-p.connSlots }()
+
 			addr := c.RemoteAddr().String()
-			peer := &Peer{
-				Address:    addr,
-				Conn:       c,
-				LastActive: time.Now(),
-				IsActive:   true,
+			c, err := p.serverTLS(c)
+			if err != nil {
+				p.logger.Warn("rejecting connection", "peer", addr, "error", err)
+				return
 			}
+			peer := newPeer(addr, c, true)
 
 			p.mu.Lock()
 			p.peers[addr] = peer
@@ -269,6 +620,52 @@ func (p *P2PNetwork) acceptConnections() {
 	}
 }
 
+// sweepPeers periodically evicts peers that have been disconnected for
+// longer than the peer retention, until the network is stopped
+func (p *P2PNetwork) sweepPeers() {
+	interval := p.options.PeerRetention / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+			p.evictInactivePeers()
+		}
+	}
+}
+
+// evictInactivePeers removes peers that have been disconnected for longer
+// than the peer retention and marks their nodes inactive
+func (p *P2PNetwork) evictInactivePeers() int {
+	p.mu.Lock()
+	var evicted []*Peer
+	for addr, peer := range p.peers {
+		if _, reconnecting := p.persistent[addr]; reconnecting {
+			continue // Kept while it is being reconnected
+		}
+		if !peer.IsActive && time.Since(peer.LastActive) > p.options.PeerRetention {
+			delete(p.peers, addr)
+			evicted = append(evicted, peer)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, peer := range evicted {
+		if peer.ID != "" {
+			p.nodeManager.UpdateNodeStatus(peer.ID, "inactive")
+		}
+	}
+
+	return len(evicted)
+}
+
 // handleConnection handles messages from a peer
 func (p *P2PNetwork) handleConnection(peer *Peer) {
 	defer func() {
@@ -277,8 +674,13 @@ func (p *P2PNetwork) handleConnection(peer *Peer) {
 		if peer.Conn != nil {
 			peer.Conn.Close()
 		}
+		peer.writeMu.Lock()
 		peer.IsActive = false
+		peer.writeMu.Unlock()
+		peer.LastActive = time.Now() // Retention counts from the disconnect
 		p.mu.Unlock()
+		close(peer.closed)
+		p.publishPeerEvent(PeerDisconnected, peer)
 	}()
 
 	// Buffer for reading message length
@@ -289,7 +691,7 @@ func (p *P2PNetwork) handleConnection(peer *Peer) {
 		_, err := io.ReadFull(peer.Conn, lenBuf)
 		if err != nil {
 			if err != io.EOF {
-				fmt.Printf("Error reading message length from peer %s: %v\n", peer.Address, err)
+				p.peerLog(peer).Warn("error reading message length", "error", err)
 			}
 			return
 		}
@@ -297,63 +699,234 @@ func (p *P2PNetwork) handleConnection(peer *Peer) {
 		// Convert the length bytes to an integer
 		msgLen := binary.BigEndian.Uint32(lenBuf)
 
+		// Drop peers announcing oversized frames before allocating for them
+		if uint64(msgLen) > uint64(p.options.MaxMessageSize) {
+			p.peerLog(peer).Warn("dropping peer, message exceeds the size limit", "size", msgLen, "limit", p.options.MaxMessageSize)
+			return
+		}
+
 		// Read the message data
 		msgBuf := make([]byte, msgLen)
 		_, err = io.ReadFull(peer.Conn, msgBuf)
 		if err != nil {
-			fmt.Printf("Error reading message from peer %s: %v\n", peer.Address, err)
+			p.peerLog(peer).Warn("error reading message", "error", err)
 			return
 		}
+		peer.BytesReceived.Add(int64(len(lenBuf) + len(msgBuf)))
 
 		// Decode the message
 		msg, err := DecodeMessage(msgBuf)
 		if err != nil {
-			fmt.Printf("Error decoding message from peer %s: %v\n", peer.Address, err)
+			p.peerLog(peer).Warn("error decoding message", "error", err)
 			continue
 		}
 
 		// Update peer last active time
 		peer.LastActive = time.Now()
+		p.messages.countReceived(msg.Type)
 
-		// Handle the message
-		p.mu.RLock()
-		handler, exists := p.handlers[msg.Type]
-		p.mu.RUnlock()
+		// Responses go straight to the request waiting for them
+		if p.deliverResponse(peer, msg) {
+			continue
+		}
 
-		if exists {
-			if err := handler(peer, msg); err != nil {
-				fmt.Printf("Error handling message type %d from peer %s: %v\n", msg.Type, peer.Address, err)
+		// Data messages can take a long time to serve, so they run on the
+		// worker pool to keep pings and other control messages flowing.
+		// When every worker is busy they are turned away rather than
+		// stalling the read loop. Everything else is handled in order on
+		// the read loop.
+		if isDataMessage(msg.Type) {
+			select {
+			case p.workers <- struct{}{}:
+				go func(msg *Message) {
+					defer func() { <-p.workers }()
+					p.dispatch(peer, msg)
+				}(msg)
+			default:
+				p.replyError(peer, msg, ErrorCodeBusy, "all message workers are busy, try again later")
 			}
-		} else {
-			fmt.Printf("No handler registered for message type %d\n", msg.Type)
+			continue
 		}
+
+		p.dispatch(peer, msg)
+	}
+}
+
+// isDataMessage reports whether a message type is served on the worker pool
+func isDataMessage(msgType MessageType) bool {
+	return msgType == MessageTypeFileRequest
+}
+
+// dispatch runs the registered handler for a message
+func (p *P2PNetwork) dispatch(peer *Peer, msg *Message) {
+	p.mu.RLock()
+	handler, exists := p.handlers[msg.Type]
+	p.mu.RUnlock()
+
+	if !exists {
+		p.peerLog(peer).Warn("no handler registered for message", "type", msg.Type.String())
+		p.replyError(peer, msg, ErrorCodeBadRequest, fmt.Sprintf("unsupported message type %d", msg.Type))
+		return
+	}
+
+	if err := handler(peer, msg); err != nil {
+		p.peerLog(peer).Warn("error handling message", "type", msg.Type.String(), "error", err)
+
+		// Tell the peer why its message failed
+		var peerErr *PeerError
+		if errors.As(err, &peerErr) {
+			p.replyError(peer, msg, peerErr.Code, peerErr.Message)
+		}
+	}
+}
+
+// Heartbeat is the optional payload of ping and pong messages, carrying
+// the sender's view of its own capacity and status
+type Heartbeat struct {
+	NodeID      string `json:"id"`
+	StorageUsed int64  `json:"used"`
+	StorageMax  int64  `json:"max"`
+	Status      string `json:"status"`
+}
+
+// heartbeat encodes this node's heartbeat, or returns nil if the node
+// isn't registered with the node manager
+func (p *P2PNetwork) heartbeat() []byte {
+	self, err := p.nodeManager.GetNode(p.options.NodeID)
+	if err != nil {
+		return nil
+	}
+
+	payload, err := json.Marshal(Heartbeat{
+		NodeID:      self.ID,
+		StorageUsed: self.StorageUsed,
+		StorageMax:  self.StorageMax,
+		Status:      self.Status,
+	})
+	if err != nil {
+		return nil
+	}
+	return payload
+}
+
+// applyHeartbeat updates the node manager from a ping or pong. Peers that
+// send no payload only have their last seen time updated. Heartbeats are
+// ignored until the handshake identified the peer, and only ever update
+// the node it identified as.
+func (p *P2PNetwork) applyHeartbeat(peer *Peer, msg *Message) {
+	p.mu.RLock()
+	id := peer.ID
+	p.mu.RUnlock()
+
+	if id == "" {
+		return
+	}
+
+	var hb Heartbeat
+	if len(msg.Payload) > 0 {
+		if err := json.Unmarshal(msg.Payload, &hb); err != nil {
+			p.peerLog(peer).Warn("ignoring malformed heartbeat", "error", err)
+		}
+	}
+
+	p.nodeManager.HeartbeatNode(id)
+	if hb.NodeID == id {
+		p.nodeManager.ApplyHeartbeat(id, hb.StorageUsed, hb.StorageMax, hb.Status)
 	}
 }
 
 // handlePing handles ping messages
 func (p *P2PNetwork) handlePing(peer *Peer, msg *Message) error {
-	// Update the node's last seen time
+	p.applyHeartbeat(peer, msg)
+
+	// Send a pong response
+	return p.reply(peer, msg, MessageTypePong, p.heartbeat())
+}
+
+// handlePong handles pong messages
+func (p *P2PNetwork) handlePong(peer *Peer, msg *Message) error {
+	// Measure the round trip time of our last ping
+	p.mu.Lock()
+	if !peer.pingSent.IsZero() {
+		peer.Latency = time.Since(peer.pingSent)
+		peer.pingSent = time.Time{}
+	}
+	latency := peer.Latency
+	p.mu.Unlock()
+
+	// Update the node's last seen time, capacity and latency
+	p.applyHeartbeat(peer, msg)
 	if peer.ID != "" {
-		p.nodeManager.HeartbeatNode(peer.ID)
+		p.nodeManager.UpdateNodeLatency(peer.ID, latency)
 	}
+	return nil
+}
 
-	// Send a pong response
-	pongMsg := NewMessage(MessageTypePong, nil)
-	encodedMsg, err := EncodeMessage(pongMsg)
+// Ping sends a ping to a peer, its latency is measured when the pong arrives
+func (p *P2PNetwork) Ping(peer *Peer) error {
+	p.mu.Lock()
+	peer.pingSent = time.Now()
+	p.mu.Unlock()
+
+	return p.sendMessage(peer, NewMessage(MessageTypePing, p.heartbeat()))
+}
+
+// sendHandshake sends this node's ID to a peer
+func (p *P2PNetwork) sendHandshake(peer *Peer) error {
+	payload, err := json.Marshal(Handshake{NodeID: p.options.NodeID})
 	if err != nil {
 		return err
 	}
 
-	return peer.Send(encodedMsg)
+	return p.sendMessage(peer, NewMessage(MessageTypeHandshake, payload))
 }
 
-// handlePong handles pong messages
-func (p *P2PNetwork) handlePong(peer *Peer, msg *Message) error {
-	// Update the node's last seen time
+// handleHandshake sets a peer's ID from its handshake and registers it with
+// the node manager. Inbound peers get our handshake in reply.
+func (p *P2PNetwork) handleHandshake(peer *Peer, msg *Message) error {
+	var hs Handshake
+	if err := json.Unmarshal(msg.Payload, &hs); err != nil || hs.NodeID == "" {
+		return &PeerError{Code: ErrorCodeBadRequest, Message: "handshake must carry a node ID"}
+	}
+	if hs.NodeID == p.options.NodeID {
+		peer.Conn.Close()
+		return fmt.Errorf("peer %s is this node", peer.Address)
+	}
+	if err := p.checkCertificateNodeID(peer, hs.NodeID); err != nil {
+		peer.Conn.Close()
+		return fmt.Errorf("rejecting peer %s: %w", peer.Address, err)
+	}
+
+	p.mu.Lock()
 	if peer.ID != "" {
-		p.nodeManager.HeartbeatNode(peer.ID)
+		p.mu.Unlock()
+		return nil // Already identified
 	}
-	return nil
+	peer.ID = hs.NodeID
+	p.mu.Unlock()
+
+	if peer.inbound {
+		if err := p.sendHandshake(peer); err != nil {
+			return err
+		}
+	}
+
+	// Register the peer, keeping the address of nodes registered through
+	// the API rather than replacing it with the P2P address
+	if _, err := p.nodeManager.GetNode(hs.NodeID); err != nil {
+		p.nodeManager.RegisterNode(hs.NodeID, peer.Address, 0)
+	} else {
+		p.nodeManager.HeartbeatNode(hs.NodeID)
+	}
+
+	close(peer.handshook)
+	p.publishPeerEvent(PeerConnected, peer)
+
+	// Replicas the peer missed deleting while it was away can go now
+	go p.nodeManager.RetryPendingDeletes(hs.NodeID)
+
+	// Now that the peer knows who we are, announce our capacity
+	return p.sendRegistration(peer)
 }
 
 // handleNodeDiscovery handles node discovery messages
@@ -368,19 +941,21 @@ func (p *P2PNetwork) handleNodeDiscovery(peer *Peer, msg *Message) error {
 	}
 	p.mu.RUnlock()
 
+	// Send a random sample of peers rather than the whole list
+	if len(peerAddrs) > p.options.MaxDiscoveryPeers {
+		rand.Shuffle(len(peerAddrs), func(i, j int) {
+			peerAddrs[i], peerAddrs[j] = peerAddrs[j], peerAddrs[i]
+		})
+		peerAddrs = peerAddrs[:p.options.MaxDiscoveryPeers]
+	}
+
 	// Create response message
 	respPayload, err := json.Marshal(peerAddrs)
 	if err != nil {
 		return fmt.Errorf("failed to marshal peer list: %w", err)
 	}
 
-	responseMsg := NewMessage(MessageTypeNodeAnnouncement, respPayload)
-	encodedMsg, err := EncodeMessage(responseMsg)
-	if err != nil {
-		return fmt.Errorf("failed to encode node announcement: %w", err)
-	}
-
-	return peer.Send(encodedMsg)
+	return p.reply(peer, msg, MessageTypeNodeAnnouncement, respPayload)
 }
 
 // handleNodeAnnouncement handles node announcement messages
@@ -391,6 +966,8 @@ func (p *P2PNetwork) handleNodeAnnouncement(peer *Peer, msg *Message) error {
 		return fmt.Errorf("failed to unmarshal peer list: %w", err)
 	}
 
+	p.expireDiscovered()
+
 	// Connect to new peers
 	for _, addr := range peerAddrs {
 		// Skip connecting to ourselves
@@ -398,18 +975,84 @@ func (p *P2PNetwork) handleNodeAnnouncement(peer *Peer, msg *Message) error {
 			continue
 		}
 
+		// Skip addresses we are already connected to or trying to reach
+		p.mu.Lock()
+		_, pending := p.discovered[addr]
+		existing, connected := p.peers[addr]
+		if pending || (connected && existing.IsActive) {
+			p.mu.Unlock()
+			continue
+		}
+		p.discovered[addr] = time.Now()
+		p.mu.Unlock()
+
 		// Connect to the peer in a separate goroutine
 		go func(address string) {
 			_, err := p.ConnectToPeer(address)
 			if err != nil {
-				fmt.Printf("Failed to connect to discovered peer %s: %v\n", address, err)
+				p.logger.Warn("failed to connect to discovered peer", "peer", address, "error", err)
+				return
 			}
+
+			p.mu.Lock()
+			delete(p.discovered, address)
+			p.mu.Unlock()
 		}(addr)
 	}
 
 	return nil
 }
 
+// expireDiscovered drops discovered addresses that were never connected
+// within the discovery TTL
+func (p *P2PNetwork) expireDiscovered() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for addr, seen := range p.discovered {
+		if time.Since(seen) > p.options.DiscoveryTTL {
+			delete(p.discovered, addr)
+		}
+	}
+}
+
+// GetDiscoveredPeers returns discovered addresses that are not connected yet
+func (p *P2PNetwork) GetDiscoveredPeers() []string {
+	p.expireDiscovered()
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	addrs := make([]string, 0, len(p.discovered))
+	for addr := range p.discovered {
+		addrs = append(addrs, addr)
+	}
+
+	return addrs
+}
+
+// handleError handles error messages reported by a peer
+func (p *P2PNetwork) handleError(peer *Peer, msg *Message) error {
+	peerErr, err := DecodePeerError(msg)
+	if err != nil {
+		return err
+	}
+
+	p.peerLog(peer).Warn("peer reported error", "code", int(peerErr.Code), "error", peerErr.Message)
+	return nil
+}
+
+// replyError sends an error message with the given code to a peer in
+// response to one of its messages
+func (p *P2PNetwork) replyError(peer *Peer, req *Message, code ErrorCode, message string) error {
+	errMsg, err := NewErrorMessage(code, message)
+	if err != nil {
+		return err
+	}
+
+	return p.reply(peer, req, MessageTypeError, errMsg.Payload)
+}
+
 // isSelfAddress checks if an address is our own
 func (p *P2PNetwork) isSelfAddress(addr string) bool {
 	// Check if the address is our listener address
@@ -445,25 +1088,32 @@ func (p *P2PNetwork) isSelfAddress(addr string) bool {
 	return false
 }
 
-// Send sends data to the peer
+// Send sends data to the peer. A failed write closes the connection, which
+// ends the peer's read loop and marks it inactive.
 func (peer *Peer) Send(data []byte) error {
+	peer.writeMu.Lock()
+	defer peer.writeMu.Unlock()
+
 	if peer.Conn == nil || !peer.IsActive {
-		return fmt.Errorf("peer connection is closed")
+		return ErrPeerClosed
 	}
 
 	// Add length prefix to the data
 	lenBuf := make([]byte, 4)
 	binary.BigEndian.PutUint32(lenBuf, uint32(len(data)))
 
-	// Send the length prefix first
+	// Send the length prefix first, then the data
 	_, err := peer.Conn.Write(lenBuf)
+	if err == nil {
+		_, err = peer.Conn.Write(data)
+	}
 	if err != nil {
-		return err
+		peer.Conn.Close()
+		return fmt.Errorf("%w: %v", ErrPeerClosed, err)
 	}
+	peer.BytesSent.Add(int64(len(lenBuf) + len(data)))
 
-	// Send the data
-	_, err = peer.Conn.Write(data)
-	return err
+	return nil
 }
 
 // NewMessage creates a new message
@@ -474,6 +1124,28 @@ func NewMessage(msgType MessageType, payload []byte) *Message {
 	}
 }
 
+// NewErrorMessage creates a new error message with the given code
+func NewErrorMessage(code ErrorCode, message string) (*Message, error) {
+	payload, err := json.Marshal(&PeerError{Code: code, Message: message})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal error payload: %w", err)
+	}
+	return NewMessage(MessageTypeError, payload), nil
+}
+
+// DecodePeerError extracts the error payload from an error message
+func DecodePeerError(msg *Message) (*PeerError, error) {
+	if msg.Type != MessageTypeError {
+		return nil, fmt.Errorf("message type %d is not an error message", msg.Type)
+	}
+
+	var peerErr PeerError
+	if err := json.Unmarshal(msg.Payload, &peerErr); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal error payload: %w", err)
+	}
+	return &peerErr, nil
+}
+
 // EncodeMessage encodes a message to bytes
 func EncodeMessage(msg *Message) ([]byte, error) {
 	return json.Marshal(msg)
@@ -0,0 +1,238 @@
+package node
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// PlacementRequest describes where a file's replicas need to be placed
+type PlacementRequest struct {
+	Key      string            // File path or ID, used by hash based strategies
+	FileSize int64             // Size of the file in bytes
+	Replicas int               // Number of nodes to select
+	Existing []string          // Node IDs already holding the file
+	Labels   map[string]string // Labels the selected nodes must carry
+}
+
+// Reasons a node is passed over when placing a file
+const (
+	RejectInactive    = "inactive"
+	RejectFull        = "full"
+	RejectReserve     = "below-reserve" // Would eat into the free space reserve
+	RejectHoldsFile   = "already-holds-file"
+	RejectLabels      = "missing-labels"
+	RejectNotSelected = "not-selected" // Eligible, but the strategy preferred other nodes
+)
+
+// PlacedNode is a node selected to store a file
+type PlacedNode struct {
+	ID        string `json:"id"`
+	Address   string `json:"address"`
+	Available int64  `json:"available"` // Free space above the reserve in bytes
+}
+
+// RejectedNode is a node passed over when placing a file
+type RejectedNode struct {
+	ID     string `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// PlacementResult explains where a file is placed and why other nodes
+// were passed over
+type PlacementResult struct {
+	Selected []PlacedNode   `json:"selected"`
+	Rejected []RejectedNode `json:"rejected"`
+}
+
+// PlacementStrategy selects the nodes a file should be stored on. The
+// nodes passed in are already filtered down to active nodes with enough
+// free space that don't hold the file yet.
+type PlacementStrategy interface {
+	SelectNodes(nodes []*Node, req PlacementRequest) []string
+}
+
+// Placement strategy names
+const (
+	PlacementFreeSpace      = "free-space"
+	PlacementRoundRobin     = "round-robin"
+	PlacementConsistentHash = "consistent-hash"
+	PlacementLabelAware     = "label-aware"
+)
+
+// NewPlacementStrategy returns the placement strategy with the given name
+func NewPlacementStrategy(name string) (PlacementStrategy, error) {
+	switch name {
+	case PlacementFreeSpace, "":
+		return FreeSpacePlacement{}, nil
+	case PlacementRoundRobin:
+		return &RoundRobinPlacement{}, nil
+	case PlacementConsistentHash:
+		return ConsistentHashPlacement{VirtualNodes: 100}, nil
+	case PlacementLabelAware:
+		return LabelAwarePlacement{}, nil
+	default:
+		return nil, fmt.Errorf("unknown placement strategy: %s", name)
+	}
+}
+
+// FreeSpacePlacement picks the nodes with the most available space
+type FreeSpacePlacement struct{}
+
+// SelectNodes implements PlacementStrategy
+func (FreeSpacePlacement) SelectNodes(nodes []*Node, req PlacementRequest) []string {
+	sorted := make([]*Node, len(nodes))
+	copy(sorted, nodes)
+
+	sort.Slice(sorted, func(i, j int) bool { return hasMoreFreeSpace(sorted[i], sorted[j]) })
+
+	return firstNodeIDs(sorted, req.Replicas)
+}
+
+// hasMoreFreeSpace orders nodes by available space (descending). Ties go
+// to the less utilized node so placement spreads evenly, then to the
+// lower ID so the order is stable.
+func hasMoreFreeSpace(a, b *Node) bool {
+	aAvail, bAvail := a.StorageMax-a.StorageUsed, b.StorageMax-b.StorageUsed
+	if aAvail != bAvail {
+		return aAvail > bAvail
+	}
+	if aUsage, bUsage := usageRatio(a), usageRatio(b); aUsage != bUsage {
+		return aUsage < bUsage
+	}
+	return a.ID < b.ID
+}
+
+// usageRatio returns the share of a node's capacity in use, nodes without
+// capacity count as full
+func usageRatio(node *Node) float64 {
+	if node.StorageMax <= 0 {
+		return 1
+	}
+	return float64(node.StorageUsed) / float64(node.StorageMax)
+}
+
+// RoundRobinPlacement cycles through the nodes across successive calls
+type RoundRobinPlacement struct {
+	next int
+	mu   sync.Mutex
+}
+
+// SelectNodes implements PlacementStrategy
+func (rr *RoundRobinPlacement) SelectNodes(nodes []*Node, req PlacementRequest) []string {
+	if len(nodes) == 0 {
+		return []string{}
+	}
+
+	// Order by ID so the rotation is stable regardless of map iteration
+	sorted := make([]*Node, len(nodes))
+	copy(sorted, nodes)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	rr.mu.Lock()
+	start := rr.next % len(sorted)
+	rr.next++
+	rr.mu.Unlock()
+
+	resultCount := min(req.Replicas, len(sorted))
+	result := make([]string, resultCount)
+	for i := 0; i < resultCount; i++ {
+		result[i] = sorted[(start+i)%len(sorted)].ID
+	}
+
+	return result
+}
+
+// ConsistentHashPlacement maps the request key onto a hash ring of nodes,
+// so a file keeps its placement as long as its nodes stay in the cluster
+type ConsistentHashPlacement struct {
+	VirtualNodes int // Ring positions per node
+}
+
+// SelectNodes implements PlacementStrategy
+func (ch ConsistentHashPlacement) SelectNodes(nodes []*Node, req PlacementRequest) []string {
+	type ringEntry struct {
+		hash uint64
+		id   string
+	}
+
+	vnodes := ch.VirtualNodes
+	if vnodes <= 0 {
+		vnodes = 1
+	}
+
+	// Build the ring
+	ring := make([]ringEntry, 0, len(nodes)*vnodes)
+	for _, node := range nodes {
+		for v := 0; v < vnodes; v++ {
+			ring = append(ring, ringEntry{hash: hashKey(fmt.Sprintf("%s#%d", node.ID, v)), id: node.ID})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+
+	// Walk clockwise from the key's position collecting distinct nodes
+	resultCount := min(req.Replicas, len(nodes))
+	result := make([]string, 0, resultCount)
+	if resultCount == 0 {
+		return result
+	}
+
+	keyHash := hashKey(req.Key)
+	start := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= keyHash })
+	seen := make(map[string]bool)
+	for i := 0; len(result) < resultCount; i++ {
+		entry := ring[(start+i)%len(ring)]
+		if !seen[entry.id] {
+			seen[entry.id] = true
+			result = append(result, entry.id)
+		}
+	}
+
+	return result
+}
+
+// LabelAwarePlacement only picks nodes carrying all of the requested
+// labels, preferring those with the most available space
+type LabelAwarePlacement struct{}
+
+// SelectNodes implements PlacementStrategy
+func (LabelAwarePlacement) SelectNodes(nodes []*Node, req PlacementRequest) []string {
+	var matching []*Node
+	for _, node := range nodes {
+		if hasLabels(node, req.Labels) {
+			matching = append(matching, node)
+		}
+	}
+
+	return FreeSpacePlacement{}.SelectNodes(matching, req)
+}
+
+// hasLabels checks if a node carries all of the given labels
+func hasLabels(node *Node, labels map[string]string) bool {
+	for key, value := range labels {
+		if node.Labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// firstNodeIDs returns the IDs of the first n nodes
+func firstNodeIDs(nodes []*Node, n int) []string {
+	resultCount := min(n, len(nodes))
+	result := make([]string, resultCount)
+
+	for i := 0; i < resultCount; i++ {
+		result[i] = nodes[i].ID
+	}
+
+	return result
+}
+
+// hashKey hashes a string onto the consistent hash ring
+func hashKey(key string) uint64 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
@@ -0,0 +1,154 @@
+package node
+
+import (
+	"sort"
+)
+
+// RecordPlacement records the nodes holding replicas of a file
+func (nm *NodeManager) RecordPlacement(fileKey string, nodeIDs []string) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	if len(nodeIDs) == 0 {
+		delete(nm.placements, fileKey)
+		return
+	}
+
+	ids := make([]string, len(nodeIDs))
+	copy(ids, nodeIDs)
+	nm.placements[fileKey] = ids
+}
+
+// GetPlacement returns the nodes holding replicas of a file
+func (nm *NodeManager) GetPlacement(fileKey string) []string {
+	nm.mu.RLock()
+	defer nm.mu.RUnlock()
+
+	ids := make([]string, len(nm.placements[fileKey]))
+	copy(ids, nm.placements[fileKey])
+	return ids
+}
+
+// RemovePlacement forgets where a file's replicas are stored
+func (nm *NodeManager) RemovePlacement(fileKey string) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	delete(nm.placements, fileKey)
+	delete(nm.confirmed, fileKey)
+}
+
+// ConfirmReplica records that a node holds a copy of a file, e.g. after
+// the file was transferred from it
+func (nm *NodeManager) ConfirmReplica(fileKey, nodeID string) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	if containsID(nm.confirmed[fileKey], nodeID) {
+		return
+	}
+	nm.confirmed[fileKey] = append(nm.confirmed[fileKey], nodeID)
+}
+
+// SharesPlacement reports whether a node is in the recorded placement of a
+// file or was confirmed to hold a copy of it
+func (nm *NodeManager) SharesPlacement(fileKey, nodeID string) bool {
+	nm.mu.RLock()
+	defer nm.mu.RUnlock()
+
+	return containsID(nm.placements[fileKey], nodeID) || containsID(nm.confirmed[fileKey], nodeID)
+}
+
+// containsID reports whether a list of node IDs contains one
+func containsID(ids []string, nodeID string) bool {
+	for _, id := range ids {
+		if id == nodeID {
+			return true
+		}
+	}
+	return false
+}
+
+// FilesOnNode returns the keys of all files with a replica on a node
+func (nm *NodeManager) FilesOnNode(nodeID string) []string {
+	nm.mu.RLock()
+	defer nm.mu.RUnlock()
+
+	var files []string
+	for fileKey, ids := range nm.placements {
+		for _, id := range ids {
+			if id == nodeID {
+				files = append(files, fileKey)
+				break
+			}
+		}
+	}
+
+	sort.Strings(files)
+	return files
+}
+
+// replacePlacement swaps one node for another in a file's placement
+func (nm *NodeManager) replacePlacement(fileKey, oldID, newID string) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	ids := nm.placements[fileKey]
+	for i, id := range ids {
+		if id == oldID {
+			ids[i] = newID
+		}
+	}
+}
+
+// Replica health of a file
+const (
+	ReplicaHealthy         = "healthy"          // At least the wanted number of replicas is on active nodes
+	ReplicaUnderReplicated = "under-replicated" // Some replicas are missing or on nodes that are not active
+	ReplicaCritical        = "critical"         // At most one replica is left on an active node, fewer than wanted
+)
+
+// ReplicaLocation is a node holding a replica of a file
+type ReplicaLocation struct {
+	NodeID string `json:"nodeId"`
+	Status string `json:"status"` // Status of the node, "unknown" if it is not registered
+}
+
+// ReplicaReport describes where a file's replicas are and whether enough
+// of them are on active nodes
+type ReplicaReport struct {
+	Locations []ReplicaLocation `json:"locations"`
+	Wanted    int               `json:"wanted"`    // Replication factor of the file
+	Available int               `json:"available"` // Replicas on active nodes
+	Health    string            `json:"health"`
+}
+
+// ReplicaHealth reports the replica locations of a file from its recorded
+// placement, and its health given the number of replicas wanted
+func (nm *NodeManager) ReplicaHealth(fileKey string, wanted int) ReplicaReport {
+	nm.mu.RLock()
+	defer nm.mu.RUnlock()
+
+	report := ReplicaReport{Locations: []ReplicaLocation{}, Wanted: wanted}
+	for _, id := range nm.placements[fileKey] {
+		status := "unknown"
+		if node, exists := nm.nodes[id]; exists {
+			status = node.Status
+		}
+		if status == "active" {
+			report.Available++
+		}
+		report.Locations = append(report.Locations, ReplicaLocation{NodeID: id, Status: status})
+	}
+
+	switch {
+	case report.Available >= wanted:
+		report.Health = ReplicaHealthy
+	case report.Available <= 1:
+		report.Health = ReplicaCritical
+	default:
+		report.Health = ReplicaUnderReplicated
+	}
+
+	return report
+}
@@ -0,0 +1,84 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ReadPreference selects which replica serves a read
+type ReadPreference string
+
+const (
+	// ReadLocalFirst serves reads locally when this node holds a replica
+	ReadLocalFirst ReadPreference = "local-first"
+	// ReadLowestLatency serves reads from the replica with the lowest latency
+	ReadLowestLatency ReadPreference = "lowest-latency"
+	// ReadRoundRobin spreads reads across all replicas
+	ReadRoundRobin ReadPreference = "round-robin"
+)
+
+// ErrNoReplica is returned when a file has no replica on an active node
+var ErrNoReplica = errors.New("no active replica available")
+
+// ParseReadPreference validates a read preference name
+func ParseReadPreference(name string) (ReadPreference, error) {
+	switch pref := ReadPreference(name); pref {
+	case ReadLocalFirst, ReadLowestLatency, ReadRoundRobin:
+		return pref, nil
+	default:
+		return "", fmt.Errorf("unknown read preference: %s", name)
+	}
+}
+
+// SelectReadReplica picks the node a read of a file should be served from.
+// localID is the ID of this node, used by the local-first preference.
+// Placements are only a plan, so other nodes are only picked once they
+// were confirmed to hold a copy of the file.
+func (nm *NodeManager) SelectReadReplica(fileKey string, pref ReadPreference, localID string) (*Node, error) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	// Only consider replicas on active nodes
+	var candidates []*Node
+	for _, id := range nm.placements[fileKey] {
+		if id != localID && !containsID(nm.confirmed[fileKey], id) {
+			continue
+		}
+		if node, exists := nm.nodes[id]; exists && node.Status == "active" {
+			candidates = append(candidates, node)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, ErrNoReplica
+	}
+
+	switch pref {
+	case ReadLocalFirst:
+		for _, node := range candidates {
+			if node.ID == localID {
+				copied := *node
+				return &copied, nil
+			}
+		}
+		fallthrough
+	case ReadLowestLatency:
+		// Unknown latencies sort last
+		sort.SliceStable(candidates, func(i, j int) bool {
+			li, lj := candidates[i].Latency, candidates[j].Latency
+			if li == 0 || lj == 0 {
+				return lj == 0 && li != 0
+			}
+			return li < lj
+		})
+		copied := *candidates[0]
+		return &copied, nil
+	case ReadRoundRobin:
+		node := candidates[nm.readCounter%len(candidates)]
+		nm.readCounter++
+		copied := *node
+		return &copied, nil
+	default:
+		return nil, fmt.Errorf("unknown read preference: %s", pref)
+	}
+}
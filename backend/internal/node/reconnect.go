@@ -0,0 +1,170 @@
+package node
+
+import (
+	"sort"
+	"time"
+)
+
+// reconnectState tracks the reconnect attempts to a persistent peer
+type reconnectState struct {
+	failures   int       // Consecutive failed attempts
+	next       time.Time // Earliest time of the next attempt
+	connecting bool      // An attempt is in flight
+	connected  bool      // Connected at the last check
+}
+
+// ReconnectStatus describes a persistent peer address
+type ReconnectStatus struct {
+	Address     string     `json:"address"`
+	Connected   bool       `json:"connected"`
+	Failures    int        `json:"failures"`              // Consecutive failed reconnects
+	NextAttempt *time.Time `json:"nextAttempt,omitempty"` // Set while disconnected
+}
+
+// AddPersistentPeer keeps the network connected to address: it is dialed
+// right away and redialed with exponential backoff whenever the connection
+// is lost, until RemovePersistentPeer or DisconnectPeer.
+func (p *P2PNetwork) AddPersistentPeer(address string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, exists := p.persistent[address]; !exists {
+		p.persistent[address] = &reconnectState{}
+	}
+
+	// Wake the reconnect loop so the first attempt doesn't wait a tick
+	select {
+	case p.reconnectWake <- struct{}{}:
+	default:
+	}
+}
+
+// RemovePersistentPeer stops reconnecting to address. An open connection
+// is left alone.
+func (p *P2PNetwork) RemovePersistentPeer(address string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.persistent, address)
+}
+
+// PersistentPeers returns the reconnect status of the persistent peers
+func (p *P2PNetwork) PersistentPeers() []ReconnectStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	statuses := make([]ReconnectStatus, 0, len(p.persistent))
+	for address, state := range p.persistent {
+		status := ReconnectStatus{Address: address, Connected: state.connected, Failures: state.failures}
+		if !state.connected {
+			next := state.next
+			status.NextAttempt = &next
+		}
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Address < statuses[j].Address
+	})
+	return statuses
+}
+
+// reconnectBackoff returns the delay before the next attempt after the
+// given number of consecutive failures: ReconnectMin doubling up to
+// ReconnectMax
+func (p *P2PNetwork) reconnectBackoff(failures int) time.Duration {
+	delay := p.options.ReconnectMin
+	for i := 1; i < failures && delay < p.options.ReconnectMax; i++ {
+		delay *= 2
+	}
+	if delay > p.options.ReconnectMax {
+		delay = p.options.ReconnectMax
+	}
+	return delay
+}
+
+// reconnectPeers redials persistent peers that lost their connection,
+// until the network is stopped
+func (p *P2PNetwork) reconnectPeers() {
+	interval := p.options.ReconnectMin / 2
+	if interval > time.Second {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-p.reconnectWake:
+		}
+
+		for _, address := range p.duePeers(time.Now()) {
+			go p.reconnect(address)
+		}
+	}
+}
+
+// duePeers marks the persistent peers that are disconnected and due for an
+// attempt as connecting and returns their addresses. A peer found newly
+// disconnected waits ReconnectMin before its first attempt.
+func (p *P2PNetwork) duePeers(now time.Time) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var due []string
+	for address, state := range p.persistent {
+		if state.connecting {
+			continue
+		}
+
+		peer, exists := p.peers[address]
+		if exists && peer.IsActive {
+			state.connected = true
+			state.failures = 0
+			continue
+		}
+		if state.connected {
+			state.connected = false
+			state.next = now.Add(p.options.ReconnectMin)
+		}
+
+		if now.Before(state.next) {
+			continue
+		}
+		state.connecting = true
+		due = append(due, address)
+	}
+	return due
+}
+
+// reconnect makes one attempt to connect to a persistent peer and
+// schedules the next one if it fails
+func (p *P2PNetwork) reconnect(address string) {
+	// The backoff already spaces out attempts, don't let the breaker's
+	// cooldown delay noticing the peer is back
+	_, err := p.connectToPeer(p.ctx, address, false)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state, exists := p.persistent[address]
+	if !exists {
+		return // Removed while connecting
+	}
+	state.connecting = false
+
+	if err != nil {
+		state.failures++
+		delay := p.reconnectBackoff(state.failures)
+		state.next = time.Now().Add(delay)
+		p.logger.Warn("reconnecting to peer failed", "peer", address, "attempt", state.failures, "retryIn", delay, "error", err)
+		return
+	}
+
+	state.connected = true
+	state.failures = 0
+}
@@ -0,0 +1,85 @@
+package node
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// NodeRegistration is the payload of a node registration message, sent
+// after the handshake so peers add the sender to their node manager
+// without a manual POST /api/nodes
+type NodeRegistration struct {
+	NodeID     string `json:"id"`
+	Address    string `json:"address"` // HTTP API address of the node
+	StorageMax int64  `json:"storageMax"`
+	Zone       string `json:"zone,omitempty"`
+	Signature  string `json:"signature,omitempty"` // HMAC-SHA256 with the cluster secret
+}
+
+// sign returns the signature of a registration under a cluster secret
+func (r NodeRegistration) sign(secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%d", r.NodeID, r.Address, r.StorageMax)
+	if r.Zone != "" {
+		fmt.Fprintf(mac, "\n%s", r.Zone) // Zoneless registrations keep their old signature
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sendRegistration announces this node's address and capacity to a peer.
+// Nodes without an advertised address don't register.
+func (p *P2PNetwork) sendRegistration(peer *Peer) error {
+	if p.options.AdvertiseAddr == "" {
+		return nil
+	}
+
+	reg := NodeRegistration{
+		NodeID:     p.options.NodeID,
+		Address:    p.options.AdvertiseAddr,
+		StorageMax: p.options.StorageMax,
+		Zone:       p.options.Zone,
+	}
+	if p.options.ClusterSecret != "" {
+		reg.Signature = reg.sign(p.options.ClusterSecret)
+	}
+
+	payload, err := json.Marshal(reg)
+	if err != nil {
+		return err
+	}
+
+	return p.sendMessage(peer, NewMessage(MessageTypeNodeRegistration, payload))
+}
+
+// handleNodeRegistration registers a peer with the node manager from its
+// self-registration. A peer can only register the node ID it identified
+// as in its handshake, and must sign the registration when a cluster
+// secret is configured.
+func (p *P2PNetwork) handleNodeRegistration(peer *Peer, msg *Message) error {
+	var reg NodeRegistration
+	if err := json.Unmarshal(msg.Payload, &reg); err != nil {
+		return &PeerError{Code: ErrorCodeBadRequest, Message: "malformed node registration"}
+	}
+
+	p.mu.RLock()
+	peerID := peer.ID
+	p.mu.RUnlock()
+
+	if peerID == "" || reg.NodeID != peerID {
+		return &PeerError{Code: ErrorCodeUnauthorized, Message: "nodes can only register the ID of their handshake"}
+	}
+	if p.options.ClusterSecret != "" && !hmac.Equal([]byte(reg.Signature), []byte(reg.sign(p.options.ClusterSecret))) {
+		return &PeerError{Code: ErrorCodeUnauthorized, Message: "invalid node registration signature"}
+	}
+	if reg.Address == "" || reg.StorageMax < 0 {
+		return &PeerError{Code: ErrorCodeBadRequest, Message: "node registration needs an address and a non-negative capacity"}
+	}
+
+	if _, _, err := p.nodeManager.RegisterNodeWithOptions(reg.NodeID, reg.Address, RegisterOptions{StorageMax: reg.StorageMax, Zone: reg.Zone}); err != nil {
+		return &PeerError{Code: ErrorCodeBadRequest, Message: err.Error()}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// registryFile is the on-disk format of the node registry
+type registryFile struct {
+	SavedAt time.Time `json:"savedAt"`
+	Nodes   []*Node   `json:"nodes"`
+}
+
+// LoadNodeManager creates a node manager whose registry is persisted to a
+// JSON file at path, loading the nodes already saved there. A missing file
+// starts an empty registry.
+func LoadNodeManager(path string) (*NodeManager, error) {
+	nm := NewNodeManager()
+	nm.registryPath = path
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nm, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read node registry: %w", err)
+	}
+
+	var registry registryFile
+	if err := json.Unmarshal(data, &registry); err != nil {
+		return nil, fmt.Errorf("failed to parse node registry %s: %w", path, err)
+	}
+
+	for _, node := range registry.Nodes {
+		if node == nil || node.ID == "" {
+			continue
+		}
+		nm.nodes[node.ID] = node
+		nm.nodeAddrs[node.Address] = node.ID
+	}
+
+	return nm, nil
+}
+
+// Save writes the node registry to its file if it changed since the last
+// save. The file is replaced atomically, so a crash mid-save leaves the
+// previous registry in place. Without a registry file Save does nothing.
+func (nm *NodeManager) Save() error {
+	nm.saveMu.Lock() // Keeps an older snapshot from overwriting a newer one
+	defer nm.saveMu.Unlock()
+
+	nm.mu.Lock()
+	if nm.registryPath == "" || !nm.dirty {
+		nm.mu.Unlock()
+		return nil
+	}
+	registry := registryFile{SavedAt: time.Now(), Nodes: make([]*Node, 0, len(nm.nodes))}
+	for _, node := range nm.nodes {
+		nodeCopy := *node
+		registry.Nodes = append(registry.Nodes, &nodeCopy)
+	}
+	nm.dirty = false
+	nm.mu.Unlock()
+
+	if err := writeFileAtomic(nm.registryPath, registry); err != nil {
+		nm.markDirty() // Try again on the next save
+		return err
+	}
+
+	return nil
+}
+
+// StartAutoSave saves the registry every interval while it has unsaved
+// changes, until Close is called
+func (nm *NodeManager) StartAutoSave(interval time.Duration) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	if interval <= 0 || nm.stopSave != nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	nm.stopSave = stop
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := nm.Save(); err != nil {
+					nm.log().Error("error saving node registry", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// Close stops the background work of the node manager and saves pending
+// registry changes
+func (nm *NodeManager) Close() error {
+	nm.Stop()
+
+	return nm.Save()
+}
+
+// markDirty records that the registry has changes to save
+func (nm *NodeManager) markDirty() {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	nm.dirty = true
+}
+
+// writeFileAtomic writes v as JSON to a temporary file next to path and
+// renames it over path
+func writeFileAtomic(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to save node registry: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save node registry: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save node registry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save node registry: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to save node registry: %w", err)
+	}
+	return nil
+}
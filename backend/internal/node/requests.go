@@ -0,0 +1,143 @@
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrRequestTimeout is returned when a peer doesn't answer a request in time
+var ErrRequestTimeout = errors.New("request timed out")
+
+// pendingRequest is a request waiting for its responses. Responses are
+// handed from the peer's read loop to the requester.
+type pendingRequest struct {
+	id        string
+	peer      *Peer
+	responses chan *Message
+	done      chan struct{} // Closed when the requester stops waiting
+}
+
+// SendRequest sends a message to a peer and waits for the reply carrying
+// the same request ID. The message gets a request ID if it has none.
+// Error replies are returned as a *PeerError.
+func (p *P2PNetwork) SendRequest(peer *Peer, msg *Message, timeout time.Duration) (*Message, error) {
+	req, err := p.openRequest(peer, msg, 1)
+	if err != nil {
+		return nil, err
+	}
+	defer p.closeRequest(req)
+
+	return p.nextResponse(req, timeout)
+}
+
+// openRequest registers a request and sends it. buffer is how many
+// responses may queue up before the peer's read loop waits for the
+// requester.
+func (p *P2PNetwork) openRequest(peer *Peer, msg *Message, buffer int) (*pendingRequest, error) {
+	if msg.RequestID == "" {
+		msg.RequestID = uuid.New().String()
+	}
+
+	req := &pendingRequest{
+		id:        msg.RequestID,
+		peer:      peer,
+		responses: make(chan *Message, buffer),
+		done:      make(chan struct{}),
+	}
+
+	p.mu.Lock()
+	if _, exists := p.pending[req.id]; exists {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("request %s is already pending", req.id)
+	}
+	p.pending[req.id] = req
+	p.mu.Unlock()
+
+	if err := p.sendMessage(peer, msg); err != nil {
+		p.closeRequest(req)
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// closeRequest unregisters a request and releases the peer's read loop if
+// it is waiting to hand over a response
+func (p *P2PNetwork) closeRequest(req *pendingRequest) {
+	p.mu.Lock()
+	if p.pending[req.id] == req {
+		delete(p.pending, req.id)
+	}
+	p.mu.Unlock()
+
+	close(req.done)
+}
+
+// nextResponse waits for the next response to a request
+func (p *P2PNetwork) nextResponse(req *pendingRequest, timeout time.Duration) (*Message, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case msg := <-req.responses:
+		if msg.Type == MessageTypeError {
+			peerErr, err := DecodePeerError(msg)
+			if err != nil {
+				return nil, err
+			}
+			return nil, peerErr
+		}
+		return msg, nil
+	case <-req.peer.closed:
+		return nil, ErrPeerClosed
+	case <-timer.C:
+		return nil, fmt.Errorf("%w: peer %s sent no reply to request %s within %v", ErrRequestTimeout, req.peer.Address, req.id, timeout)
+	case <-p.ctx.Done():
+		return nil, p.ctx.Err()
+	}
+}
+
+// deliverResponse hands a message to the request from the same peer it
+// answers, and reports whether there was one. Messages answering no
+// pending request are dispatched to the handlers as usual.
+func (p *P2PNetwork) deliverResponse(peer *Peer, msg *Message) bool {
+	if msg.RequestID == "" {
+		return false
+	}
+
+	p.mu.RLock()
+	req, exists := p.pending[msg.RequestID]
+	p.mu.RUnlock()
+
+	if !exists || req.peer != peer {
+		return false
+	}
+
+	select {
+	case req.responses <- msg:
+	case <-req.done:
+	}
+	return true
+}
+
+// reply sends a response to a request message, echoing its request ID
+func (p *P2PNetwork) reply(peer *Peer, req *Message, msgType MessageType, payload []byte) error {
+	msg := NewMessage(msgType, payload)
+	msg.RequestID = req.RequestID
+
+	return p.sendMessage(peer, msg)
+}
+
+// replyJSON sends a response with a JSON encoded payload to a request
+func (p *P2PNetwork) replyJSON(peer *Peer, req *Message, msgType MessageType, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return p.reply(peer, req, msgType, data)
+}
@@ -0,0 +1,81 @@
+package node
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// StorageReserve is free space kept back on every node for the OS, logs and
+// other headroom. A node is considered full once its free space drops to
+// the reserve, even if StorageMax isn't reached.
+type StorageReserve struct {
+	Bytes   int64   `json:"bytes"`   // Absolute reserve
+	Percent float64 `json:"percent"` // Reserve as a percentage of StorageMax
+}
+
+// ParseStorageReserve parses a reserve given as a byte count ("1073741824")
+// or a percentage of each node's capacity ("10%")
+func ParseStorageReserve(value string) (StorageReserve, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return StorageReserve{}, nil
+	}
+
+	if percent, ok := strings.CutSuffix(value, "%"); ok {
+		p, err := strconv.ParseFloat(percent, 64)
+		if err != nil || p < 0 || p > 100 {
+			return StorageReserve{}, fmt.Errorf("invalid reserve percentage: %s", value)
+		}
+		return StorageReserve{Percent: p}, nil
+	}
+
+	bytes, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || bytes < 0 {
+		return StorageReserve{}, fmt.Errorf("invalid reserve size: %s", value)
+	}
+	return StorageReserve{Bytes: bytes}, nil
+}
+
+// Reserved returns the bytes reserved on a node with the given capacity
+func (r StorageReserve) Reserved(storageMax int64) int64 {
+	reserved := r.Bytes
+	if r.Percent > 0 {
+		reserved = int64(float64(storageMax) * r.Percent / 100)
+	}
+	if reserved > storageMax {
+		reserved = storageMax
+	}
+	return reserved
+}
+
+// UsableCapacity returns the part of a node's capacity files may be placed in
+func (r StorageReserve) UsableCapacity(storageMax int64) int64 {
+	return storageMax - r.Reserved(storageMax)
+}
+
+// SetStorageReserve sets the free space kept back on every node
+func (nm *NodeManager) SetStorageReserve(reserve StorageReserve) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	nm.reserve = reserve
+}
+
+// StorageReserve returns the free space kept back on every node
+func (nm *NodeManager) StorageReserve() StorageReserve {
+	nm.mu.RLock()
+	defer nm.mu.RUnlock()
+
+	return nm.reserve
+}
+
+// usableFree returns the space left on a node before it reaches the reserve.
+// Callers must hold nm.mu.
+func (nm *NodeManager) usableFree(node *Node) int64 {
+	free := nm.reserve.UsableCapacity(node.StorageMax) - node.StorageUsed
+	if free < 0 {
+		return 0
+	}
+	return free
+}
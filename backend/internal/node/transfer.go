@@ -0,0 +1,297 @@
+package node
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/user/distfs/internal/fs"
+)
+
+// FileRequest is the payload of a file request message. Files are looked
+// up by DFS path, or by file ID among the files this node has chunked.
+type FileRequest struct {
+	Path   string `json:"path,omitempty"`
+	FileID string `json:"fileId,omitempty"`
+}
+
+// FileManifest is the payload of a file info message, sent in reply to a
+// file request ahead of the file's chunks
+type FileManifest struct {
+	FileID string         `json:"fileId"`
+	Path   string         `json:"path,omitempty"`
+	Size   int64          `json:"size"`
+	Chunks []fs.ChunkInfo `json:"chunks"`
+}
+
+// FileChunk is the payload of a file chunk message
+type FileChunk struct {
+	FileID  string `json:"fileId"`
+	ChunkID string `json:"chunkId"`
+	Index   int    `json:"index"`
+	Data    []byte `json:"data"`
+}
+
+// fileTransferBuffer is how many messages of a transfer may queue up
+// before the peer's read loop waits for the requester
+const fileTransferBuffer = 16
+
+// SetFileSource sets where files requested by peers are read from.
+// Without a source every file request is answered with not found.
+func (p *P2PNetwork) SetFileSource(fileSystem *fs.DistributedFileSystem, chunker *fs.FileChunker) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.fileSystem = fileSystem
+	p.chunker = chunker
+}
+
+// RequestFile fetches a file by its file ID from a connected peer and
+// writes its content to out. Every chunk is checked against its hash.
+func (p *P2PNetwork) RequestFile(peerID, fileID string, out io.Writer) error {
+	return p.requestFile(peerID, FileRequest{FileID: fileID}, out)
+}
+
+// RequestFileByPath fetches a file by its DFS path from a connected peer
+// and writes its content to out
+func (p *P2PNetwork) RequestFileByPath(peerID, path string, out io.Writer) error {
+	return p.requestFile(peerID, FileRequest{Path: path}, out)
+}
+
+// requestFile sends a file request and reassembles the reply, which is
+// matched to the request by its request ID
+func (p *P2PNetwork) requestFile(peerID string, fileReq FileRequest, out io.Writer) error {
+	peer, err := p.activePeer(peerID)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(fileReq)
+	if err != nil {
+		return err
+	}
+	req, err := p.openRequest(peer, NewMessage(MessageTypeFileRequest, payload), fileTransferBuffer)
+	if err != nil {
+		return err
+	}
+	defer p.closeRequest(req)
+
+	// The manifest comes first
+	msg, err := p.nextResponse(req, p.options.TransferTimeout)
+	if err != nil {
+		return err
+	}
+	if msg.Type != MessageTypeFileInfo {
+		return fmt.Errorf("expected file info from peer %s, got message type %d", peerID, msg.Type)
+	}
+	var manifest FileManifest
+	if err := json.Unmarshal(msg.Payload, &manifest); err != nil {
+		return fmt.Errorf("malformed file info from peer %s: %w", peerID, err)
+	}
+
+	// Then the chunks, in order
+	var written int64
+	for i, chunkInfo := range manifest.Chunks {
+		msg, err := p.nextResponse(req, p.options.TransferTimeout)
+		if err != nil {
+			return err
+		}
+		if msg.Type != MessageTypeFileChunk {
+			return fmt.Errorf("expected chunk %d from peer %s, got message type %d", i, peerID, msg.Type)
+		}
+
+		var chunk FileChunk
+		if err := json.Unmarshal(msg.Payload, &chunk); err != nil {
+			return fmt.Errorf("malformed chunk from peer %s: %w", peerID, err)
+		}
+		if chunk.FileID != manifest.FileID || chunk.Index != i || chunk.ChunkID != chunkInfo.ID {
+			return fmt.Errorf("peer %s sent chunk %d of file %s out of order", peerID, chunk.Index, chunk.FileID)
+		}
+		hash := sha256.Sum256(chunk.Data)
+		if hex.EncodeToString(hash[:]) != chunk.ChunkID {
+			return fmt.Errorf("chunk %d of file %s from peer %s does not match its hash", i, manifest.FileID, peerID)
+		}
+
+		if _, err := out.Write(chunk.Data); err != nil {
+			return fmt.Errorf("failed to write chunk %d: %w", i, err)
+		}
+		written += int64(len(chunk.Data))
+	}
+
+	if written != manifest.Size {
+		return fmt.Errorf("received %d bytes of file %s from peer %s, expected %d", written, manifest.FileID, peerID, manifest.Size)
+	}
+
+	// The peer evidently holds the file
+	if fileReq.Path != "" {
+		p.nodeManager.ConfirmReplica(fileReq.Path, peerID)
+	}
+
+	return nil
+}
+
+// activePeer returns the connected peer with a node ID
+func (p *P2PNetwork) activePeer(peerID string) (*Peer, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, peer := range p.peers {
+		if peer.ID == peerID && peer.IsActive {
+			return peer, nil
+		}
+	}
+	return nil, fmt.Errorf("peer %s not connected", peerID)
+}
+
+// handleFileRequest replies to a file request with the file's manifest
+// followed by its chunks
+func (p *P2PNetwork) handleFileRequest(peer *Peer, msg *Message) error {
+	var req FileRequest
+	if err := json.Unmarshal(msg.Payload, &req); err != nil || (req.Path == "") == (req.FileID == "") {
+		return &PeerError{Code: ErrorCodeBadRequest, Message: "file request needs either a path or a file ID"}
+	}
+
+	p.mu.RLock()
+	fileSystem, chunker := p.fileSystem, p.chunker
+	p.mu.RUnlock()
+
+	if req.Path != "" {
+		return p.sendFileByPath(peer, msg, fileSystem, req.Path)
+	}
+
+	if chunker == nil {
+		return &PeerError{Code: ErrorCodeNotFound, Message: "this node does not serve files"}
+	}
+
+	manifest, err := chunkedFileManifest(chunker, req.FileID)
+	if errors.Is(err, os.ErrNotExist) {
+		return &PeerError{Code: ErrorCodeNotFound, Message: err.Error()}
+	}
+	if err != nil {
+		return &PeerError{Code: ErrorCodeInternal, Message: err.Error()}
+	}
+
+	if err := p.replyJSON(peer, msg, MessageTypeFileInfo, manifest); err != nil {
+		return err
+	}
+
+	for _, chunkInfo := range manifest.Chunks {
+		data, err := chunker.GetChunk(manifest.FileID, chunkInfo.ID)
+		if err != nil {
+			return &PeerError{Code: ErrorCodeInternal, Message: err.Error()}
+		}
+
+		err = p.replyJSON(peer, msg, MessageTypeFileChunk, FileChunk{
+			FileID:  manifest.FileID,
+			ChunkID: chunkInfo.ID,
+			Index:   chunkInfo.Index,
+			Data:    data,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// chunkedFileManifest looks up the chunks of a file this node has chunked
+func chunkedFileManifest(chunker *fs.FileChunker, fileID string) (*FileManifest, error) {
+	chunks, err := chunker.FileChunks(fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &FileManifest{FileID: fileID, Chunks: chunks}
+	for _, chunk := range chunks {
+		manifest.Size += int64(chunk.Size)
+	}
+
+	return manifest, nil
+}
+
+// sendFileByPath replies to a request for a file by its DFS path. The file
+// is streamed in DefaultChunkSize pieces, read once to hash them for the
+// manifest and once more to send them, so nothing is written to the chunk
+// store. The file ID is the hash of the whole content.
+func (p *P2PNetwork) sendFileByPath(peer *Peer, msg *Message, fileSystem *fs.DistributedFileSystem, path string) error {
+	if fileSystem == nil {
+		return &PeerError{Code: ErrorCodeNotFound, Message: "this node does not serve files"}
+	}
+
+	reader, err := fileSystem.DownloadFile(path)
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, fs.ErrIsDirectory) {
+		return &PeerError{Code: ErrorCodeNotFound, Message: err.Error()}
+	}
+	if err != nil {
+		return &PeerError{Code: ErrorCodeInternal, Message: err.Error()}
+	}
+	defer reader.Close()
+
+	file, ok := reader.(io.ReadSeeker)
+	if !ok {
+		return &PeerError{Code: ErrorCodeInternal, Message: fmt.Sprintf("file %s can't be streamed", path)}
+	}
+
+	manifest := &FileManifest{Path: path}
+	content := sha256.New()
+	buf := make([]byte, fs.DefaultChunkSize)
+	for index := 0; ; index++ {
+		n, err := io.ReadFull(file, buf)
+		if n > 0 {
+			content.Write(buf[:n])
+			hash := sha256.Sum256(buf[:n])
+			manifest.Chunks = append(manifest.Chunks, fs.ChunkInfo{ID: hex.EncodeToString(hash[:]), Index: index, Size: n})
+			manifest.Size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return &PeerError{Code: ErrorCodeInternal, Message: err.Error()}
+		}
+	}
+	manifest.FileID = hex.EncodeToString(content.Sum(nil))
+	for i := range manifest.Chunks {
+		manifest.Chunks[i].FileID = manifest.FileID
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return &PeerError{Code: ErrorCodeInternal, Message: err.Error()}
+	}
+	if err := p.replyJSON(peer, msg, MessageTypeFileInfo, manifest); err != nil {
+		return err
+	}
+
+	for _, chunkInfo := range manifest.Chunks {
+		data := buf[:chunkInfo.Size]
+		if _, err := io.ReadFull(file, data); err != nil {
+			return &PeerError{Code: ErrorCodeInternal, Message: err.Error()}
+		}
+		if hash := sha256.Sum256(data); hex.EncodeToString(hash[:]) != chunkInfo.ID {
+			return &PeerError{Code: ErrorCodeInternal, Message: fmt.Sprintf("file %s changed while it was sent", path)}
+		}
+
+		err := p.replyJSON(peer, msg, MessageTypeFileChunk, FileChunk{
+			FileID:  manifest.FileID,
+			ChunkID: chunkInfo.ID,
+			Index:   chunkInfo.Index,
+			Data:    data,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// handleFileTransferMessage rejects file info and chunk messages that
+// answer no pending file request
+func (p *P2PNetwork) handleFileTransferMessage(peer *Peer, msg *Message) error {
+	return fmt.Errorf("unexpected message type %d, no file transfer running", msg.Type)
+}
@@ -0,0 +1,80 @@
+package node
+
+import "errors"
+
+// RegisterOptions are the optional settings of a node registration
+type RegisterOptions struct {
+	StorageMax int64
+	Zone       string            // Failure domain (zone or rack), empty keeps the current one
+	Labels     map[string]string // nil keeps the current labels
+}
+
+// RegisterNodeWithOptions works like UpsertNode and also sets the node's
+// zone and labels
+func (nm *NodeManager) RegisterNodeWithOptions(id, address string, opts RegisterOptions) (*Node, bool, error) {
+	node, created, err := nm.UpsertNode(id, address, opts.StorageMax)
+	if err != nil {
+		return nil, false, err
+	}
+
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	if opts.Zone != "" {
+		node.Zone = opts.Zone
+	}
+	if opts.Labels != nil {
+		node.Labels = opts.Labels
+	}
+	nm.dirty = true
+
+	return node, created, nil
+}
+
+// SetNodeZone sets the failure domain of a node
+func (nm *NodeManager) SetNodeZone(id, zone string) error {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	node, exists := nm.nodes[id]
+	if !exists {
+		return errors.New("node not found")
+	}
+
+	node.Zone = zone
+	nm.dirty = true
+
+	return nil
+}
+
+// SetZoneSpread sets whether the replicas of a file are spread across
+// distinct zones before several go to the same zone
+func (nm *NodeManager) SetZoneSpread(enabled bool) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	nm.zoneSpread = enabled
+}
+
+// spreadAcrossZones picks n nodes from candidates in order of preference,
+// taking the first candidate of every zone before any zone gets a second
+// replica. Nodes without a zone count as a zone of their own.
+func spreadAcrossZones(candidates []*Node, n int) []string {
+	selected := make([]string, 0, min(n, len(candidates)))
+	taken := make([]bool, len(candidates))
+	for len(selected) < n && len(selected) < len(candidates) {
+		zones := make(map[string]bool)
+		for i, node := range candidates {
+			if len(selected) == n {
+				break
+			}
+			if taken[i] || (node.Zone != "" && zones[node.Zone]) {
+				continue
+			}
+			zones[node.Zone] = true
+			taken[i] = true
+			selected = append(selected, node.ID)
+		}
+	}
+	return selected
+}